@@ -25,7 +25,6 @@
 package cniovs
 
 import (
-	"crypto/rand"
 	_ "encoding/json"
 	"errors"
 	"fmt"
@@ -35,11 +34,13 @@ import (
 	"path/filepath"
 	"regexp"
 	_ "runtime"
+	"strconv"
 	"strings"
 
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 
 	"github.com/Billy99/user-space-net-plugin/cniovs/ovsdb"
+	"github.com/Billy99/user-space-net-plugin/usrspk8s"
 	"github.com/Billy99/user-space-net-plugin/usrsptypes"
 )
 
@@ -48,6 +49,9 @@ import (
 //
 const defaultCNIDir = "/var/lib/cni/vhostuser"
 const defaultOvsScript = "/usr/share/openvswitch/scripts/ovs-config.py"
+const defaultOvsBridge = "br0"
+const statusOvsTimeout = "2" // Seconds ovs-vsctl waits on ovsdb-server
+const dbgOvs = false
 
 //
 // Types
@@ -62,7 +66,9 @@ func (cniOvs CniOvs) AddOnHost(conf *usrsptypes.NetConf, containerID string, ipR
 	var err error
 	var data ovsdb.OvsSavedData
 
-	fmt.Printf("ENTER OVS CNI - ADD:\n")
+	if dbgOvs {
+		fmt.Fprintf(os.Stderr, "ENTER OVS CNI - ADD:\n")
+	}
 
 	//
 	// Create Local Interface
@@ -98,12 +104,25 @@ func (cniOvs CniOvs) AddOnHost(conf *usrsptypes.NetConf, containerID string, ipR
 		return err
 	}
 
-	fmt.Printf("EXIT OVS CNI - ADD:\n")
+	// Add the host side vhost-user port to the CNI result.
+	ipResult.Interfaces = append(ipResult.Interfaces, &current.Interface{
+		Name:       data.Vhostname,
+		Mac:        data.VhostMac,
+		Mtu:        conf.Mtu,
+		SocketPath: data.SocketFile,
+	})
+
+	if dbgOvs {
+		fmt.Fprintf(os.Stderr, "EXIT OVS CNI - ADD:\n")
+	}
 
 	return err
 }
 
 func (cniOvs CniOvs) AddOnContainer(conf *usrsptypes.NetConf, containerID string, ipResult *current.Result) error {
+	if conf.ConfigDelivery == "annotation" {
+		return usrspk8s.SaveRemoteConfigAnnotation(conf, ipResult, containerID)
+	}
 	return nil
 }
 
@@ -136,6 +155,22 @@ func (cniOvs CniOvs) DelFromHost(conf *usrsptypes.NetConf, containerID string) e
 }
 
 func (cniOvs CniOvs) DelFromContainer(conf *usrsptypes.NetConf, containerID string) error {
+	if conf.ConfigDelivery == "annotation" {
+		return usrspk8s.DeleteRemoteConfigAnnotation(conf)
+	}
+	return nil
+}
+
+// Status() - Report whether the local OVS instance can be reached and has
+//  the bridge vhost-user ports are added to. Used to answer CNI STATUS.
+func (cniOvs CniOvs) Status() error {
+	// Not run through execCommand(), which writes to stdout and would
+	// corrupt the CNI response.
+	cmd := exec.Command("ovs-vsctl", "--timeout="+statusOvsTimeout, "br-exists", defaultOvsBridge)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ERROR: OVS not reachable or bridge %s missing: %v %s", defaultOvsBridge, err, strings.TrimSpace(string(output)))
+	}
+
 	return nil
 }
 
@@ -145,21 +180,10 @@ func (cniOvs CniOvs) DelFromContainer(conf *usrsptypes.NetConf, containerID stri
 
 // execCommand Execute shell commands and return the output.
 func execCommand(cmd string, args []string) ([]byte, error) {
-	fmt.Printf("EXEC: %s\n", cmd)
-	return exec.Command(cmd, args...).Output()
-}
-
-func generateRandomMacAddress() string {
-	buf := make([]byte, 6)
-	if _, err := rand.Read(buf); err != nil {
-		return ""
+	if dbgOvs {
+		fmt.Fprintf(os.Stderr, "EXEC: %s\n", cmd)
 	}
-
-	// Set the local bit and make sure not MC address
-	macAddr := fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x",
-		(buf[0]|0x2)&0xfe, buf[1], buf[2],
-		buf[3], buf[4], buf[5])
-	return macAddr
+	return exec.Command(cmd, args...).Output()
 }
 
 func addLocalDeviceVhost(conf *usrsptypes.NetConf, containerID string, data *ovsdb.OvsSavedData) error {
@@ -167,32 +191,72 @@ func addLocalDeviceVhost(conf *usrsptypes.NetConf, containerID string, data *ovs
 	s := []string{containerID[:12], conf.If0name}
 	sockRef := strings.Join(s, "-")
 
-	sockDir := filepath.Join(defaultCNIDir, containerID)
-	if _, err := os.Stat(sockDir); err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(sockDir, 0700); err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
+	sockDir, err := conf.ResolveSocketDir(containerID)
+	if err != nil {
+		return err
+	}
+	if sockDir == "" {
+		sockDir = filepath.Join(defaultCNIDir, containerID)
 	}
 
+	// The directory of the container or the socketDir template is the
+	// pod's own, not one given by the runtime.
 	sockPath := filepath.Join(sockDir, sockRef)
+	perPod := true
+	if conf.RuntimeConfig.SocketPath != "" {
+		sockPath = conf.RuntimeConfig.SocketPath
+		perPod = false
+	}
+
+	socketDirOwned, err := conf.PrepareSocketDir(filepath.Dir(sockPath), perPod)
+	if err != nil {
+		return err
+	}
+
+	// Pass the resolved path on to the container.
+	conf.RuntimeConfig.SocketPath = sockPath
 
 	// ovs-vsctl add-port
 	cmd_args := []string{"create", sockPath}
 	if output, err := execCommand(defaultOvsScript, cmd_args); err == nil {
 		vhostName := strings.Replace(string(output), "\n", "", -1)
 
+		// Jumbo frames need the MTU set on the port, OVS defaults to 1500.
+		if conf.Mtu != 0 {
+			cmd_args = []string{"setmtu", vhostName, strconv.Itoa(conf.Mtu)}
+			if _, err = execCommand(defaultOvsScript, cmd_args); err != nil {
+				execCommand(defaultOvsScript, []string{"delete", vhostName})
+				return fmt.Errorf("ERROR: Unable to set MTU %d on %s: %v", conf.Mtu, vhostName, err)
+			}
+		}
+
 		cmd_args = []string{"getmac", vhostName}
-		if output, err := execCommand(defaultCNIDir, cmd_args); err == nil {
+		if output, err := execCommand(defaultOvsScript, cmd_args); err == nil {
 			data.VhostMac = strings.Replace(string(output), "\n", "", -1)
 		}
 
 		data.Vhostname = vhostName
 		data.Ifname = conf.If0name
-		data.IfMac = generateRandomMacAddress()
+		// Without a macPolicy, a MAC not provided is random.
+		if conf.ContainerConf.Mac == "" {
+			mac, err := usrsptypes.RandomMac()
+			if err != nil {
+				execCommand(defaultOvsScript, []string{"delete", vhostName})
+				return err
+			}
+			conf.ContainerConf.Mac = mac.String()
+		}
+		data.IfMac = conf.ContainerConf.Mac
+		data.ContainerId = containerID
+		data.NetName = conf.Name
+		data.SocketFile = sockPath
+		data.SocketDirOwned = socketDirOwned
+
+		// Make the socket usable by the configured uid, or back out.
+		if err = conf.SetSocketPermissions(sockPath); err != nil {
+			execCommand(defaultOvsScript, []string{"delete", vhostName})
+			return err
+		}
 	}
 
 	return nil
@@ -205,6 +269,19 @@ func delLocalDeviceVhost(conf *usrsptypes.NetConf, containerID string, data *ovs
 	if _, err := execCommand(defaultOvsScript, cmd_args); err == nil {
 		path := filepath.Join(defaultCNIDir, containerID)
 
+		// A socketPath override or socketDir template places the socket
+		// outside the container directory. Its directory is removed once
+		// empty, if it was created for the pod.
+		if data.SocketFile != "" && filepath.Dir(data.SocketFile) != path {
+			if err := os.Remove(data.SocketFile); err != nil && os.IsNotExist(err) == false {
+				return err
+			}
+			if data.SocketDirOwned {
+				os.Remove(filepath.Dir(data.SocketFile))
+			}
+			return nil
+		}
+
 		folder, err := os.Open(path)
 		if err != nil {
 			return err
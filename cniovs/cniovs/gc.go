@@ -0,0 +1,100 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module removes what the OVS UserSpace CNI implementation created for
+// containers that no longer exist, for example when the runtime never
+// called DEL after a node crash. It is used by CNI GC and userspace-ctl.
+//
+
+package cniovs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//
+// API Functions
+//
+
+// GarbageCollect() - Remove the OVS ports, socket directories and saved data
+//  of every container not in liveIDs. If netName is not empty, only ports
+//  created for that network are removed, and socket directories are only
+//  removed once the container has no port left in any network. Returns a
+//  description of each removed object.
+func (cniOvs CniOvs) GarbageCollect(netName string, liveIDs []string) ([]string, error) {
+	var removed []string
+
+	list, err := cniOvs.Inventory()
+	if err != nil {
+		return nil, err
+	}
+
+	// Containers that still own a port after this pass.
+	var inUse []string
+
+	for _, entry := range list {
+		containerId := entry.ContainerId()
+
+		if containerId == "" || isLive(containerId, liveIDs) ||
+			(netName != "" && (entry.Saved == nil || entry.Saved.NetName != netName)) {
+			if containerId != "" {
+				inUse = append(inUse, containerId)
+			}
+			continue
+		}
+
+		if err = cniOvs.RemoveInventoryEntry(entry); err != nil {
+			return removed, err
+		}
+		removed = append(removed, fmt.Sprintf("ovs port %s (container %s, socket %s)",
+			entry.Port, containerId, entry.SocketFile))
+	}
+
+	// Socket directories, /var/lib/cni/vhostuser/<ContainerId>
+	files, err := ioutil.ReadDir(defaultCNIDir)
+	if err != nil && os.IsNotExist(err) == false {
+		return removed, err
+	}
+	for _, file := range files {
+		if file.IsDir() == false || isLive(file.Name(), liveIDs) || isLive(file.Name(), inUse) {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(defaultCNIDir, file.Name())); err != nil {
+			return removed, err
+		}
+		removed = append(removed, "socket directory "+filepath.Join(defaultCNIDir, file.Name()))
+	}
+
+	return removed, nil
+}
+
+//
+// Local Functions
+//
+
+// The CNI only records the first 12 characters of the ContainerId in some
+// places, so compare on the shorter of the two.
+func isLive(containerId string, liveIDs []string) bool {
+	for _, liveId := range liveIDs {
+		if liveId != "" && (strings.HasPrefix(liveId, containerId) || strings.HasPrefix(containerId, liveId)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module compares the data saved by the OVS UserSpace CNI implementation
+// with the ports that actually exist in the local OVS instance. It is used
+// to inspect and repair node state (see userspace-ctl).
+//
+
+package cniovs
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Billy99/user-space-net-plugin/cniovs/ovsdb"
+)
+
+//
+// Types
+//
+
+// A vhost-user port created by the UserSpace CNI on the local OVS instance,
+// as recorded in the saved data and as reported by OVS. Either side may be
+// missing, in which case the entry is orphaned.
+type InventoryEntry struct {
+	Saved      *ovsdb.OvsSavedData // nil if the OVS port has no saved data.
+	Port       string              // OVS port name, "" if missing from OVS.
+	SocketFile string              // Socket file of the port, "" if unknown.
+}
+
+// Ports created by the CNI are named <ContainerId:12>-<If0name>.
+var portNameRegex = regexp.MustCompile("^[0-9a-f]{12}-.+$")
+
+// Returns the ContainerId (possibly only the first 12 characters) owning
+// the entry, "" if unknown.
+func (entry InventoryEntry) ContainerId() string {
+	if entry.Saved != nil {
+		return entry.Saved.ContainerId
+	}
+	if portNameRegex.MatchString(entry.Port) {
+		return entry.Port[:12]
+	}
+	return ""
+}
+
+// Returns true if the OVS port exists.
+func (entry InventoryEntry) InOvs() bool {
+	return entry.Port != ""
+}
+
+// Returns true if only one of the saved data and the OVS port exist.
+func (entry InventoryEntry) Orphaned() bool {
+	return entry.Saved == nil || entry.InOvs() == false
+}
+
+//
+// API Functions
+//
+
+// Inventory() - Return the vhost-user ports owned by the UserSpace CNI,
+//  pairing each saved entry with its OVS port.
+func (cniOvs CniOvs) Inventory() ([]InventoryEntry, error) {
+	var list []InventoryEntry
+
+	savedList, err := ovsdb.ListConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := execCommand(defaultOvsScript, []string{"list"})
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]bool)
+	for _, port := range strings.Fields(string(output)) {
+		ports[port] = true
+	}
+
+	for i := range savedList {
+		entry := InventoryEntry{
+			Saved:      &savedList[i],
+			SocketFile: savedList[i].SocketFile,
+		}
+
+		if ports[entry.Saved.Vhostname] {
+			entry.Port = entry.Saved.Vhostname
+			delete(ports, entry.Port)
+		}
+
+		list = append(list, entry)
+	}
+
+	// Any remaining port following the CNI naming convention was created
+	// by the CNI but has lost its saved data.
+	for port := range ports {
+		if portNameRegex.MatchString(port) {
+			entry := InventoryEntry{
+				Port: port,
+			}
+
+			// Socket directory is named after the full ContainerId.
+			matches, _ := filepath.Glob(filepath.Join(defaultCNIDir, port[:12]+"*", port))
+			if len(matches) == 1 {
+				entry.SocketFile = matches[0]
+			}
+
+			list = append(list, entry)
+		}
+	}
+
+	return list, nil
+}
+
+// RemoveInventoryEntry() - Delete whatever exists of the given entry: the
+//  OVS port, the socket file and the saved data.
+func (cniOvs CniOvs) RemoveInventoryEntry(entry InventoryEntry) error {
+
+	if entry.InOvs() {
+		if _, err := execCommand(defaultOvsScript, []string{"delete", entry.Port}); err != nil {
+			return err
+		}
+	}
+
+	if entry.SocketFile != "" {
+		if err := os.Remove(entry.SocketFile); err != nil && os.IsNotExist(err) == false {
+			return err
+		}
+
+		// Remove the container or pod directory if it is now empty.
+		dir := filepath.Dir(entry.SocketFile)
+		if filepath.Dir(dir) == filepath.Clean(defaultCNIDir) || (entry.Saved != nil && entry.Saved.SocketDirOwned) {
+			os.Remove(dir)
+		}
+	}
+
+	if entry.Saved != nil {
+		if err := ovsdb.DeleteConfig(entry.Saved.ContainerId, entry.Saved.Ifname); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -26,8 +26,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 
 	"github.com/Billy99/user-space-net-plugin/usrsptypes"
 )
@@ -37,6 +38,7 @@ import (
 //
 const defaultBaseCNIDir = "/var/run/ovs/cni"
 const defaultLocalCNIDir = "/var/run/ovs/cni/data"
+const debugOvsDb = false
 
 //
 // Types
@@ -49,6 +51,17 @@ type OvsSavedData struct {
 	VhostMac  string `json:"vhostmac"`  // Vhost port MAC address
 	Ifname    string `json:"ifname"`    // Interface name
 	IfMac     string `json:"ifmac"`     // Interface Mac address
+
+	// Used to match the saved data against the ports in OVS. Not present in
+	// data saved by older versions.
+	ContainerId string `json:"containerId,omitempty"` // Full ContainerId, file name only contains the first 12 characters.
+	NetName     string `json:"netName,omitempty"`     // Network name from the NetConf, used to scope CNI GC.
+	SocketFile  string `json:"socketFile,omitempty"`  // Vhost socket file
+
+	// The directory of SocketFile was created by the CNI for the pod, and is
+	// removed with its last socket. Other directories, like those of the
+	// runtime, are left in place.
+	SocketDirOwned bool `json:"socketDirOwned,omitempty"`
 }
 
 // This structure is used to pass additional data outside of the usrsptypes date into the container.
@@ -85,7 +98,9 @@ func SaveConfig(conf *usrsptypes.NetConf, containerID string, data *OvsSavedData
 
 		path := filepath.Join(sockDir, fileName)
 
-		fmt.Printf("SAVE FILE: path=%s dataBytes=%s\n", path, dataBytes)
+		if debugOvsDb {
+			fmt.Fprintf(os.Stderr, "SAVE FILE: path=%s dataBytes=%s\n", path, dataBytes)
+		}
 		return ioutil.WriteFile(path, dataBytes, 0644)
 	} else {
 		return fmt.Errorf("ERROR: serializing delegate VPP saved data: %v", err)
@@ -117,6 +132,58 @@ func LoadConfig(conf *usrsptypes.NetConf, containerID string, data *OvsSavedData
 	return nil
 }
 
+// ListConfig() - Return all the data saved by SaveConfig(). Unlike
+//  LoadConfig(), the files are left in place.
+func ListConfig() ([]OvsSavedData, error) {
+	var list []OvsSavedData
+
+	matches, err := filepath.Glob(filepath.Join(defaultLocalCNIDir, "local-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range matches {
+		var data OvsSavedData
+
+		dataBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return list, fmt.Errorf("ERROR: Failed to read OVS saved data: %v", err)
+		}
+		if err = json.Unmarshal(dataBytes, &data); err != nil {
+			return list, fmt.Errorf("ERROR: Failed to parse OVS saved data %s: %v", path, err)
+		}
+
+		// Fill in what can be recovered from the file name for data
+		// saved by older versions.
+		if data.ContainerId == "" {
+			name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "local-"), ".json")
+			if len(name) >= 14 && name[12] == '-' {
+				data.ContainerId = name[:12]
+				if data.Ifname == "" {
+					data.Ifname = name[13:]
+				}
+			}
+		}
+
+		list = append(list, data)
+	}
+
+	return list, nil
+}
+
+// DeleteConfig() - Remove the data saved by SaveConfig() for the given
+//  container and interface without reading it.
+func DeleteConfig(containerID string, ifName string) error {
+	if len(containerID) < 12 {
+		return fmt.Errorf("ERROR: Invalid ContainerId: %s", containerID)
+	}
+
+	fileName := fmt.Sprintf("local-%s-%s.json", containerID[:12], ifName)
+	path := filepath.Join(defaultLocalCNIDir, fileName)
+
+	return fileCleanup(defaultLocalCNIDir, path)
+}
+
 // This function deletes the input file (if provided) and the associated
 // directory (if provided) if the directory is empty.
 //  directory string - Directory file is located in, Use "" if directory
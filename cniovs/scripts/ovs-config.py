@@ -52,6 +52,13 @@ def deleteVhostPort(port):
 	cmd = 'ovs-vsctl --if-exists del-port br0 {}'.format(port)
 	return re.sub("\n\s*\n*", "", execCommand(cmd))
 
+def setVhostPortMtu(port, mtu):
+	'''Request the MTU of the specified Vhost User Port'''
+	cmd = 'ovs-vsctl set Interface {} mtu_request={}'.format(port, mtu)
+	if subprocess.call(cmd, shell=True) != 0:
+		sys.exit(1)
+	return mtu
+
 def getVhostPortMac(port):
 	'''Get MAC address of the specified Vhost User Port'''
 	cmd = 'ovs-ofctl show br0'
@@ -65,6 +72,11 @@ def getVhostPortMac(port):
 
 	return None
 
+def listVhostPorts():
+	'''List the ports on the OVS bridge, one per line'''
+	cmd = 'ovs-vsctl list-ports br0'
+	return execCommand(cmd).strip()
+
 def configVhostPortRoute(port, containerIP, containerMAC):
 	'''Setup Routing rules for the Vhost User port's client'''
 	# TODO
@@ -78,6 +90,10 @@ def main():
 		print createVhostPort(sys.argv[2])
 	elif sys.argv[1] == 'delete':
 		print deleteVhostPort(sys.argv[2])
+	elif sys.argv[1] == 'list':
+		print listVhostPorts()
+	elif sys.argv[1] == 'setmtu':
+		print setVhostPortMtu(sys.argv[2], sys.argv[3])
 	elif sys.argv[1] == 'getmac':
 		print getVhostPortMac(sys.argv[2])
 	elif sys.argv[1] == 'config':
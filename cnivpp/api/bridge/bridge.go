@@ -21,9 +21,13 @@ package vppbridge
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"git.fd.io/govpp.git/api"
 	"git.fd.io/govpp.git/core/bin_api/l2"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
 )
 
 //
@@ -31,6 +35,33 @@ import (
 //
 const debugBridge = false
 
+// BridgeDomainDump with this BdID returns all Bridge Domains.
+const allBridges = ^uint32(0)
+
+//
+// Types
+//
+
+// Bridge Domain as reported by VPP.
+type Bridge struct {
+	BdID         uint32
+	Flood        bool
+	UuFlood      bool
+	Forward      bool
+	Learn        bool
+	ArpTerm      bool
+	MacAge       uint8
+	BviSwIfIndex uint32
+	Tag          string
+	Members      []BridgeMember
+}
+
+// Interface attached to a Bridge Domain.
+type BridgeMember struct {
+	SwIfIndex uint32
+	Shg       uint8 // Split Horizon Group
+}
+
 //
 // API Functions
 //
@@ -48,7 +79,7 @@ func BridgeCompatibilityCheck(ch *api.Channel) error {
 	)
 	if err != nil {
 		if debugBridge {
-			fmt.Println("VPP memif failed compatibility")
+			fmt.Fprintln(os.Stderr, "VPP memif failed compatibility")
 		}
 	}
 
@@ -61,7 +92,7 @@ func CreateBridge(ch *api.Channel, bridgeDomain uint32) error {
 	exists, _ := findBridge(ch, bridgeDomain)
 	if exists {
 		if debugBridge {
-			fmt.Printf("Bridge Domain %d already exist, exit\n", bridgeDomain)
+			fmt.Fprintf(os.Stderr, "Bridge Domain %d already exist, exit\n", bridgeDomain)
 		}
 		return nil
 	}
@@ -82,10 +113,13 @@ func CreateBridge(ch *api.Channel, bridgeDomain uint32) error {
 	reply := &l2.BridgeDomainAddDelReply{}
 
 	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugBridge {
-			fmt.Println("Error creating bridge domain:", err)
+			fmt.Fprintln(os.Stderr, "Error creating bridge domain:", err)
 		}
 		return err
 	}
@@ -111,10 +145,13 @@ func DeleteBridge(ch *api.Channel, bridgeDomain uint32) error {
 	reply := &l2.BridgeDomainAddDelReply{}
 
 	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugBridge {
-			fmt.Println("Error deleting Bridge Domain:", err)
+			fmt.Fprintln(os.Stderr, "Error deleting Bridge Domain:", err)
 		}
 		return err
 	}
@@ -145,10 +182,13 @@ func AddBridgeInterface(ch *api.Channel, bridgeDomain uint32, swIfId uint32) err
 	reply := &l2.SwInterfaceSetL2BridgeReply{}
 
 	err = ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugBridge {
-			fmt.Println("Error adding interface to bridge domain:", err)
+			fmt.Fprintln(os.Stderr, "Error adding interface to bridge domain:", err)
 		}
 		return err
 	}
@@ -171,10 +211,13 @@ func RemoveBridgeInterface(ch *api.Channel, bridgeDomain uint32, swIfId uint32)
 	reply := &l2.SwInterfaceSetL2BridgeReply{}
 
 	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugBridge {
-			fmt.Println("Error removing interface from bridge domain:", err)
+			fmt.Fprintln(os.Stderr, "Error removing interface from bridge domain:", err)
 		}
 		return err
 	}
@@ -189,41 +232,49 @@ func RemoveBridgeInterface(ch *api.Channel, bridgeDomain uint32, swIfId uint32)
 	return err
 }
 
-// Dump the input Bridge data to Stdout. There is not VPP API to dump
-// all the Bridges.
-func DumpBridge(ch *api.Channel, bridgeDomain uint32) {
+// Return the set of existing Bridge Domains.
+func ListBridge(ch *api.Channel) ([]Bridge, error) {
+	return dumpBridge(ch, allBridges)
+}
 
-	// Populate the Message Structure
-	req := &l2.BridgeDomainDump{
-		BdID: bridgeDomain,
+// Return the given Bridge Domain, if it exists.
+func GetBridge(ch *api.Channel, bridgeDomain uint32) (bridge Bridge, found bool, err error) {
+
+	list, err := dumpBridge(ch, bridgeDomain)
+	if err != nil || len(list) == 0 {
+		return
 	}
 
-	reply := &l2.BridgeDomainDetails{}
+	return list[0], true, nil
+}
+
+// Dump the input Bridge data to the given writer.
+func DumpBridge(w io.Writer, ch *api.Channel, bridgeDomain uint32) {
 
-	err := ch.SendRequest(req).ReceiveReply(reply)
+	bridge, found, err := GetBridge(ch, bridgeDomain)
 
-	if err == nil {
-		fmt.Printf("    Bridge Domain %d: Fld=%d UuFld=%d Fwd=%d Lrn=%d Arp=%d Mac=%d Bvi=%d NSwId=%d BdTag=%s\n",
-			bridgeDomain,
-			reply.Flood,
-			reply.UuFlood,
-			reply.Forward,
-			reply.Learn,
-			reply.ArpTerm,
-			reply.MacAge,
-			reply.BviSwIfIndex,
-			reply.NSwIfs,
-			string(reply.BdTag))
-
-		if reply.NSwIfs != 0 {
-			for i := uint32(0); i < reply.NSwIfs; i++ {
-				fmt.Printf("      SwId=%d Shg=%d\n",
-					reply.SwIfDetails[i].SwIfIndex,
-					reply.SwIfDetails[i].Shg)
-			}
+	if err != nil {
+		fmt.Fprintf(w, "Error dumping Bridge Domain %d: %v\n", bridgeDomain, err)
+	} else if found {
+		fmt.Fprintf(w, "    Bridge Domain %d: Fld=%d UuFld=%d Fwd=%d Lrn=%d Arp=%d Mac=%d Bvi=%d NSwId=%d BdTag=%s\n",
+			bridge.BdID,
+			boolToInt(bridge.Flood),
+			boolToInt(bridge.UuFlood),
+			boolToInt(bridge.Forward),
+			boolToInt(bridge.Learn),
+			boolToInt(bridge.ArpTerm),
+			bridge.MacAge,
+			bridge.BviSwIfIndex,
+			len(bridge.Members),
+			bridge.Tag)
+
+		for _, member := range bridge.Members {
+			fmt.Fprintf(w, "      SwId=%d Shg=%d\n",
+				member.SwIfIndex,
+				member.Shg)
 		}
 	} else {
-		fmt.Printf("Bridge Domain %d does NOT Exist.\n", bridgeDomain)
+		fmt.Fprintf(w, "Bridge Domain %d does NOT Exist.\n", bridgeDomain)
 	}
 }
 
@@ -235,8 +286,26 @@ func DumpBridge(ch *api.Channel, bridgeDomain uint32) {
 // Return: true - Exists  false - otherwise
 //         uint32 - Number of associated interfaces
 func findBridge(ch *api.Channel, bridgeDomain uint32) (bool, uint32) {
-	var rval bool = false
-	var count uint32
+
+	bridge, found, err := GetBridge(ch, bridgeDomain)
+	if err != nil {
+		if debugBridge {
+			fmt.Fprintf(os.Stderr, "Error searching for Bridge Domain %d\n", bridgeDomain)
+		}
+		return false, 0
+	}
+	if found == false {
+		if debugBridge {
+			fmt.Fprintf(os.Stderr, "Bridge Domain %d does NOT exist\n", bridgeDomain)
+		}
+	}
+
+	return found, uint32(len(bridge.Members))
+}
+
+// Retrieve the given Bridge Domain, or all Bridge Domains if bridgeDomain
+// is allBridges.
+func dumpBridge(ch *api.Channel, bridgeDomain uint32) (list []Bridge, err error) {
 
 	// Populate the Message Structure
 	req := &l2.BridgeDomainDump{
@@ -244,28 +313,46 @@ func findBridge(ch *api.Channel, bridgeDomain uint32) (bool, uint32) {
 	}
 	reqCtx := ch.SendMultiRequest(req)
 
-	// BridgeDomainDump only returns one message, but if the Bridge Domain
-	// doesn't exist, no response is returned and Reply times out. So use
-	// SendMultiRequest to handle possible no response.
+	// If the Bridge Domain doesn't exist, no response is returned and Reply
+	// times out. So use SendMultiRequest to handle possible no response.
 	for {
 		reply := &l2.BridgeDomainDetails{}
-		stop, err := reqCtx.ReceiveReply(reply)
+		stop, replyErr := reqCtx.ReceiveReply(reply)
 		if stop {
-			if debugBridge {
-				fmt.Printf("Bridge Domain %d does NOT exist\n", bridgeDomain)
-			}
 			break // break out of the loop
-		} else if err != nil {
-			if debugBridge {
-				fmt.Printf("Error searching for Bridge Domain %d\n", bridgeDomain)
-			}
-			break // break out of the loop
-		} else {
-			count = reply.NSwIfs
+		}
+		if replyErr != nil {
+			err = replyErr
+			break
 		}
 
-		rval = true
+		bridge := Bridge{
+			BdID:         reply.BdID,
+			Flood:        reply.Flood != 0,
+			UuFlood:      reply.UuFlood != 0,
+			Forward:      reply.Forward != 0,
+			Learn:        reply.Learn != 0,
+			ArpTerm:      reply.ArpTerm != 0,
+			MacAge:       reply.MacAge,
+			BviSwIfIndex: reply.BviSwIfIndex,
+			Tag:          vppinfra.CString(reply.BdTag),
+		}
+		for i := uint32(0); i < reply.NSwIfs && int(i) < len(reply.SwIfDetails); i++ {
+			bridge.Members = append(bridge.Members, BridgeMember{
+				SwIfIndex: reply.SwIfDetails[i].SwIfIndex,
+				Shg:       reply.SwIfDetails[i].Shg,
+			})
+		}
+
+		list = append(list, bridge)
 	}
 
-	return rval, count
+	return
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,192 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vppbridge
+
+import (
+	"testing"
+
+	"git.fd.io/govpp.git/core/bin_api/l2"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/mock"
+)
+
+func bridgeDetails(bridgeDomain uint32, swIfIndexes ...uint32) *l2.BridgeDomainDetails {
+	reply := &l2.BridgeDomainDetails{
+		BdID:    bridgeDomain,
+		Flood:   1,
+		Learn:   1,
+		BdTag:   []byte("cni-bridge"),
+		NSwIfs:  uint32(len(swIfIndexes)),
+		Forward: 1,
+	}
+	for _, swIfIndex := range swIfIndexes {
+		reply.SwIfDetails = append(reply.SwIfDetails, l2.BridgeDomainSwIf{SwIfIndex: swIfIndex, Shg: 1})
+	}
+	return reply
+}
+
+func decodeAddDel(t *testing.T, vpp *vppmock.VPP) []*l2.BridgeDomainAddDel {
+	var list []*l2.BridgeDomainAddDel
+
+	for _, r := range vpp.Requests("bridge_domain_add_del") {
+		req := &l2.BridgeDomainAddDel{}
+		if err := r.Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, req)
+	}
+	return list
+}
+
+func TestAddBridgeInterfaceNewBridge(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	// bridge_domain_dump is not answered, as VPP does for a missing bridge.
+	vpp.Reply("bridge_domain_dump")
+	vpp.Reply("bridge_domain_add_del", &l2.BridgeDomainAddDelReply{})
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+
+	if err := AddBridgeInterface(vpp.Ch, 4, 7); err != nil {
+		t.Fatalf("AddBridgeInterface() failed: %v", err)
+	}
+
+	reqs := decodeAddDel(t, vpp)
+	if len(reqs) != 1 || reqs[0].IsAdd != 1 || reqs[0].BdID != 4 {
+		t.Errorf("bridge not created: %+v", reqs)
+	}
+
+	req := &l2.SwInterfaceSetL2Bridge{}
+	if err := vpp.Requests("sw_interface_set_l2_bridge")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.BdID != 4 || req.RxSwIfIndex != 7 || req.Enable != 1 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestAddBridgeInterfaceExistingBridge(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("bridge_domain_dump", bridgeDetails(4, 3))
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+
+	if err := AddBridgeInterface(vpp.Ch, 4, 7); err != nil {
+		t.Fatalf("AddBridgeInterface() failed: %v", err)
+	}
+	if reqs := vpp.Requests("bridge_domain_add_del"); len(reqs) != 0 {
+		t.Errorf("existing bridge was created again")
+	}
+}
+
+func TestRemoveBridgeInterfaceLastMember(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+	vpp.Reply("bridge_domain_dump", bridgeDetails(4))
+	vpp.Reply("bridge_domain_add_del", &l2.BridgeDomainAddDelReply{})
+
+	if err := RemoveBridgeInterface(vpp.Ch, 4, 7); err != nil {
+		t.Fatalf("RemoveBridgeInterface() failed: %v", err)
+	}
+
+	reqs := decodeAddDel(t, vpp)
+	if len(reqs) != 1 || reqs[0].IsAdd != 0 || reqs[0].BdID != 4 {
+		t.Errorf("empty bridge not deleted: %+v", reqs)
+	}
+}
+
+func TestRemoveBridgeInterfaceInUse(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+	vpp.Reply("bridge_domain_dump", bridgeDetails(4, 3))
+
+	if err := RemoveBridgeInterface(vpp.Ch, 4, 7); err != nil {
+		t.Fatalf("RemoveBridgeInterface() failed: %v", err)
+	}
+	if reqs := vpp.Requests("bridge_domain_add_del"); len(reqs) != 0 {
+		t.Errorf("bridge deleted while still in use")
+	}
+}
+
+func TestDeleteBridgeMissing(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("bridge_domain_dump")
+
+	if err := DeleteBridge(vpp.Ch, 4); err != nil {
+		t.Fatalf("DeleteBridge() failed: %v", err)
+	}
+	if reqs := vpp.Requests("bridge_domain_add_del"); len(reqs) != 0 {
+		t.Errorf("missing bridge was deleted")
+	}
+}
+
+func TestGetBridge(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("bridge_domain_dump", bridgeDetails(4, 3, 7))
+
+	bridge, found, err := GetBridge(vpp.Ch, 4)
+	if err != nil || found == false {
+		t.Fatalf("GetBridge() = %v, %v, want found", found, err)
+	}
+	if bridge.BdID != 4 || bridge.Tag != "cni-bridge" || bridge.Flood == false || bridge.UuFlood {
+		t.Errorf("unexpected bridge %+v", bridge)
+	}
+	if len(bridge.Members) != 2 || bridge.Members[0].SwIfIndex != 3 || bridge.Members[1].SwIfIndex != 7 {
+		t.Errorf("unexpected members %+v", bridge.Members)
+	}
+
+	req := &l2.BridgeDomainDump{}
+	if err = vpp.Requests("bridge_domain_dump")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.BdID != 4 {
+		t.Errorf("dumped BdID %d, want 4", req.BdID)
+	}
+}
+
+func TestBridgeRetval(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("bridge_domain_dump")
+	vpp.Reply("bridge_domain_add_del", &l2.BridgeDomainAddDelReply{Retval: vppinfra.VppErrUnspecified})
+	if err := CreateBridge(vpp.Ch, 4); vppinfra.IsVppError(err, vppinfra.VppErrUnspecified) == false {
+		t.Errorf("CreateBridge() = %v, want VPP error %d", err, vppinfra.VppErrUnspecified)
+	}
+
+	// The interface is not added to a bridge that failed to be created.
+	if err := AddBridgeInterface(vpp.Ch, 4, 7); err == nil {
+		t.Errorf("AddBridgeInterface() succeeded without a bridge")
+	}
+	if reqs := vpp.Requests("sw_interface_set_l2_bridge"); len(reqs) != 0 {
+		t.Errorf("interface added to a bridge that failed to be created")
+	}
+
+	vpp.Reply("bridge_domain_dump", bridgeDetails(4))
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{Retval: vppinfra.VppErrInvalidSwIfIndex})
+	if err := AddBridgeInterface(vpp.Ch, 4, 7); vppinfra.IsVppError(err, vppinfra.VppErrInvalidSwIfIndex) == false {
+		t.Errorf("AddBridgeInterface() = %v, want VPP error %d", err, vppinfra.VppErrInvalidSwIfIndex)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module converts the Retval of VPP replies into errors. Every wrapper
+// in the 'api' library checks the Retval with CheckRetval(), so callers can
+// branch on the VPP error code with IsVppError().
+//
+
+package vppinfra
+
+import (
+	"fmt"
+
+	"git.fd.io/govpp.git/api"
+)
+
+//
+// Constants
+//
+
+// VPP error codes, from vnet/api_errno.h.
+const (
+	VppErrUnspecified                 int32 = -1
+	VppErrInvalidSwIfIndex            int32 = -2
+	VppErrNoSuchFib                   int32 = -3
+	VppErrNoSuchEntry                 int32 = -6
+	VppErrInvalidValue                int32 = -7
+	VppErrInvalidValue2               int32 = -8
+	VppErrUnimplemented               int32 = -9
+	VppErrInvalidSwIfIndex2           int32 = -10
+	VppErrSyscallError1               int32 = -11
+	VppErrSyscallError10              int32 = -20
+	VppErrNoMatchingInterface         int32 = -54
+	VppErrAddressLengthMismatch       int32 = -59
+	VppErrAddressNotFoundForInterface int32 = -60
+	VppErrAddressNotDeletable         int32 = -61
+	VppErrIp6NotEnabled               int32 = -62
+	VppErrValueExist                  int32 = -81
+)
+
+var vppErrNames = map[int32]string{
+	VppErrUnspecified:                 "unspecified error",
+	VppErrInvalidSwIfIndex:            "invalid sw_if_index",
+	VppErrNoSuchFib:                   "no such FIB / VRF",
+	VppErrNoSuchEntry:                 "no such entry",
+	VppErrInvalidValue:                "invalid value",
+	VppErrInvalidValue2:               "invalid value #2",
+	VppErrUnimplemented:               "unimplemented",
+	VppErrInvalidSwIfIndex2:           "invalid sw_if_index #2",
+	VppErrNoMatchingInterface:         "no matching interface for probe address",
+	VppErrAddressLengthMismatch:       "address length mismatch",
+	VppErrAddressNotFoundForInterface: "address not found for interface",
+	VppErrAddressNotDeletable:         "address not deletable",
+	VppErrIp6NotEnabled:               "ip6 not enabled",
+	VppErrValueExist:                  "value already exists",
+}
+
+//
+// Types
+//
+
+// Returned when VPP answers a request with a non-zero Retval.
+type VppError struct {
+	Op     string // Name of the request that failed, like "memif_create".
+	Retval int32  // VPP error code, one of the VppErr values.
+}
+
+func (e *VppError) Error() string {
+	return fmt.Sprintf("VPP %s failed: %s (%d)", e.Op, e.Name(), e.Retval)
+}
+
+// Name() - Return the readable name of the VPP error code.
+func (e *VppError) Name() string {
+	if name, ok := vppErrNames[e.Retval]; ok {
+		return name
+	}
+	if e.Retval <= VppErrSyscallError1 && e.Retval >= VppErrSyscallError10 {
+		return fmt.Sprintf("system call error #%d", VppErrSyscallError1-e.Retval+1)
+	}
+	return "unknown error"
+}
+
+//
+// API Functions
+//
+
+// CheckRetval() - Return a VppError if the Retval of the reply to req is
+//  non-zero, nil otherwise.
+func CheckRetval(req api.Message, retval int32) error {
+	if retval == 0 {
+		return nil
+	}
+	return &VppError{Op: req.GetMessageName(), Retval: retval}
+}
+
+// IsVppError() - Return true if err is a VppError with the given code.
+func IsVppError(err error, retval int32) bool {
+	vppErr, ok := err.(*VppError)
+	return ok && vppErr.Retval == retval
+}
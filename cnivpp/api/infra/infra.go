@@ -20,7 +20,11 @@ package vppinfra
 //go:generate binapi-generator --input-dir=../../bin_api --output-dir=../../bin_api
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -34,6 +38,14 @@ import (
 //
 const debugInfra = false
 
+const (
+	defaultConnectTimeout = 5 * time.Second
+	defaultReplyTimeout   = 2 * time.Second
+	defaultConnectRetries = 5
+	defaultConnectBackoff = 250 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
 //
 // Types
 //
@@ -44,12 +56,50 @@ type ConnectionData struct {
 	closeFlag      bool
 }
 
+// Returned when a connection to VPP could not be established within the
+// configured number of attempts or timeout.
+type VppNotReachableError struct {
+	Err error // Error from the last connection attempt.
+}
+
+func (e *VppNotReachableError) Error() string {
+	return "VPP not reachable: " + e.Err.Error()
+}
+
+// ConnectOptions controls how long VppOpenChWithOptions() waits on VPP.
+type ConnectOptions struct {
+	ConnectTimeout time.Duration // Max time a single connection attempt may block.
+	ReplyTimeout   time.Duration // Max time to wait for the reply to each request on the Channel.
+	Retries        int           // Number of connection attempts before giving up.
+	Backoff        time.Duration // Delay after the first failed attempt, doubled after each failure.
+	MaxBackoff     time.Duration // Upper bound of the delay between attempts (0 = no bound).
+}
+
 //
 // API Functions
 //
 
+// Return the set of options used by VppOpenCh().
+func DefaultConnectOptions() ConnectOptions {
+	return ConnectOptions{
+		ConnectTimeout: defaultConnectTimeout,
+		ReplyTimeout:   defaultReplyTimeout,
+		Retries:        defaultConnectRetries,
+		Backoff:        defaultConnectBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+}
+
 // Open a Connection and Channel to VPP to allow communication to VPP.
 func VppOpenCh() (ConnectionData, error) {
+	return VppOpenChWithOptions(DefaultConnectOptions())
+}
+
+// Open a Connection and Channel to VPP, retrying with backoff while VPP is
+// starting up. Every request sent on the returned Channel times out after
+// opts.ReplyTimeout, so a hung VPP results in an error instead of blocking
+// the caller forever.
+func VppOpenChWithOptions(opts ConnectOptions) (ConnectionData, error) {
 
 	var vppCh ConnectionData
 	var err error
@@ -58,13 +108,35 @@ func VppOpenCh() (ConnectionData, error) {
 	//   Logrus has six logging levels: DebugLevel, InfoLevel, WarningLevel, ErrorLevel, FatalLevel and PanicLevel.
 	core.SetLogger(&logrus.Logger{Level: logrus.ErrorLevel})
 
+	if opts.Retries < 1 {
+		opts.Retries = 1
+	}
+
 	// Connect to VPP
-	vppCh.conn, err = govpp.Connect("")
-	if err != nil {
+	backoff := opts.Backoff
+	for attempt := 1; attempt <= opts.Retries; attempt++ {
+		vppCh.conn, err = connect(opts.ConnectTimeout)
+		if err == nil {
+			break
+		}
+
 		if debugInfra {
-			fmt.Println("Error:", err)
+			fmt.Fprintf(os.Stderr, "Error: connect attempt %d of %d: %v\n", attempt, opts.Retries, err)
 		}
-		return vppCh, err
+
+		// A hung attempt can't be cancelled and still owns the govpp
+		// connection, so there is no point in retrying.
+		if err == errConnectTimeout {
+			break
+		}
+
+		if attempt < opts.Retries {
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff, opts.MaxBackoff)
+		}
+	}
+	if err != nil {
+		return vppCh, &VppNotReachableError{Err: err}
 	}
 	vppCh.disconnectFlag = true
 
@@ -73,12 +145,16 @@ func VppOpenCh() (ConnectionData, error) {
 	if err != nil {
 		VppCloseCh(vppCh)
 		if debugInfra {
-			fmt.Println("Error:", err)
+			fmt.Fprintln(os.Stderr, "Error:", err)
 		}
 		return vppCh, err
 	}
 	vppCh.closeFlag = true
 
+	if opts.ReplyTimeout != 0 {
+		vppCh.Ch.SetReplyTimeout(opts.ReplyTimeout)
+	}
+
 	return vppCh, err
 }
 
@@ -95,3 +171,88 @@ func VppCloseCh(vppCh ConnectionData) {
 		vppCh.disconnectFlag = false
 	}
 }
+
+// Convert a fixed length, NUL padded string returned by VPP to a Go string.
+func CString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+//
+// Local Functions
+//
+
+var errConnectTimeout = errors.New("timed out waiting for connection")
+
+// Returned by govpp when the process-global connection is already taken,
+// in which case the attempt created nothing and must not reset it.
+const errOneConnection = "only one connection per process is supported"
+
+// Double the delay between connection attempts, up to max (0 = no bound).
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	backoff *= 2
+	if max != 0 && backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// Perform a single connection attempt, bounded by timeout (0 = no bound).
+func connect(timeout time.Duration) (*core.Connection, error) {
+	type result struct {
+		conn *core.Connection
+		err  error
+	}
+
+	var lock sync.Mutex
+	var timedOut bool
+
+	done := make(chan result, 1)
+	go func() {
+		conn, err := govpp.Connect("")
+		if err != nil && err.Error() != errOneConnection {
+			// govpp keeps the handle of the failed attempt registered,
+			// which rejects any further attempt with "only one connection
+			// per process". Disconnect() on any handle clears it.
+			new(core.Connection).Disconnect()
+		}
+
+		// Nobody is waiting on an attempt that timed out, so don't leave
+		// a late connection behind.
+		lock.Lock()
+		defer lock.Unlock()
+		if timedOut {
+			if err == nil {
+				conn.Disconnect()
+			}
+			return
+		}
+		done <- result{conn, err}
+	}()
+
+	if timeout == 0 {
+		r := <-done
+		return r.conn, r.err
+	}
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		lock.Lock()
+		defer lock.Unlock()
+
+		// The attempt may have completed in the meantime.
+		select {
+		case r := <-done:
+			return r.conn, r.err
+		default:
+		}
+		timedOut = true
+		return nil, errConnectTimeout
+	}
+}
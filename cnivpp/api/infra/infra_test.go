@@ -0,0 +1,40 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vppinfra
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		max     time.Duration
+		want    time.Duration
+	}{
+		{"doubled", time.Second, 10 * time.Second, 2 * time.Second},
+		{"capped", 8 * time.Second, 10 * time.Second, 10 * time.Second},
+		{"at cap", 10 * time.Second, 10 * time.Second, 10 * time.Second},
+		{"no cap", 8 * time.Second, 0, 16 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := nextBackoff(test.backoff, test.max); got != test.want {
+			t.Errorf("%s: nextBackoff(%v, %v) = %v, want %v", test.name, test.backoff, test.max, got, test.want)
+		}
+	}
+}
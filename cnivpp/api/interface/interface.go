@@ -21,11 +21,15 @@ package vppinterface
 
 import (
 	"fmt"
+	"net"
+	"os"
 
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 
 	"git.fd.io/govpp.git/api"
 	"git.fd.io/govpp.git/core/bin_api/interfaces"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
 )
 
 //
@@ -33,6 +37,12 @@ import (
 //
 const debugInterface = false
 
+// Sizes of the string fields in the interface API, including the NUL.
+const (
+	maxTagLen        = 64
+	maxNameFilterLen = 49
+)
+
 //
 // API Functions
 //
@@ -44,10 +54,22 @@ func InterfaceCompatibilityCheck(ch *api.Channel) (err error) {
 		&interfaces.SwInterfaceSetFlagsReply{},
 		&interfaces.SwInterfaceAddDelAddress{},
 		&interfaces.SwInterfaceAddDelAddressReply{},
+		&interfaces.SwInterfaceSetMtu{},
+		&interfaces.SwInterfaceSetMtuReply{},
+		&interfaces.SwInterfaceSetUnnumbered{},
+		&interfaces.SwInterfaceSetUnnumberedReply{},
+		&interfaces.SwInterfaceDump{},
+		&interfaces.SwInterfaceDetails{},
+		&interfaces.SwInterfaceTagAddDel{},
+		&interfaces.SwInterfaceTagAddDelReply{},
+		&interfaces.CreateLoopback{},
+		&interfaces.CreateLoopbackReply{},
+		&interfaces.DeleteLoopback{},
+		&interfaces.DeleteLoopbackReply{},
 	)
 	if err != nil {
 		if debugInterface {
-			fmt.Println("VPP Interface failed compatibility")
+			fmt.Fprintln(os.Stderr, "VPP Interface failed compatibility")
 		}
 	}
 
@@ -66,10 +88,39 @@ func SetState(ch *api.Channel, swIfIndex uint32, isUp uint8) error {
 	reply := &interfaces.SwInterfaceSetFlagsReply{}
 
 	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
+
+	if err != nil {
+		if debugInterface {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Attempt to set the MTU of an interface. The same MTU is used for L3, IPv4,
+// IPv6 and MPLS.
+func SetMtu(ch *api.Channel, swIfIndex uint32, mtu uint32) error {
+	// Populate the Set Structure
+	req := &interfaces.SwInterfaceSetMtu{
+		SwIfIndex: swIfIndex,
+		Mtu:       []uint32{mtu, mtu, mtu, mtu},
+	}
+
+	reply := &interfaces.SwInterfaceSetMtuReply{}
+
+	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugInterface {
-			fmt.Println("Error:", err)
+			fmt.Fprintln(os.Stderr, "Error:", err)
 		}
 		return err
 	}
@@ -87,12 +138,12 @@ func AddDelIpAddress(ch *api.Channel, swIfIndex uint32, isAdd uint8, ipResult *c
 	}
 
 	for _, ip := range ipResult.IPs {
-		if ip.Version == "4" {
+		if ip.Address.IP.To4() != nil {
 			req.IsIpv6 = 0
 			req.Address = []byte(ip.Address.IP.To4())
 			prefix, _ := ip.Address.Mask.Size()
 			req.AddressLength = byte(prefix)
-		} else if ip.Version == "6" {
+		} else if ip.Address.IP.To16() != nil {
 			req.IsIpv6 = 1
 			req.Address = []byte(ip.Address.IP.To16())
 			prefix, _ := ip.Address.Mask.Size()
@@ -108,13 +159,228 @@ func AddDelIpAddress(ch *api.Channel, swIfIndex uint32, isAdd uint8, ipResult *c
 	reply := &interfaces.SwInterfaceAddDelAddressReply{}
 
 	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugInterface {
-			fmt.Println("Error:", err)
+			fmt.Fprintln(os.Stderr, "Error:", err)
 		}
 		return err
 	}
 
 	return nil
 }
+
+// Attempt to add (isAdd = 1) or delete (isAdd = 0) a single address, with
+// its prefix length, on an interface.
+func AddDelAddress(ch *api.Channel, swIfIndex uint32, isAdd uint8, addr net.IPNet) error {
+
+	// Populate the Add Structure
+	req := &interfaces.SwInterfaceAddDelAddress{
+		SwIfIndex: swIfIndex,
+		IsAdd:     isAdd, // 1 = add, 0 = delete
+		DelAll:    0,
+	}
+
+	prefix, _ := addr.Mask.Size()
+	req.AddressLength = byte(prefix)
+	if addr.IP.To4() != nil {
+		req.IsIpv6 = 0
+		req.Address = []byte(addr.IP.To4())
+	} else {
+		req.IsIpv6 = 1
+		req.Address = []byte(addr.IP.To16())
+	}
+
+	reply := &interfaces.SwInterfaceAddDelAddressReply{}
+
+	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
+
+	if err != nil {
+		if debugInterface {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Attempt to make an interface unnumbered (isAdd = 1), borrowing the
+// addresses of interface unnumberedSwIfIndex, or to undo it (isAdd = 0).
+func SetUnnumbered(ch *api.Channel, swIfIndex uint32, unnumberedSwIfIndex uint32, isAdd uint8) error {
+	// Populate the Set Structure. SwIfIndex is the interface holding the
+	// addresses, UnnumberedSwIfIndex the one borrowing them.
+	req := &interfaces.SwInterfaceSetUnnumbered{
+		SwIfIndex:           unnumberedSwIfIndex,
+		UnnumberedSwIfIndex: swIfIndex,
+		IsAdd:               isAdd,
+	}
+
+	reply := &interfaces.SwInterfaceSetUnnumberedReply{}
+
+	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
+
+	if err != nil {
+		if debugInterface {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Return the swIfIndex of the interface with the given name, such as
+// "loop0" or "GigabitEthernet0/8/0". swIfIndexes are not stable across VPP
+// restarts, names are.
+func FindInterface(ch *api.Channel, name string) (uint32, error) {
+	list, err := dumpInterfaces(ch, name)
+	if err != nil {
+		return 0, err
+	}
+
+	// The name filter of VPP matches on a substring.
+	for _, intf := range list {
+		if vppinfra.CString(intf.InterfaceName) == name {
+			return intf.SwIfIndex, nil
+		}
+	}
+
+	return 0, fmt.Errorf("ERROR: VPP interface %s not found", name)
+}
+
+// Return the swIfIndex of the interface with the given tag, if any.
+func FindInterfaceByTag(ch *api.Channel, tag string) (found bool, swIfIndex uint32, err error) {
+	list, err := dumpInterfaces(ch, "")
+	if err != nil {
+		return false, 0, err
+	}
+
+	for _, intf := range list {
+		if vppinfra.CString(intf.Tag) == tag {
+			return true, intf.SwIfIndex, nil
+		}
+	}
+
+	return false, 0, nil
+}
+
+// Attempt to create a loopback interface, tagged so it can be found again
+// with FindInterfaceByTag().
+func CreateLoopback(ch *api.Channel, tag string) (uint32, error) {
+	if len(tag) >= maxTagLen {
+		return 0, fmt.Errorf("ERROR: Interface tag %s too long", tag)
+	}
+
+	req := &interfaces.CreateLoopback{
+		MacAddress: make([]byte, 6),
+	}
+
+	reply := &interfaces.CreateLoopbackReply{}
+
+	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
+
+	if err != nil {
+		if debugInterface {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return 0, err
+	}
+
+	tagReq := &interfaces.SwInterfaceTagAddDel{
+		IsAdd:     1,
+		SwIfIndex: reply.SwIfIndex,
+		Tag:       make([]byte, maxTagLen),
+	}
+	copy(tagReq.Tag, tag)
+
+	tagReply := &interfaces.SwInterfaceTagAddDelReply{}
+
+	err = ch.SendRequest(tagReq).ReceiveReply(tagReply)
+	if err == nil {
+		err = vppinfra.CheckRetval(tagReq, tagReply.Retval)
+	}
+
+	// An untagged loopback would never be found again, so don't leave it.
+	if err != nil {
+		if debugInterface {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		DeleteLoopback(ch, reply.SwIfIndex)
+		return 0, err
+	}
+
+	return reply.SwIfIndex, nil
+}
+
+// Attempt to delete a loopback interface.
+func DeleteLoopback(ch *api.Channel, swIfIndex uint32) error {
+	req := &interfaces.DeleteLoopback{
+		SwIfIndex: swIfIndex,
+	}
+
+	reply := &interfaces.DeleteLoopbackReply{}
+
+	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
+
+	if err != nil {
+		if debugInterface {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//
+// Local Functions
+//
+
+// Dump the interfaces, those with name in their name if not empty.
+func dumpInterfaces(ch *api.Channel, name string) ([]*interfaces.SwInterfaceDetails, error) {
+	var list []*interfaces.SwInterfaceDetails
+
+	req := &interfaces.SwInterfaceDump{
+		NameFilter: make([]byte, maxNameFilterLen),
+	}
+	if name != "" {
+		req.NameFilterValid = 1
+		copy(req.NameFilter, name)
+	}
+
+	reqCtx := ch.SendMultiRequest(req)
+
+	for {
+		reply := &interfaces.SwInterfaceDetails{}
+		stop, err := reqCtx.ReceiveReply(reply)
+		if stop {
+			break
+		}
+		if err != nil {
+			if debugInterface {
+				fmt.Fprintln(os.Stderr, "Error listing interfaces:", err)
+			}
+			return nil, err
+		}
+
+		list = append(list, reply)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vppinterface
+
+import (
+	"net"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"git.fd.io/govpp.git/core/bin_api/interfaces"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/mock"
+)
+
+func ipResult(t *testing.T, cidr string) *current.Result {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipNet.IP = ip
+	return &current.Result{IPs: []*current.IPConfig{{Address: *ipNet}}}
+}
+
+func TestSetState(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_set_flags", &interfaces.SwInterfaceSetFlagsReply{})
+
+	if err := SetState(vpp.Ch, 4, 1); err != nil {
+		t.Fatalf("SetState() failed: %v", err)
+	}
+
+	req := &interfaces.SwInterfaceSetFlags{}
+	if err := vpp.Requests("sw_interface_set_flags")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 4 || req.AdminUpDown != 1 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestSetMtu(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_set_mtu", &interfaces.SwInterfaceSetMtuReply{})
+
+	if err := SetMtu(vpp.Ch, 4, 9000); err != nil {
+		t.Fatalf("SetMtu() failed: %v", err)
+	}
+
+	req := &interfaces.SwInterfaceSetMtu{}
+	if err := vpp.Requests("sw_interface_set_mtu")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 4 || len(req.Mtu) != 4 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	for _, mtu := range req.Mtu {
+		if mtu != 9000 {
+			t.Errorf("request Mtu = %v, want 9000 for all protocols", req.Mtu)
+			break
+		}
+	}
+}
+
+func TestAddDelIpAddress(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		isAdd   uint8
+		isIpv6  uint8
+		address string
+		length  uint8
+	}{
+		{"192.168.10.5/24", 1, 0, "192.168.10.5", 24},
+		{"192.168.10.5/24", 0, 0, "192.168.10.5", 24},
+		{"2001:db8::5/64", 1, 1, "2001:db8::5", 64},
+	}
+
+	for _, test := range tests {
+		vpp := vppmock.NewTestVPP(t)
+		vpp.Reply("sw_interface_add_del_address", &interfaces.SwInterfaceAddDelAddressReply{})
+
+		if err := AddDelIpAddress(vpp.Ch, 4, test.isAdd, ipResult(t, test.cidr)); err != nil {
+			t.Errorf("AddDelIpAddress(%s) failed: %v", test.cidr, err)
+		}
+
+		req := &interfaces.SwInterfaceAddDelAddress{}
+		if err := vpp.Requests("sw_interface_add_del_address")[0].Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		vpp.Close()
+
+		// IPv4 addresses fill the first 4 of the 16 bytes.
+		address := net.IP(req.Address)
+		if req.IsIpv6 == 0 {
+			address = address[:4]
+		}
+		if req.SwIfIndex != 4 || req.IsAdd != test.isAdd || req.IsIpv6 != test.isIpv6 ||
+			address.String() != test.address || req.AddressLength != test.length {
+			t.Errorf("AddDelIpAddress(%s): unexpected request %+v", test.cidr, req)
+		}
+	}
+}
+
+func TestAddDelAddress(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_add_del_address", &interfaces.SwInterfaceAddDelAddressReply{})
+
+	addr := net.IPNet{IP: net.ParseIP("192.168.10.1"), Mask: net.CIDRMask(32, 32)}
+	if err := AddDelAddress(vpp.Ch, 4, 1, addr); err != nil {
+		t.Fatalf("AddDelAddress() failed: %v", err)
+	}
+
+	req := &interfaces.SwInterfaceAddDelAddress{}
+	if err := vpp.Requests("sw_interface_add_del_address")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 4 || req.IsAdd != 1 || req.IsIpv6 != 0 ||
+		net.IP(req.Address[:4]).String() != "192.168.10.1" || req.AddressLength != 32 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestSetUnnumbered(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_set_unnumbered", &interfaces.SwInterfaceSetUnnumberedReply{})
+
+	if err := SetUnnumbered(vpp.Ch, 4, 1, 1); err != nil {
+		t.Fatalf("SetUnnumbered() failed: %v", err)
+	}
+
+	// VPP takes the interface holding the address as SwIfIndex.
+	req := &interfaces.SwInterfaceSetUnnumbered{}
+	if err := vpp.Requests("sw_interface_set_unnumbered")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 1 || req.UnnumberedSwIfIndex != 4 || req.IsAdd != 1 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func details(swIfIndex uint32, name string, tag string) *interfaces.SwInterfaceDetails {
+	reply := &interfaces.SwInterfaceDetails{
+		SwIfIndex:     swIfIndex,
+		InterfaceName: make([]byte, 64),
+		Tag:           make([]byte, 64),
+	}
+	copy(reply.InterfaceName, name)
+	copy(reply.Tag, tag)
+	return reply
+}
+
+func TestFindInterface(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_dump", details(3, "loop01", "cni-gw-10.1.1.1"), details(1, "loop0", ""))
+
+	// Only an exact match, the VPP name filter is a substring match.
+	if swIfIndex, err := FindInterface(vpp.Ch, "loop0"); err != nil || swIfIndex != 1 {
+		t.Errorf("FindInterface(loop0) = %d, %v, want 1", swIfIndex, err)
+	}
+	if _, err := FindInterface(vpp.Ch, "loop"); err == nil {
+		t.Errorf("FindInterface(loop) found a partial match")
+	}
+
+	if found, swIfIndex, err := FindInterfaceByTag(vpp.Ch, "cni-gw-10.1.1.1"); err != nil || found == false || swIfIndex != 3 {
+		t.Errorf("FindInterfaceByTag() = %t, %d, %v, want 3", found, swIfIndex, err)
+	}
+	if found, _, err := FindInterfaceByTag(vpp.Ch, "cni-gw-10.1.2.1"); err != nil || found {
+		t.Errorf("FindInterfaceByTag() = %t, %v, want not found", found, err)
+	}
+}
+
+func TestCreateLoopback(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("create_loopback", &interfaces.CreateLoopbackReply{SwIfIndex: 7})
+	vpp.Reply("sw_interface_tag_add_del", &interfaces.SwInterfaceTagAddDelReply{})
+
+	if swIfIndex, err := CreateLoopback(vpp.Ch, "cni-gw-10.1.1.1"); err != nil || swIfIndex != 7 {
+		t.Fatalf("CreateLoopback() = %d, %v, want 7", swIfIndex, err)
+	}
+
+	req := &interfaces.SwInterfaceTagAddDel{}
+	if err := vpp.Requests("sw_interface_tag_add_del")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 7 || req.IsAdd != 1 || string(req.Tag[:16]) != "cni-gw-10.1.1.1\x00" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+// An untagged loopback is deleted.
+func TestCreateLoopbackTagFailed(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("create_loopback", &interfaces.CreateLoopbackReply{SwIfIndex: 7})
+	vpp.Reply("sw_interface_tag_add_del", &interfaces.SwInterfaceTagAddDelReply{Retval: vppinfra.VppErrInvalidSwIfIndex})
+	vpp.Reply("delete_loopback", &interfaces.DeleteLoopbackReply{})
+
+	if _, err := CreateLoopback(vpp.Ch, "cni-gw-10.1.1.1"); err == nil {
+		t.Fatalf("CreateLoopback() succeeded without a tag")
+	}
+
+	req := &interfaces.DeleteLoopback{}
+	if reqs := vpp.Requests("delete_loopback"); len(reqs) != 1 {
+		t.Fatalf("got %d delete_loopback requests, want 1", len(reqs))
+	} else if err := reqs[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 7 {
+		t.Errorf("deleted loopback %d, want 7", req.SwIfIndex)
+	}
+}
+
+func TestInterfaceRetval(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_set_flags", &interfaces.SwInterfaceSetFlagsReply{Retval: vppinfra.VppErrInvalidSwIfIndex})
+	if err := SetState(vpp.Ch, 4, 1); vppinfra.IsVppError(err, vppinfra.VppErrInvalidSwIfIndex) == false {
+		t.Errorf("SetState() = %v, want VPP error %d", err, vppinfra.VppErrInvalidSwIfIndex)
+	}
+
+	vpp.Reply("sw_interface_set_mtu", &interfaces.SwInterfaceSetMtuReply{Retval: vppinfra.VppErrInvalidValue})
+	if err := SetMtu(vpp.Ch, 4, 65000); vppinfra.IsVppError(err, vppinfra.VppErrInvalidValue) == false {
+		t.Errorf("SetMtu() = %v, want VPP error %d", err, vppinfra.VppErrInvalidValue)
+	}
+
+	vpp.Reply("sw_interface_add_del_address", &interfaces.SwInterfaceAddDelAddressReply{Retval: vppinfra.VppErrAddressNotFoundForInterface})
+	err := AddDelIpAddress(vpp.Ch, 4, 0, ipResult(t, "192.168.10.5/24"))
+	if vppinfra.IsVppError(err, vppinfra.VppErrAddressNotFoundForInterface) == false {
+		t.Errorf("AddDelIpAddress() = %v, want VPP error %d", err, vppinfra.VppErrAddressNotFoundForInterface)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module adds and deletes routes in the VPP FIB. It is used for the
+// point-to-point L3 model of NetType 'interface', where VPP routes the
+// address of the pod through the interface of the pod.
+//
+
+package vppip
+
+// Generates Go bindings for all VPP APIs located in the json directory.
+//go:generate binapi-generator --input-dir=../../bin_api --output-dir=../../bin_api
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"git.fd.io/govpp.git/api"
+	"git.fd.io/govpp.git/core/bin_api/ip"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
+)
+
+//
+// Constants
+//
+const debugIp = false
+
+//
+// API Functions
+//
+
+// Check whether generated API messages are compatible with the version
+// of VPP which the library is connected to.
+func IpCompatibilityCheck(ch *api.Channel) error {
+	err := ch.CheckMessageCompatibility(
+		&ip.IPAddDelRoute{},
+		&ip.IPAddDelRouteReply{},
+	)
+	if err != nil {
+		if debugIp {
+			fmt.Fprintln(os.Stderr, "VPP ip failed compatibility")
+		}
+	}
+
+	return err
+}
+
+// AddDelRoute() - Add (isAdd = 1) or delete (isAdd = 0) the route to dst
+//  in the default table, through interface swIfIndex to nextHop.
+func AddDelRoute(ch *api.Channel, swIfIndex uint32, isAdd uint8, dst net.IPNet, nextHop net.IP) error {
+
+	// Populate the Add Structure
+	req := &ip.IPAddDelRoute{
+		NextHopSwIfIndex: swIfIndex,
+		IsAdd:            isAdd,
+		NextHopWeight:    1,
+		DstAddress:       make([]byte, 16),
+		NextHopAddress:   make([]byte, 16),
+	}
+
+	prefix, _ := dst.Mask.Size()
+	req.DstAddressLength = uint8(prefix)
+
+	if dst.IP.To4() != nil {
+		copy(req.DstAddress, dst.IP.To4())
+		copy(req.NextHopAddress, nextHop.To4())
+	} else {
+		req.IsIPv6 = 1
+		copy(req.DstAddress, dst.IP.To16())
+		copy(req.NextHopAddress, nextHop.To16())
+	}
+
+	reply := &ip.IPAddDelRouteReply{}
+
+	err := ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
+
+	if err != nil {
+		if debugIp {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// HostPrefix() - Return the /32 (IPv4) or /128 (IPv6) prefix of addr.
+func HostPrefix(addr net.IP) net.IPNet {
+	if ip4 := addr.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return net.IPNet{IP: addr.To16(), Mask: net.CIDRMask(128, 128)}
+}
+
+// DefaultPrefix() - Return the default route prefix, 0.0.0.0/0 or ::/0,
+//  of the address family of addr.
+func DefaultPrefix(addr net.IP) net.IPNet {
+	if addr.To4() != nil {
+		return net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	}
+	return net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+}
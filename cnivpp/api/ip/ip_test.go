@@ -0,0 +1,75 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vppip
+
+import (
+	"net"
+	"testing"
+
+	"git.fd.io/govpp.git/core/bin_api/ip"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/mock"
+)
+
+func TestAddDelRoute(t *testing.T) {
+	tests := []struct {
+		dst     net.IPNet
+		nextHop string
+		isIpv6  uint8
+		length  uint8
+	}{
+		{HostPrefix(net.ParseIP("192.168.10.5")), "192.168.10.5", 0, 32},
+		{DefaultPrefix(net.ParseIP("192.168.10.1")), "192.168.10.1", 0, 0},
+		{HostPrefix(net.ParseIP("2001:db8::5")), "2001:db8::5", 1, 128},
+	}
+
+	for _, test := range tests {
+		vpp := vppmock.NewTestVPP(t)
+		vpp.Reply("ip_add_del_route", &ip.IPAddDelRouteReply{})
+
+		if err := AddDelRoute(vpp.Ch, 4, 1, test.dst, net.ParseIP(test.nextHop)); err != nil {
+			t.Errorf("AddDelRoute(%s) failed: %v", test.dst.String(), err)
+		}
+
+		req := &ip.IPAddDelRoute{}
+		if err := vpp.Requests("ip_add_del_route")[0].Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		vpp.Close()
+
+		// IPv4 addresses fill the first 4 of the 16 bytes.
+		dst, nextHop := net.IP(req.DstAddress), net.IP(req.NextHopAddress)
+		if req.IsIPv6 == 0 {
+			dst, nextHop = dst[:4], nextHop[:4]
+		}
+		if req.NextHopSwIfIndex != 4 || req.IsAdd != 1 || req.IsIPv6 != test.isIpv6 || req.DstAddressLength != test.length ||
+			dst.Equal(test.dst.IP) == false || nextHop.String() != test.nextHop {
+			t.Errorf("AddDelRoute(%s): unexpected request %+v", test.dst.String(), req)
+		}
+	}
+}
+
+func TestAddDelRouteRetval(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("ip_add_del_route", &ip.IPAddDelRouteReply{Retval: vppinfra.VppErrNoSuchEntry})
+
+	err := AddDelRoute(vpp.Ch, 4, 0, HostPrefix(net.ParseIP("192.168.10.5")), net.ParseIP("192.168.10.5"))
+	if vppinfra.IsVppError(err, vppinfra.VppErrNoSuchEntry) == false {
+		t.Errorf("AddDelRoute() = %v, want VPP error %d", err, vppinfra.VppErrNoSuchEntry)
+	}
+}
@@ -21,10 +21,14 @@ package vppmemif
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"os"
 
 	"git.fd.io/govpp.git/api"
 	"git.fd.io/govpp.git/core/bin_api/memif"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
 )
 
 //
@@ -33,6 +37,14 @@ import (
 
 const debugMemif = false
 
+// SocketId of the default socket file. VPP creates it, and it is never
+// deleted.
+const defaultSocketId = 0
+
+// Number of attempts to add a socket file, when a concurrent ADD takes the
+// chosen SocketId or adds the same file first.
+const maxSocketAttempts = 3
+
 type MemifRole uint8
 
 const (
@@ -49,10 +61,55 @@ const (
 )
 
 // Dump Strings
-var modeStr = [...]string{"eth", "ip ", "pnt"}
-var roleStr = [...]string{"master", "slave "}
 var stateStr = [...]string{"dn", "up"}
 
+//
+// Types
+//
+
+// Memif Interface as reported by VPP.
+type MemifInterface struct {
+	SwIfIndex  uint32
+	IfName     string
+	HwAddr     net.HardwareAddr
+	ID         uint32
+	SocketId   uint32
+	Role       MemifRole
+	Mode       MemifMode
+	RingSize   uint32
+	BufferSize uint16
+	AdminUp    bool
+	LinkUp     bool
+}
+
+// Memif Socketfile as reported by VPP.
+type MemifSocket struct {
+	SocketId uint32
+	Filename string
+}
+
+func (role MemifRole) String() string {
+	switch role {
+	case RoleMaster:
+		return "master"
+	case RoleSlave:
+		return "slave"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(role))
+}
+
+func (mode MemifMode) String() string {
+	switch mode {
+	case ModeEthernet:
+		return "ethernet"
+	case ModeIP:
+		return "ip"
+	case ModePuntInject:
+		return "inject-punt"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(mode))
+}
+
 //
 // API Functions
 //
@@ -70,41 +127,64 @@ func MemifCompatibilityCheck(ch *api.Channel) (err error) {
 	)
 	if err != nil {
 		if debugMemif {
-			fmt.Println("VPP memif failed compatibility")
+			fmt.Fprintln(os.Stderr, "VPP memif failed compatibility")
 		}
 	}
 
 	return err
 }
 
-// Attempt to create a MemIf Interface.
+// Attempt to create a MemIf Interface with memif ID 0 and one queue each
+// way.
+// Input:
+//   ch *api.Channel
+//   socketId uint32
+//   role MemifRole - RoleMaster or RoleSlave
+//   mode MemifMode
+//   hwAddr net.HardwareAddr - MAC of the interface, nil to let VPP generate one
+func CreateMemifInterface(ch *api.Channel, socketId uint32, role MemifRole, mode MemifMode, hwAddr net.HardwareAddr) (swIfIndex uint32, err error) {
+	return CreateMemifInterfaceWithId(ch, socketId, 0, role, mode, 1, 1, hwAddr)
+}
+
+// Attempt to create a MemIf Interface with the given memif ID. Interfaces
+// sharing a socket must have distinct memif IDs, and the interface at the
+// other end of the connection must use the same memif ID.
 // Input:
 //   ch *api.Channel
 //   socketId uint32
+//   id uint32 - memif ID, unique on the socket
 //   role MemifRole - RoleMaster or RoleSlave
-func CreateMemifInterface(ch *api.Channel, socketId uint32, role MemifRole, mode MemifMode) (swIfIndex uint32, err error) {
+//   mode MemifMode
+//   rxQueues, txQueues uint8 - Number of queues, 0 for the VPP default
+//   hwAddr net.HardwareAddr - MAC of the interface, nil to let VPP generate one
+func CreateMemifInterfaceWithId(ch *api.Channel, socketId uint32, id uint32, role MemifRole, mode MemifMode,
+	rxQueues uint8, txQueues uint8, hwAddr net.HardwareAddr) (swIfIndex uint32, err error) {
 
 	// Populate the Add Structure
 	req := &memif.MemifCreate{
 		Role:     uint8(role),
 		Mode:     uint8(mode),
-		RxQueues: 1,
-		TxQueues: 1,
-		ID:       0,
+		RxQueues: rxQueues,
+		TxQueues: txQueues,
+		ID:       id,
 		SocketID: socketId,
 		//Secret: "",
 		RingSize:   1024,
 		BufferSize: 2048,
-		//HwAddr: "",
+		HwAddr:     make([]byte, 6),
 	}
+	copy(req.HwAddr, hwAddr)
 
 	reply := &memif.MemifCreateReply{}
 
 	err = ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugMemif {
-			fmt.Println("Error creating memif interface:", err)
+			fmt.Fprintln(os.Stderr, "Error creating memif interface:", err)
 		}
 		return
 	} else {
@@ -120,12 +200,15 @@ func CreateMemifInterface(ch *api.Channel, socketId uint32, role MemifRole, mode
 func DeleteMemifInterface(ch *api.Channel, swIfIndex uint32) (err error) {
 
 	// Determine if memif interface exists
-	socketId, exist := findMemifInterface(ch, swIfIndex)
+	socketId, exist, err := findMemifInterface(ch, swIfIndex)
+	if err != nil {
+		return err
+	}
 	if debugMemif {
 		if exist == false {
-			fmt.Printf("Error deleting memif interface: memif interface (swIfIndex=%d) Does NOT Exist", swIfIndex)
+			fmt.Fprintf(os.Stderr, "Error deleting memif interface: memif interface (swIfIndex=%d) Does NOT Exist", swIfIndex)
 		} else {
-			fmt.Printf("Attempting to delete memif interface %d with SocketId %d", swIfIndex, socketId)
+			fmt.Fprintf(os.Stderr, "Attempting to delete memif interface %d with SocketId %d", swIfIndex, socketId)
 		}
 	}
 
@@ -137,110 +220,194 @@ func DeleteMemifInterface(ch *api.Channel, swIfIndex uint32) (err error) {
 	reply := &memif.MemifDeleteReply{}
 
 	err = ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugMemif {
-			fmt.Println("Error deleting memif interface:", err)
+			fmt.Fprintln(os.Stderr, "Error deleting memif interface:", err)
 		}
 		return err
 	}
 
-	// If the socketFile is not the default (0), then determine if it is no longer in use,
-	// and if not, then delete it.
-	if socketId != 0 {
-		count := findMemifSocketCnt(ch, socketId)
-		if debugMemif {
-			fmt.Printf("SocketId %d has %d attached interfaces", socketId, count)
-		}
-		if count == 0 {
-			err = DeleteMemifSocket(ch, socketId)
-			if err != nil {
-				if debugMemif {
-					fmt.Println("Error deleting memif socket:", err)
-				}
-				return err
-			}
-		}
+	// Delete the socketFile if this was the last interface using it.
+	if exist {
+		err = ReleaseMemifSocket(ch, socketId)
 	}
 
 	return err
 }
 
-// Dump the set of existing memif interfaces to stdout.
-func DumpMemif(ch *api.Channel) {
-	var count int
+// Return the set of existing memif interfaces.
+func ListMemif(ch *api.Channel) (list []MemifInterface, err error) {
 
 	// Populate the Message Structure
 	req := &memif.MemifDump{}
 	reqCtx := ch.SendMultiRequest(req)
 
-	fmt.Printf("Memif Interface List:\n")
 	for {
 		reply := &memif.MemifDetails{}
-		stop, err := reqCtx.ReceiveReply(reply)
+		stop, replyErr := reqCtx.ReceiveReply(reply)
 		if stop {
 			break // break out of the loop
 		}
-		if err != nil {
-			fmt.Println("Error dumping memif interface:", err)
+		if replyErr != nil {
+			if debugMemif {
+				fmt.Fprintln(os.Stderr, "Error listing memif interface:", replyErr)
+			}
+			err = replyErr
+			break
 		}
-		//fmt.Printf("%+v\n", reply)
-
-		macAddr := net.HardwareAddr(reply.HwAddr)
-		fmt.Printf("    SwIfId=%d ID=%d Socket=%d Role=%s Mode=%s IfName=%s HwAddr=%s RingSz=%d BufferSz=%d Admin=%s Link=%s\n",
-			reply.SwIfIndex,
-			reply.ID,
-			reply.SocketID,
-			roleStr[reply.Role],
-			modeStr[reply.Mode],
-			string(reply.IfName),
-			macAddr.String(),
-			reply.RingSize,
-			reply.BufferSize,
-			stateStr[reply.AdminUpDown],
-			stateStr[reply.LinkUpDown])
-
-		count++
-	}
-
-	fmt.Printf("  Interface Count: %d\n", count)
+
+		list = append(list, MemifInterface{
+			SwIfIndex:  reply.SwIfIndex,
+			IfName:     vppinfra.CString(reply.IfName),
+			HwAddr:     net.HardwareAddr(reply.HwAddr),
+			ID:         reply.ID,
+			SocketId:   reply.SocketID,
+			Role:       MemifRole(reply.Role),
+			Mode:       MemifMode(reply.Mode),
+			RingSize:   reply.RingSize,
+			BufferSize: reply.BufferSize,
+			AdminUp:    reply.AdminUpDown != 0,
+			LinkUp:     reply.LinkUpDown != 0,
+		})
+	}
+
+	return
 }
 
-// API to Create the MemIf Socketfile.
-func CreateMemifSocket(ch *api.Channel, socketFile string) (socketId uint32, err error) {
+// Return the memif interface with the given swIfIndex, if it exists.
+func GetMemif(ch *api.Channel, swIfIndex uint32) (intf MemifInterface, found bool, err error) {
 
-	found, socketId := findMemifSocket(ch, socketFile)
-	if found {
-		fmt.Println("Socketfile already exists")
+	list, err := ListMemif(ch)
+	if err != nil {
 		return
 	}
 
-	if debugMemif {
-		fmt.Printf("Attempting to create SocketId=%d File=%s\n", socketId, socketFile)
+	for _, entry := range list {
+		if entry.SwIfIndex == swIfIndex {
+			return entry, true, nil
+		}
 	}
 
-	// Populate the Request Structure
-	req := &memif.MemifSocketFilenameAddDel{
-		IsAdd:          1,
-		SocketID:       socketId,
-		SocketFilename: []byte(socketFile),
+	return
+}
+
+// Dump the set of existing memif interfaces to the given writer.
+func DumpMemif(w io.Writer, ch *api.Channel) {
+
+	list, err := ListMemif(ch)
+
+	fmt.Fprintf(w, "Memif Interface List:\n")
+	for _, intf := range list {
+		fmt.Fprintf(w, "    SwIfId=%d ID=%d Socket=%d Role=%s Mode=%s IfName=%s HwAddr=%s RingSz=%d BufferSz=%d Admin=%s Link=%s\n",
+			intf.SwIfIndex,
+			intf.ID,
+			intf.SocketId,
+			intf.Role,
+			intf.Mode,
+			intf.IfName,
+			intf.HwAddr.String(),
+			intf.RingSize,
+			intf.BufferSize,
+			upDownStr(intf.AdminUp),
+			upDownStr(intf.LinkUp))
+	}
+	if err != nil {
+		fmt.Fprintln(w, "Error dumping memif interface:", err)
 	}
 
-	reply := &memif.MemifSocketFilenameAddDelReply{}
+	fmt.Fprintf(w, "  Interface Count: %d\n", len(list))
+}
 
-	err = ch.SendRequest(req).ReceiveReply(reply)
+// Return the SocketId of the given socketfile, adding it to VPP with the
+// lowest free SocketId if it doesn't exist yet. Interfaces on the same
+// socketfile share the SocketId. ReleaseMemifSocket() deletes the socketfile
+// once no interface uses it.
+func CreateMemifSocket(ch *api.Channel, socketFile string) (socketId uint32, err error) {
 
-	if debugMemif {
-		if err != nil {
-			fmt.Println("Error creating memif socket:", err)
-		} else {
-			fmt.Printf("Creating memif socket: rval=%d\n", reply.Retval)
+	for attempt := 0; attempt < maxSocketAttempts; attempt++ {
+		var found bool
+
+		found, socketId, err = findMemifSocket(ch, socketFile)
+		if err != nil || found {
+			return
+		}
+
+		if debugMemif {
+			fmt.Fprintf(os.Stderr, "Attempting to create SocketId=%d File=%s\n", socketId, socketFile)
+		}
+
+		// Populate the Request Structure
+		req := &memif.MemifSocketFilenameAddDel{
+			IsAdd:          1,
+			SocketID:       socketId,
+			SocketFilename: []byte(socketFile),
+		}
+
+		reply := &memif.MemifSocketFilenameAddDelReply{}
+
+		err = ch.SendRequest(req).ReceiveReply(reply)
+		if err == nil {
+			err = vppinfra.CheckRetval(req, reply.Retval)
+		}
+
+		if debugMemif {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error creating memif socket:", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Creating memif socket: rval=%d\n", reply.Retval)
+			}
+		}
+
+		// VPP rejected the request, possibly because a concurrent ADD took the
+		// SocketId or added the same file. Look again.
+		if _, ok := err.(*vppinfra.VppError); ok == false {
+			break
 		}
 	}
 
+	if err != nil {
+		socketId = 0
+	}
 	return
 }
 
+// Delete the given socketfile from VPP if no memif interface uses it
+// anymore. Nothing is done for the default socketfile, or a socketfile
+// that is already gone.
+func ReleaseMemifSocket(ch *api.Channel, socketId uint32) error {
+
+	if socketId == defaultSocketId {
+		return nil
+	}
+
+	count, err := findMemifSocketCnt(ch, socketId)
+	if err != nil {
+		return err
+	}
+	if debugMemif {
+		fmt.Fprintf(os.Stderr, "SocketId %d has %d attached interfaces", socketId, count)
+	}
+	if count != 0 {
+		return nil
+	}
+
+	list, err := ListMemifSocket(ch)
+	if err != nil {
+		return err
+	}
+	for _, socket := range list {
+		if socket.SocketId == socketId {
+			return DeleteMemifSocket(ch, socketId)
+		}
+	}
+
+	return nil
+}
+
 // API to Delete the MemIf Socketfile.
 func DeleteMemifSocket(ch *api.Channel, socketId uint32) (err error) {
 	// Populate the Add Structure
@@ -252,45 +419,65 @@ func DeleteMemifSocket(ch *api.Channel, socketId uint32) (err error) {
 	reply := &memif.MemifSocketFilenameAddDelReply{}
 
 	err = ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if debugMemif {
 		if err != nil {
-			fmt.Println("Error deleting memif socket:", err)
+			fmt.Fprintln(os.Stderr, "Error deleting memif socket:", err)
 		} else {
-			fmt.Printf("Deleting memif socket: rval=%d\n", reply.Retval)
+			fmt.Fprintf(os.Stderr, "Deleting memif socket: rval=%d\n", reply.Retval)
 		}
 	}
 
 	return
 }
 
-// Dump the set of existing memif socketfiles to stdout.
-func DumpMemifSocket(ch *api.Channel) {
-	var count int
+// Return the set of existing memif socketfiles.
+func ListMemifSocket(ch *api.Channel) (list []MemifSocket, err error) {
 
 	// Populate the Message Structure
 	req := &memif.MemifSocketFilenameDump{}
 	reqCtx := ch.SendMultiRequest(req)
 
-	fmt.Printf("Memif Socket List:\n")
 	for {
 		reply := &memif.MemifSocketFilenameDetails{}
-		stop, err := reqCtx.ReceiveReply(reply)
+		stop, replyErr := reqCtx.ReceiveReply(reply)
 		if stop {
 			break // break out of the loop
 		}
-		if err != nil {
-			fmt.Println("Error dumping memif socket:", err)
+		if replyErr != nil {
+			if debugMemif {
+				fmt.Fprintln(os.Stderr, "Error listing memif socket:", replyErr)
+			}
+			err = replyErr
+			break
 		}
-		//fmt.Printf("%+v\n", reply)
 
-		socketId := reply.SocketID
-		filename := string(reply.SocketFilename)
-		fmt.Printf("    SocketId=%d Filename=%s\n", socketId, filename)
-		count++
+		list = append(list, MemifSocket{
+			SocketId: reply.SocketID,
+			Filename: vppinfra.CString(reply.SocketFilename),
+		})
+	}
+
+	return
+}
+
+// Dump the set of existing memif socketfiles to the given writer.
+func DumpMemifSocket(w io.Writer, ch *api.Channel) {
+
+	list, err := ListMemifSocket(ch)
+
+	fmt.Fprintf(w, "Memif Socket List:\n")
+	for _, socket := range list {
+		fmt.Fprintf(w, "    SocketId=%d Filename=%s\n", socket.SocketId, socket.Filename)
+	}
+	if err != nil {
+		fmt.Fprintln(w, "Error dumping memif socket:", err)
 	}
 
-	fmt.Printf("  Socket Count: %d\n", count)
+	fmt.Fprintf(w, "  Socket Count: %d\n", len(list))
 }
 
 //
@@ -298,144 +485,74 @@ func DumpMemifSocket(ch *api.Channel) {
 //
 
 // Find the given memif interface and return socketId if it exists
-func findMemifInterface(ch *api.Channel, swIfIndex uint32) (socketId uint32, found bool) {
+func findMemifInterface(ch *api.Channel, swIfIndex uint32) (socketId uint32, found bool, err error) {
 
-	// Populate the Message Structure
-	req := &memif.MemifDump{}
-	reqCtx := ch.SendMultiRequest(req)
-
-	for {
-		reply := &memif.MemifDetails{}
-		stop, err := reqCtx.ReceiveReply(reply)
-		if stop {
-			break // break out of the loop
-		}
-		if err != nil {
-			if debugMemif {
-				fmt.Println("Error searching memif interface:", err)
-			}
-		} else if swIfIndex == reply.SwIfIndex {
-			found = true
-			socketId = reply.SocketID
+	intf, found, err := GetMemif(ch, swIfIndex)
+	if err != nil {
+		if debugMemif {
+			fmt.Fprintln(os.Stderr, "Error searching memif interface:", err)
 		}
 	}
+	socketId = intf.SocketId
+
 	return
 }
 
 // Loop through the memif interfaces and return the number of interfaces using the given socketId
-func findMemifSocketCnt(ch *api.Channel, socketId uint32) (count uint32) {
-
-	// Populate the Message Structure
-	req := &memif.MemifDump{}
-	reqCtx := ch.SendMultiRequest(req)
+func findMemifSocketCnt(ch *api.Channel, socketId uint32) (count uint32, err error) {
 
-	for {
-		reply := &memif.MemifDetails{}
-		stop, err := reqCtx.ReceiveReply(reply)
-		if stop {
-			break // break out of the loop
+	list, err := ListMemif(ch)
+	if err != nil {
+		if debugMemif {
+			fmt.Fprintln(os.Stderr, "Error searching memif interface:", err)
 		}
-		if err != nil {
-			if debugMemif {
-				fmt.Println("Error searching memif interface:", err)
-			}
-		} else if socketId == reply.SocketID {
+		return
+	}
+
+	for _, intf := range list {
+		if socketId == intf.SocketId {
 			count++
 		}
 	}
 	return
 }
 
-// Loop through the list of Memif Sockets and determine if input
-// socketId exists.
-// Returns: true - exists  false - otherwise
-//func findMemifSocket(ch *api.Channel, socketId uint32) (found bool) {
-//
-//	// Populate the Message Structure
-//	req := &memif.MemifSocketFilenameDump{}
-//	reqCtx := ch.SendMultiRequest(req)
-//
-//	for {
-//		reply := &memif.MemifSocketFilenameDetails{}
-//		stop, err := reqCtx.ReceiveReply(reply)
-//		if stop {
-//			break // break out of the loop
-//		}
-//		if err != nil {
-//			fmt.Println("Error dumping memif socket:", err)
-//		}
-//
-//		if socketId == reply.SocketID {
-//			found = true
-//		}
-//	}
-//
-//	return
-//}
-
 // Loop through the list of Memif Sockets and determine if input
 // socketFile exists. If it does, return the associated socketId.
-// If it doesn't, return the next available socketId.
+// If it doesn't, return the lowest free socketId.
 // Returns:
 //   bool - Found flag
 //   uint32 - If found is true: associated socketId.
 //            If found is false: next free socketId.
-func findMemifSocket(ch *api.Channel, socketFilename string) (found bool, socketId uint32) {
+//   error - Unable to list the Memif Sockets, or no free socketId.
+func findMemifSocket(ch *api.Channel, socketFilename string) (found bool, socketId uint32, err error) {
 
-	var count int
-	var usedList [20]uint32
-	var done bool
-
-	// Populate the Message Structure
-	req := &memif.MemifSocketFilenameDump{}
-	reqCtx := ch.SendMultiRequest(req)
+	list, err := ListMemifSocket(ch)
+	if err != nil {
+		return
+	}
 
-	//
-	// Loop through the exisiting SocketFiles and see if input
-	// socketFilename already exists,
-	//
-	for {
-		reply := &memif.MemifSocketFilenameDetails{}
-		stop, err := reqCtx.ReceiveReply(reply)
-		if stop {
-			break // break out of the loop
-		}
-		if err != nil {
-			if debugMemif {
-				fmt.Println("Error retrieving memif socket:", err)
-			}
+	used := make(map[uint32]bool)
+	for _, socket := range list {
+		if socket.Filename == socketFilename {
+			return true, socket.SocketId, nil
 		}
+		used[socket.SocketId] = true
+	}
 
-		if socketFilename == string(reply.SocketFilename) {
-			found = true
-			socketId = reply.SocketID
-			break // break out of the loop
-		} else {
-			usedList[count] = reply.SocketID
-			count++
+	// ~0 is not a valid SocketId.
+	for socketId = defaultSocketId + 1; socketId != ^uint32(0); socketId++ {
+		if used[socketId] == false {
+			return
 		}
 	}
 
-	//
-	// If input SocketFilename has not been created, then loop
-	// through the list of existing SocketIds and find an unused Id.
-	//
-	if found == false {
-		socketId = 1
-
-		for done == false {
-
-			done = true
-			for i := 0; i < count; i++ {
-				if socketId == usedList[i] {
-					socketId++
-					done = false
-					break
-				}
-			}
+	return false, 0, fmt.Errorf("ERROR: No free memif SocketId")
+}
 
-		}
+func upDownStr(up bool) string {
+	if up {
+		return stateStr[1]
 	}
-
-	return
+	return stateStr[0]
 }
@@ -0,0 +1,332 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vppmemif
+
+import (
+	"net"
+	"testing"
+
+	"git.fd.io/govpp.git/api"
+	"git.fd.io/govpp.git/core/bin_api/memif"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/mock"
+)
+
+func socketDetails(socketId uint32, filename string) *memif.MemifSocketFilenameDetails {
+	return &memif.MemifSocketFilenameDetails{SocketID: socketId, SocketFilename: []byte(filename)}
+}
+
+func memifDetails(swIfIndex uint32, socketId uint32) *memif.MemifDetails {
+	return &memif.MemifDetails{
+		SwIfIndex: swIfIndex,
+		IfName:    []byte("memif0/0"),
+		HwAddr:    []byte{0x02, 0xfe, 0x00, 0x00, 0x00, 0x01},
+		SocketID:  socketId,
+		Role:      uint8(RoleSlave),
+		RingSize:  1024,
+	}
+}
+
+func TestCreateMemifSocketNew(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_socket_filename_dump",
+		socketDetails(0, "/run/vpp/memif.sock"),
+		socketDetails(1, "/var/run/vpp/cni/shared/memif-1.sock"))
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+
+	socketId, err := CreateMemifSocket(vpp.Ch, "/var/run/vpp/cni/shared/memif-2.sock")
+	if err != nil {
+		t.Fatalf("CreateMemifSocket() failed: %v", err)
+	}
+	if socketId != 2 {
+		t.Errorf("CreateMemifSocket() = %d, want first free Id 2", socketId)
+	}
+
+	reqs := vpp.Requests("memif_socket_filename_add_del")
+	if len(reqs) != 1 {
+		t.Fatalf("got %d memif_socket_filename_add_del requests, want 1", len(reqs))
+	}
+	req := &memif.MemifSocketFilenameAddDel{}
+	if err = reqs[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.IsAdd != 1 || req.SocketID != 2 || vppinfra.CString(req.SocketFilename) != "/var/run/vpp/cni/shared/memif-2.sock" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestCreateMemifSocketReuse(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_socket_filename_dump",
+		socketDetails(0, "/run/vpp/memif.sock"),
+		socketDetails(3, "/var/run/vpp/cni/shared/memif-1.sock"))
+
+	socketId, err := CreateMemifSocket(vpp.Ch, "/var/run/vpp/cni/shared/memif-1.sock")
+	if err != nil {
+		t.Fatalf("CreateMemifSocket() failed: %v", err)
+	}
+	if socketId != 3 {
+		t.Errorf("CreateMemifSocket() = %d, want existing Id 3", socketId)
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 0 {
+		t.Errorf("existing socket file was added again")
+	}
+}
+
+func TestCreateMemifSocketConcurrentAdd(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	// Another ADD adds the same file with Id 1 between the dump and the add.
+	dumps := 0
+	vpp.OnRequest("memif_socket_filename_dump", func(req *vppmock.Request) []api.Message {
+		dumps++
+		if dumps == 1 {
+			return []api.Message{socketDetails(0, "/run/vpp/memif.sock")}
+		}
+		return []api.Message{socketDetails(0, "/run/vpp/memif.sock"), socketDetails(1, "/var/run/vpp/cni/shared/memif-1.sock")}
+	})
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{Retval: vppinfra.VppErrValueExist})
+
+	socketId, err := CreateMemifSocket(vpp.Ch, "/var/run/vpp/cni/shared/memif-1.sock")
+	if err != nil {
+		t.Fatalf("CreateMemifSocket() failed: %v", err)
+	}
+	if socketId != 1 {
+		t.Errorf("CreateMemifSocket() = %d, want Id 1 added by the other ADD", socketId)
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 1 {
+		t.Errorf("got %d memif_socket_filename_add_del requests, want 1", len(reqs))
+	}
+}
+
+func TestCreateMemifSocketGivesUp(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_socket_filename_dump", socketDetails(0, "/run/vpp/memif.sock"))
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{Retval: vppinfra.VppErrValueExist})
+
+	socketId, err := CreateMemifSocket(vpp.Ch, "/var/run/vpp/cni/shared/memif-1.sock")
+	if vppinfra.IsVppError(err, vppinfra.VppErrValueExist) == false || socketId != 0 {
+		t.Errorf("CreateMemifSocket() = %d, %v, want VPP error %d", socketId, err, vppinfra.VppErrValueExist)
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != maxSocketAttempts {
+		t.Errorf("got %d memif_socket_filename_add_del requests, want %d", len(reqs), maxSocketAttempts)
+	}
+}
+
+func TestReleaseMemifSocket(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_dump", memifDetails(5, 2))
+	vpp.Reply("memif_socket_filename_dump",
+		socketDetails(0, "/run/vpp/memif.sock"),
+		socketDetails(2, "/var/run/vpp/cni/shared/memif-2.sock"),
+		socketDetails(3, "/var/run/vpp/cni/shared/memif-3.sock"))
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+
+	// In use, already gone and the default socket are all left alone.
+	for _, socketId := range []uint32{2, 4, 0} {
+		if err := ReleaseMemifSocket(vpp.Ch, socketId); err != nil {
+			t.Errorf("ReleaseMemifSocket(%d) failed: %v", socketId, err)
+		}
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 0 {
+		t.Fatalf("socket deleted while in use, missing or default")
+	}
+
+	if err := ReleaseMemifSocket(vpp.Ch, 3); err != nil {
+		t.Fatalf("ReleaseMemifSocket(3) failed: %v", err)
+	}
+	req := &memif.MemifSocketFilenameAddDel{}
+	if err := vpp.Requests("memif_socket_filename_add_del")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.IsAdd != 0 || req.SocketID != 3 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestCreateMemifInterface(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_create", &memif.MemifCreateReply{SwIfIndex: 5})
+
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:0a")
+	swIfIndex, err := CreateMemifInterface(vpp.Ch, 2, RoleMaster, ModeIP, hwAddr)
+	if err != nil {
+		t.Fatalf("CreateMemifInterface() failed: %v", err)
+	}
+	if swIfIndex != 5 {
+		t.Errorf("CreateMemifInterface() = %d, want 5", swIfIndex)
+	}
+
+	req := &memif.MemifCreate{}
+	if err = vpp.Requests("memif_create")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SocketID != 2 || MemifRole(req.Role) != RoleMaster || MemifMode(req.Mode) != ModeIP {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if net.HardwareAddr(req.HwAddr).String() != hwAddr.String() {
+		t.Errorf("request HwAddr = %s, want %s", net.HardwareAddr(req.HwAddr), hwAddr)
+	}
+}
+
+func TestCreateMemifInterfaceWithId(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_create", &memif.MemifCreateReply{SwIfIndex: 6})
+
+	swIfIndex, err := CreateMemifInterfaceWithId(vpp.Ch, 2, 1, RoleSlave, ModeEthernet, 4, 2, nil)
+	if err != nil {
+		t.Fatalf("CreateMemifInterfaceWithId() failed: %v", err)
+	}
+	if swIfIndex != 6 {
+		t.Errorf("CreateMemifInterfaceWithId() = %d, want 6", swIfIndex)
+	}
+
+	req := &memif.MemifCreate{}
+	if err = vpp.Requests("memif_create")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SocketID != 2 || req.ID != 1 || MemifRole(req.Role) != RoleSlave || req.RxQueues != 4 || req.TxQueues != 2 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestDeleteMemifInterfaceLastOnSocket(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	// The interface is gone from the second dump, after the delete.
+	dumps := 0
+	vpp.OnRequest("memif_dump", func(req *vppmock.Request) []api.Message {
+		dumps++
+		if dumps == 1 {
+			return []api.Message{memifDetails(5, 2)}
+		}
+		return nil
+	})
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+	vpp.Reply("memif_socket_filename_dump",
+		socketDetails(0, "/run/vpp/memif.sock"),
+		socketDetails(2, "/var/run/vpp/cni/shared/memif-2.sock"))
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+
+	if err := DeleteMemifInterface(vpp.Ch, 5); err != nil {
+		t.Fatalf("DeleteMemifInterface() failed: %v", err)
+	}
+
+	reqs := vpp.Requests("memif_socket_filename_add_del")
+	if len(reqs) != 1 {
+		t.Fatalf("got %d memif_socket_filename_add_del requests, want 1", len(reqs))
+	}
+	req := &memif.MemifSocketFilenameAddDel{}
+	if err := reqs[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.IsAdd != 0 || req.SocketID != 2 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestDeleteMemifInterfaceSharedSocket(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	// Another interface still uses the socket after the delete.
+	dumps := 0
+	vpp.OnRequest("memif_dump", func(req *vppmock.Request) []api.Message {
+		dumps++
+		if dumps == 1 {
+			return []api.Message{memifDetails(5, 2), memifDetails(6, 2)}
+		}
+		return []api.Message{memifDetails(6, 2)}
+	})
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+
+	if err := DeleteMemifInterface(vpp.Ch, 5); err != nil {
+		t.Fatalf("DeleteMemifInterface() failed: %v", err)
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 0 {
+		t.Errorf("socket file deleted while still in use")
+	}
+}
+
+func TestGetMemif(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_dump", memifDetails(4, 1), memifDetails(5, 2))
+
+	intf, found, err := GetMemif(vpp.Ch, 5)
+	if err != nil || found == false {
+		t.Fatalf("GetMemif() = %v, %v, want found", found, err)
+	}
+	if intf.IfName != "memif0/0" || intf.SocketId != 2 || intf.Role != RoleSlave ||
+		intf.HwAddr.String() != "02:fe:00:00:00:01" {
+		t.Errorf("unexpected interface %+v", intf)
+	}
+
+	if _, found, err = GetMemif(vpp.Ch, 7); err != nil || found {
+		t.Errorf("GetMemif() of a missing interface = %v, %v, want not found", found, err)
+	}
+}
+
+func TestMemifNoReply(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	// memif_create is not scripted, so VPP never answers.
+	if _, err := CreateMemifInterface(vpp.Ch, 0, RoleMaster, ModeEthernet, nil); err == nil {
+		t.Errorf("CreateMemifInterface() succeeded without a reply")
+	}
+}
+
+func TestMemifRetval(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("memif_create", &memif.MemifCreateReply{Retval: vppinfra.VppErrInvalidValue, SwIfIndex: ^uint32(0)})
+	swIfIndex, err := CreateMemifInterface(vpp.Ch, 0, RoleMaster, ModeEthernet, nil)
+	if vppinfra.IsVppError(err, vppinfra.VppErrInvalidValue) == false {
+		t.Errorf("CreateMemifInterface() = %v, want VPP error %d", err, vppinfra.VppErrInvalidValue)
+	}
+	if swIfIndex != 0 {
+		t.Errorf("CreateMemifInterface() returned SwIfIndex %d on error", swIfIndex)
+	}
+
+	vpp.Reply("memif_socket_filename_dump")
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{Retval: vppinfra.VppErrValueExist})
+	if _, err = CreateMemifSocket(vpp.Ch, "/run/vpp/memif.sock"); vppinfra.IsVppError(err, vppinfra.VppErrValueExist) == false {
+		t.Errorf("CreateMemifSocket() = %v, want VPP error %d", err, vppinfra.VppErrValueExist)
+	}
+
+	vpp.Reply("memif_dump")
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{Retval: vppinfra.VppErrInvalidSwIfIndex})
+	if err = DeleteMemifInterface(vpp.Ch, 5); vppinfra.IsVppError(err, vppinfra.VppErrInvalidSwIfIndex) == false {
+		t.Errorf("DeleteMemifInterface() = %v, want VPP error %d", err, vppinfra.VppErrInvalidSwIfIndex)
+	}
+}
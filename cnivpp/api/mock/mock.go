@@ -0,0 +1,251 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module wraps the govpp mock adapter so the 'api' library and cnivpp
+// can be unit tested without VPP. Tests script the replies to each request
+// by message name, and can check the requests that were sent.
+//
+
+package vppmock
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunixbochs/struc"
+	"github.com/sirupsen/logrus"
+
+	"git.fd.io/govpp.git/adapter/mock"
+	"git.fd.io/govpp.git/api"
+	"git.fd.io/govpp.git/core"
+	"git.fd.io/govpp.git/core/bin_api/vpe"
+)
+
+//
+// Constants
+//
+
+// Requests without a scripted reply are left unanswered, so fail fast.
+const replyTimeout = 500 * time.Millisecond
+
+// The govpp logger is global and read by the goroutines of the previous
+// connection, so only set it once.
+var setLoggerOnce sync.Once
+
+//
+// Types
+//
+
+// Called for each request with a scripted reply. Returns the replies to
+// send, in order. Return nil to send no reply, as VPP does when a dump
+// finds no entries.
+type ReplyFunc func(req *Request) []api.Message
+
+// Request received by the mock VPP.
+type Request struct {
+	Name string
+	data []byte
+}
+
+// Mock VPP instance. Ch is connected to it.
+type VPP struct {
+	*mock.VppAdapter
+	Conn *core.Connection
+	Ch   *api.Channel
+
+	lock      sync.Mutex
+	callback  func(context uint32, msgID uint16, data []byte)
+	replies   map[string]ReplyFunc
+	requests  []*Request
+	unhandled []string
+}
+
+//
+// API Functions
+//
+
+// NewVPP() - Connect to a new mock VPP. govpp allows one connection per
+//  process, so Close() must be called before the next NewVPP().
+func NewVPP() (*VPP, error) {
+	var err error
+
+	vpp := &VPP{
+		VppAdapter: &mock.VppAdapter{},
+		replies:    make(map[string]ReplyFunc),
+	}
+
+	setLoggerOnce.Do(func() {
+		core.SetLogger(&logrus.Logger{Level: logrus.ErrorLevel})
+	})
+
+	// Dumps end with the reply to the control ping sent after them.
+	vpp.Reply("control_ping", &vpe.ControlPingReply{})
+
+	vpp.VppAdapter.MockReplyHandler(vpp.handleRequest)
+
+	vpp.Conn, err = core.Connect(vpp)
+	if err != nil {
+		return nil, err
+	}
+
+	vpp.Ch, err = vpp.Conn.NewAPIChannel()
+	if err != nil {
+		vpp.Conn.Disconnect()
+		return nil, err
+	}
+	vpp.Ch.SetReplyTimeout(replyTimeout)
+
+	return vpp, nil
+}
+
+// NewTestVPP() - Connect to a new mock VPP, or fail the test. Call
+//  Close() when done.
+func NewTestVPP(t *testing.T) *VPP {
+	vpp, err := NewVPP()
+	if err != nil {
+		t.Fatalf("Unable to connect to mock VPP: %v", err)
+	}
+	return vpp
+}
+
+// Close() - Close the Channel and disconnect from the mock VPP.
+func (vpp *VPP) Close() {
+	vpp.Ch.Close()
+	vpp.Conn.Disconnect()
+}
+
+// SetMsgCallback() - Called by govpp on connect. The callback is kept so
+//  a request can be answered with several messages.
+func (vpp *VPP) SetMsgCallback(cb func(context uint32, msgID uint16, data []byte)) {
+	vpp.lock.Lock()
+	vpp.callback = cb
+	vpp.lock.Unlock()
+
+	vpp.VppAdapter.SetMsgCallback(cb)
+}
+
+// OnRequest() - Script the replies to the request with the given message
+//  name, replacing any previous script.
+func (vpp *VPP) OnRequest(name string, fn ReplyFunc) {
+	vpp.lock.Lock()
+	defer vpp.lock.Unlock()
+
+	vpp.replies[name] = fn
+}
+
+// Reply() - Script a fixed set of replies to the request with the given
+//  message name.
+func (vpp *VPP) Reply(name string, msgs ...api.Message) {
+	vpp.OnRequest(name, func(req *Request) []api.Message {
+		return msgs
+	})
+}
+
+// Requests() - Return the requests received with the given message name,
+//  in order.
+func (vpp *VPP) Requests(name string) []*Request {
+	var list []*Request
+
+	vpp.lock.Lock()
+	defer vpp.lock.Unlock()
+
+	for _, req := range vpp.requests {
+		if req.Name == name {
+			list = append(list, req)
+		}
+	}
+	return list
+}
+
+// RequestNames() - Return the names of all requests received, in order,
+//  except the control pings ending each dump.
+func (vpp *VPP) RequestNames() []string {
+	var list []string
+
+	vpp.lock.Lock()
+	defer vpp.lock.Unlock()
+
+	for _, req := range vpp.requests {
+		if req.Name != "control_ping" {
+			list = append(list, req.Name)
+		}
+	}
+	return list
+}
+
+// Unhandled() - Return the names of the requests received without a
+//  scripted reply.
+func (vpp *VPP) Unhandled() []string {
+	vpp.lock.Lock()
+	defer vpp.lock.Unlock()
+
+	return append([]string(nil), vpp.unhandled...)
+}
+
+// Decode() - Decode the request into msg, which must be of the type of the
+//  request.
+func (req *Request) Decode(msg api.Message) error {
+	buf := bytes.NewReader(req.data)
+
+	if err := struc.Unpack(buf, &core.VppRequestHeader{}); err != nil {
+		return err
+	}
+	return struc.Unpack(buf, msg)
+}
+
+//
+// Local Functions
+//
+
+// Reply handler registered with the govpp mock adapter. All but the last
+// reply are sent through the callback, the last one is returned.
+func (vpp *VPP) handleRequest(dto mock.MessageDTO) ([]byte, uint16, bool) {
+	// The mock adapter only names the control ping through GetMsgNameByID().
+	if dto.MsgName == "" {
+		dto.MsgName, _ = vpp.GetMsgNameByID(dto.MsgID)
+	}
+	req := &Request{Name: dto.MsgName, data: dto.Data}
+
+	vpp.lock.Lock()
+	vpp.requests = append(vpp.requests, req)
+	fn, ok := vpp.replies[dto.MsgName]
+	if ok == false {
+		vpp.unhandled = append(vpp.unhandled, dto.MsgName)
+	}
+	callback := vpp.callback
+	vpp.lock.Unlock()
+
+	if ok == false {
+		return nil, 0, false
+	}
+
+	msgs := fn(req)
+	for i, msg := range msgs {
+		data, err := vpp.ReplyBytes(dto, msg)
+		if err != nil {
+			return nil, 0, false
+		}
+		msgID, _ := vpp.GetMsgID(msg.GetMessageName(), msg.GetCrcString())
+
+		if i == len(msgs)-1 {
+			return data, msgID, true
+		}
+		callback(dto.ClientID, msgID, data)
+	}
+
+	return nil, 0, false
+}
@@ -21,9 +21,13 @@ package vppvhostuser
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"git.fd.io/govpp.git/api"
 	"git.fd.io/govpp.git/core/bin_api/vhost_user"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
 )
 
 //
@@ -39,8 +43,31 @@ const (
 	ModeServer VhostUserMode = 1
 )
 
-// Dump Strings
-var modeStr = [...]string{"client", "server"}
+//
+// Types
+//
+
+// Vhost-User Interface as reported by VPP.
+type VhostUserInterface struct {
+	SwIfIndex      uint32
+	IfName         string
+	Mode           VhostUserMode
+	SockFilename   string
+	SockErrno      int32
+	Features       uint64
+	NumRegions     uint32
+	VirtioNetHdrSz uint32
+}
+
+func (mode VhostUserMode) String() string {
+	switch mode {
+	case ModeClient:
+		return "client"
+	case ModeServer:
+		return "server"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(mode))
+}
 
 //
 // API Functions
@@ -59,7 +86,7 @@ func VhostUserCompatibilityCheck(ch *api.Channel) (err error) {
 	)
 	if err != nil {
 		if debugVhost {
-			fmt.Println("VPP vhostUser failed compatibility")
+			fmt.Fprintln(os.Stderr, "VPP vhostUser failed compatibility")
 		}
 	}
 
@@ -87,10 +114,13 @@ func CreateVhostUserInterface(ch *api.Channel, mode VhostUserMode, socketFile st
 	reply := &vhost_user.CreateVhostUserIfReply{}
 
 	err = ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugVhost {
-			fmt.Println("Error creating vhostUser interface:", err)
+			fmt.Fprintln(os.Stderr, "Error creating vhostUser interface:", err)
 		}
 		return
 	} else {
@@ -111,10 +141,13 @@ func DeleteVhostUserInterface(ch *api.Channel, swIfIndex uint32) (err error) {
 	reply := &vhost_user.DeleteVhostUserIfReply{}
 
 	err = ch.SendRequest(req).ReceiveReply(reply)
+	if err == nil {
+		err = vppinfra.CheckRetval(req, reply.Retval)
+	}
 
 	if err != nil {
 		if debugVhost {
-			fmt.Println("Error deleting vhostUser interface:", err)
+			fmt.Fprintln(os.Stderr, "Error deleting vhostUser interface:", err)
 		}
 		return err
 	}
@@ -122,38 +155,79 @@ func DeleteVhostUserInterface(ch *api.Channel, swIfIndex uint32) (err error) {
 	return err
 }
 
-// Dump the set of existing Vhost-User interfaces to stdout.
-func DumpVhostUser(ch *api.Channel) {
-	var count int
+// Return the set of existing Vhost-User interfaces.
+func ListVhostUser(ch *api.Channel) (list []VhostUserInterface, err error) {
 
 	// Populate the Message Structure
 	req := &vhost_user.SwInterfaceVhostUserDump{}
 	reqCtx := ch.SendMultiRequest(req)
 
-	fmt.Printf("Vhost-User Interface List:\n")
 	for {
 		reply := &vhost_user.SwInterfaceVhostUserDetails{}
-		stop, err := reqCtx.ReceiveReply(reply)
+		stop, replyErr := reqCtx.ReceiveReply(reply)
 		if stop {
 			break // break out of the loop
 		}
-		if err != nil {
-			fmt.Println("Error dumping vhostUser interface:", err)
+		if replyErr != nil {
+			if debugVhost {
+				fmt.Fprintln(os.Stderr, "Error listing vhostUser interface:", replyErr)
+			}
+			err = replyErr
+			break
 		}
-		//fmt.Printf("%+v\n", reply)
-
-		fmt.Printf("    SwIfId=%d Mode=%s IfName=%s NumReg=%d SockErrno=%d Feature=0x16%x HdrSz=%d SockFile=%s\n",
-			reply.SwIfIndex,
-			modeStr[reply.IsServer],
-			string(reply.InterfaceName),
-			reply.NumRegions,
-			reply.SockErrno,
-			reply.Features,
-			reply.VirtioNetHdrSz,
-			string(reply.SockFilename))
-
-		count++
+
+		list = append(list, VhostUserInterface{
+			SwIfIndex:      reply.SwIfIndex,
+			IfName:         vppinfra.CString(reply.InterfaceName),
+			Mode:           VhostUserMode(reply.IsServer),
+			SockFilename:   vppinfra.CString(reply.SockFilename),
+			SockErrno:      reply.SockErrno,
+			Features:       reply.Features,
+			NumRegions:     reply.NumRegions,
+			VirtioNetHdrSz: reply.VirtioNetHdrSz,
+		})
+	}
+
+	return
+}
+
+// Return the Vhost-User interface with the given swIfIndex, if it exists.
+func GetVhostUser(ch *api.Channel, swIfIndex uint32) (intf VhostUserInterface, found bool, err error) {
+
+	list, err := ListVhostUser(ch)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range list {
+		if entry.SwIfIndex == swIfIndex {
+			return entry, true, nil
+		}
+	}
+
+	return
+}
+
+// Dump the set of existing Vhost-User interfaces to the given writer.
+func DumpVhostUser(w io.Writer, ch *api.Channel) {
+
+	list, err := ListVhostUser(ch)
+
+	fmt.Fprintf(w, "Vhost-User Interface List:\n")
+	for _, intf := range list {
+		fmt.Fprintf(w, "    SwIfId=%d Mode=%s IfName=%s NumReg=%d SockErrno=%d Feature=0x%016x HdrSz=%d SockFile=%s\n",
+			intf.SwIfIndex,
+			intf.Mode,
+			intf.IfName,
+			intf.NumRegions,
+			intf.SockErrno,
+			intf.Features,
+			intf.VirtioNetHdrSz,
+			intf.SockFilename)
+	}
+	if err != nil {
+		fmt.Fprintln(w, "Error dumping vhostUser interface:", err)
 	}
 
-	fmt.Printf("  Interface Count: %d\n", count)
+	fmt.Fprintf(w, "  Interface Count: %d\n", len(list))
 }
@@ -0,0 +1,108 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vppvhostuser
+
+import (
+	"testing"
+
+	"git.fd.io/govpp.git/core/bin_api/vhost_user"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/mock"
+)
+
+func TestCreateVhostUserInterface(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("create_vhost_user_if", &vhost_user.CreateVhostUserIfReply{SwIfIndex: 3})
+
+	swIfIndex, err := CreateVhostUserInterface(vpp.Ch, ModeServer, "/var/run/vpp/cni/abc/vhost-1")
+	if err != nil {
+		t.Fatalf("CreateVhostUserInterface() failed: %v", err)
+	}
+	if swIfIndex != 3 {
+		t.Errorf("CreateVhostUserInterface() = %d, want 3", swIfIndex)
+	}
+
+	req := &vhost_user.CreateVhostUserIf{}
+	if err = vpp.Requests("create_vhost_user_if")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if VhostUserMode(req.IsServer) != ModeServer || vppinfra.CString(req.SockFilename) != "/var/run/vpp/cni/abc/vhost-1" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestDeleteVhostUserInterface(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("delete_vhost_user_if", &vhost_user.DeleteVhostUserIfReply{})
+
+	if err := DeleteVhostUserInterface(vpp.Ch, 3); err != nil {
+		t.Fatalf("DeleteVhostUserInterface() failed: %v", err)
+	}
+
+	req := &vhost_user.DeleteVhostUserIf{}
+	if err := vpp.Requests("delete_vhost_user_if")[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 3 {
+		t.Errorf("deleted SwIfIndex %d, want 3", req.SwIfIndex)
+	}
+}
+
+func TestGetVhostUser(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("sw_interface_vhost_user_dump",
+		&vhost_user.SwInterfaceVhostUserDetails{SwIfIndex: 2, InterfaceName: []byte("VirtualEthernet0/0/0")},
+		&vhost_user.SwInterfaceVhostUserDetails{
+			SwIfIndex:     3,
+			InterfaceName: []byte("VirtualEthernet0/0/1"),
+			IsServer:      uint8(ModeServer),
+			SockFilename:  []byte("/var/run/vpp/cni/abc/vhost-1"),
+		})
+
+	intf, found, err := GetVhostUser(vpp.Ch, 3)
+	if err != nil || found == false {
+		t.Fatalf("GetVhostUser() = %v, %v, want found", found, err)
+	}
+	if intf.IfName != "VirtualEthernet0/0/1" || intf.Mode != ModeServer || intf.SockFilename != "/var/run/vpp/cni/abc/vhost-1" {
+		t.Errorf("unexpected interface %+v", intf)
+	}
+
+	if _, found, err = GetVhostUser(vpp.Ch, 7); err != nil || found {
+		t.Errorf("GetVhostUser() of a missing interface = %v, %v, want not found", found, err)
+	}
+}
+
+func TestVhostUserRetval(t *testing.T) {
+	vpp := vppmock.NewTestVPP(t)
+	defer vpp.Close()
+
+	vpp.Reply("create_vhost_user_if", &vhost_user.CreateVhostUserIfReply{Retval: vppinfra.VppErrSyscallError1})
+	_, err := CreateVhostUserInterface(vpp.Ch, ModeServer, "/tmp/vhost-1")
+	if vppinfra.IsVppError(err, vppinfra.VppErrSyscallError1) == false {
+		t.Errorf("CreateVhostUserInterface() = %v, want VPP error %d", err, vppinfra.VppErrSyscallError1)
+	}
+
+	vpp.Reply("delete_vhost_user_if", &vhost_user.DeleteVhostUserIfReply{Retval: vppinfra.VppErrInvalidSwIfIndex})
+	if err = DeleteVhostUserInterface(vpp.Ch, 3); vppinfra.IsVppError(err, vppinfra.VppErrInvalidSwIfIndex) == false {
+		t.Errorf("DeleteVhostUserInterface() = %v, want VPP error %d", err, vppinfra.VppErrInvalidSwIfIndex)
+	}
+}
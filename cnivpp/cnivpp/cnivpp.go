@@ -27,17 +27,22 @@ package cnivpp
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 
 	"github.com/Billy99/user-space-net-plugin/cnivpp/api/bridge"
 	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
 	"github.com/Billy99/user-space-net-plugin/cnivpp/api/interface"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/ip"
 	"github.com/Billy99/user-space-net-plugin/cnivpp/api/memif"
 	"github.com/Billy99/user-space-net-plugin/cnivpp/api/vhostuser"
 	"github.com/Billy99/user-space-net-plugin/cnivpp/vppdb"
+	"github.com/Billy99/user-space-net-plugin/usrspk8s"
 	"github.com/Billy99/user-space-net-plugin/usrsptypes"
 )
 
@@ -51,26 +56,54 @@ const (
 
 const defaultVPPSocketDir = "/var/run/vpp/cni/shared/"
 
+// How long DEL waits for vpp-app to delete the interface in the Container.
+const remoteDeleteTimeout = 5 * time.Second
+
+// How often WaitOnContainer() checks the status reported by vpp-app.
+const containerStatusPoll = 200 * time.Millisecond
+
+// Tag of the loopback holding the gateways of NetType interface, followed
+// by the gateways.
+const gatewayLoopbackPrefix = "cni-gw-"
+
+// STATUS is polled by the runtime, so fail fast instead of waiting on a
+// VPP instance that is still starting.
+const (
+	statusConnectTimeout = 2 * time.Second
+	statusConnectRetries = 1
+)
+
 //
 // Types
 //
 type CniVpp struct {
+	// Optional long-lived Channel to VPP. If nil, each call opens its own
+	// Channel and closes it before returning.
+	VppCh *vppinfra.ConnectionData
+
+	// Glob patterns of the per pod socket directories, see
+	// NetConf.SocketDirGlob(). Sockets in these directories are owned by
+	// the CNI, along with the default and saved ones, see Inventory().
+	SocketDirGlobs []string
+
+	// Set when run by vpp-app for the container end of the connection,
+	// which takes the pod address instead of routing to it.
+	inContainer bool
 }
 
 //
 // API Functions
 //
-func (cniVpp CniVpp) AddOnHost(conf *usrsptypes.NetConf, containerID string, ipResult *current.Result) error {
-	var vppCh vppinfra.ConnectionData
-	var err error
+func (cniVpp CniVpp) AddOnHost(conf *usrsptypes.NetConf, containerID string, ipResult *current.Result) (err error) {
 	var data vppdb.VppSavedData
+	var bridged []uint32
 
 	// Create Channel to pass requests to VPP
-	vppCh, err = vppinfra.VppOpenCh()
+	vppCh, closeCh, err := cniVpp.openCh()
 	if err != nil {
 		return err
 	}
-	defer vppinfra.VppCloseCh(vppCh)
+	defer closeCh()
 
 	// Make sure version of API structs used by CNI are same as used by local VPP Instance.
 	err = compatibilityChecks(vppCh)
@@ -78,6 +111,24 @@ func (cniVpp CniVpp) AddOnHost(conf *usrsptypes.NetConf, containerID string, ipR
 		return err
 	}
 
+	// Routing to the pod needs an address for the host end, so check before
+	// anything is created.
+	if conf.HostConf.NetType == "interface" && cniVpp.inContainer == false && conf.HostConf.L3Conf.Unnumbered == "" {
+		for _, ip := range ipResult.IPs {
+			if ip.Gateway == nil {
+				return fmt.Errorf("ERROR: NetType interface needs a gateway from IPAM or host.l3.unnumbered for %s", ip.Address.IP)
+			}
+		}
+	}
+
+	// Nothing is saved for DelFromHost() to find until the end, so undo
+	// what was created so far on any failure.
+	defer func() {
+		if err != nil {
+			undoAddOnHost(vppCh, &data, bridged)
+		}
+	}()
+
 	//
 	// Create Local Interface
 	//
@@ -93,15 +144,36 @@ func (cniVpp CniVpp) AddOnHost(conf *usrsptypes.NetConf, containerID string, ipR
 		return err
 	}
 
-	//
-	// Set interface to up (1)
-	//
-	err = vppinterface.SetState(vppCh.Ch, data.SwIfIndex, 1)
-	if err != nil {
-		if dbgInterface {
-			fmt.Println("Error bringing interface UP:", err)
+	data.ContainerId = containerID
+	data.NetName = conf.Name
+	data.IfName = conf.If0name
+	data.IfType = conf.HostConf.IfType
+
+	for _, swIfIndex := range data.SwIfIndexList() {
+		//
+		// Set MTU, if provided
+		//
+		if conf.Mtu != 0 {
+			err = vppinterface.SetMtu(vppCh.Ch, swIfIndex, uint32(conf.Mtu))
+			if err != nil {
+				if dbgInterface {
+					fmt.Fprintln(os.Stderr, "Error setting MTU:", err)
+				}
+				return err
+			}
+			data.Mtu = conf.Mtu
+		}
+
+		//
+		// Set interface to up (1)
+		//
+		err = vppinterface.SetState(vppCh.Ch, swIfIndex, 1)
+		if err != nil {
+			if dbgInterface {
+				fmt.Fprintln(os.Stderr, "Error bringing interface UP:", err)
+			}
+			return err
 		}
-		return err
 	}
 
 	//
@@ -112,61 +184,69 @@ func (cniVpp CniVpp) AddOnHost(conf *usrsptypes.NetConf, containerID string, ipR
 	if conf.HostConf.NetType == "bridge" {
 
 		var bridgeDomain uint32 = uint32(conf.HostConf.BridgeConf.BridgeId)
+		data.BridgeId = bridgeDomain
 
-		// Add Interface to Bridge. If Bridge does not exist, AddBridgeInterface()
+		// Add Interfaces to Bridge. If Bridge does not exist, AddBridgeInterface()
 		// will create.
-		err = vppbridge.AddBridgeInterface(vppCh.Ch, bridgeDomain, data.SwIfIndex)
-		if err != nil {
-			if dbgBridge {
-				fmt.Println("Error:", err)
-			}
-			return err
-		} else {
-			if dbgBridge {
-				fmt.Printf("INTERFACE %d added to BRIDGE %d\n", data.SwIfIndex, bridgeDomain)
-				vppbridge.DumpBridge(vppCh.Ch, bridgeDomain)
+		for _, swIfIndex := range data.SwIfIndexList() {
+			err = vppbridge.AddBridgeInterface(vppCh.Ch, bridgeDomain, swIfIndex)
+			if err != nil {
+				if dbgBridge {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+				}
+				return err
+			} else {
+				bridged = append(bridged, swIfIndex)
+				if dbgBridge {
+					fmt.Fprintf(os.Stderr, "INTERFACE %d added to BRIDGE %d\n", swIfIndex, bridgeDomain)
+					vppbridge.DumpBridge(os.Stderr, vppCh.Ch, bridgeDomain)
+				}
 			}
 		}
-		// Add L3 Network if supplied
+		// Add L3 Network if supplied. The IPs are only set on the first interface.
 	} else if conf.HostConf.NetType == "interface" {
 		if len(ipResult.IPs) != 0 {
-			err = vppinterface.AddDelIpAddress(vppCh.Ch, data.SwIfIndex, 1, ipResult)
+			if cniVpp.inContainer {
+				err = addContainerRouting(vppCh, &data, ipResult)
+			} else {
+				err = addHostRouting(vppCh, conf, &data, ipResult)
+			}
 			if err != nil {
 				if dbgInterface {
-					fmt.Println("Error:", err)
+					fmt.Fprintln(os.Stderr, "Error:", err)
 				}
 				return err
 			}
 		}
 	}
 
+	addResultInterface(vppCh, &data, ipResult)
+	data.ContainerMac = conf.ContainerConf.Mac
+
 	//
 	// Save Create Data for Delete
 	//
 	err = vppdb.SaveVppConfig(conf, containerID, &data)
 
-	if err != nil {
-		return err
-	}
-
 	return err
 }
 
 func (cniVpp CniVpp) AddOnContainer(conf *usrsptypes.NetConf, containerID string, ipResult *current.Result) error {
+	if conf.ConfigDelivery == "annotation" {
+		return usrspk8s.SaveRemoteConfigAnnotation(conf, ipResult, containerID)
+	}
 	return vppdb.SaveRemoteConfig(conf, ipResult, containerID)
 }
 
 func (cniVpp CniVpp) DelFromHost(conf *usrsptypes.NetConf, containerID string) error {
-	var vppCh vppinfra.ConnectionData
 	var data vppdb.VppSavedData
-	var err error
 
 	// Create Channel to pass requests to VPP
-	vppCh, err = vppinfra.VppOpenCh()
+	vppCh, closeCh, err := cniVpp.openCh()
 	if err != nil {
 		return err
 	}
-	defer vppinfra.VppCloseCh(vppCh)
+	defer closeCh()
 
 	// Retrieved squirreled away data needed for processing delete
 	err = vppdb.LoadVppConfig(conf, containerID, &data)
@@ -184,75 +264,373 @@ func (cniVpp CniVpp) DelFromHost(conf *usrsptypes.NetConf, containerID string) e
 		var bridgeDomain uint32 = uint32(conf.HostConf.BridgeConf.BridgeId)
 
 		if dbgBridge {
-			fmt.Printf("INTERFACE %d retrieved from CONF - attempt to DELETE Bridge %d\n", data.SwIfIndex, bridgeDomain)
+			fmt.Fprintf(os.Stderr, "INTERFACE %d retrieved from CONF - attempt to DELETE Bridge %d\n", data.SwIfIndex, bridgeDomain)
 		}
 
-		// Remove MemIf from Bridge. RemoveBridgeInterface() will delete Bridge if
+		// Remove MemIfs from Bridge. RemoveBridgeInterface() will delete Bridge if
 		// no more interfaces are associated with the Bridge.
-		err = vppbridge.RemoveBridgeInterface(vppCh.Ch, bridgeDomain, data.SwIfIndex)
+		for _, swIfIndex := range data.SwIfIndexList() {
+			err = vppbridge.RemoveBridgeInterface(vppCh.Ch, bridgeDomain, swIfIndex)
 
-		if err != nil {
-			if dbgBridge {
-				fmt.Println("Error:", err)
+			// The interface is already gone, like after a VPP restart.
+			if vppinfra.IsVppError(err, vppinfra.VppErrInvalidSwIfIndex) {
+				err = nil
 			}
-			return err
-		} else {
-			if dbgBridge {
-				fmt.Printf("INTERFACE %d removed from BRIDGE %d\n", data.SwIfIndex, bridgeDomain)
-				vppbridge.DumpBridge(vppCh.Ch, bridgeDomain)
+
+			if err != nil {
+				if dbgBridge {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+				}
+				return err
+			} else {
+				if dbgBridge {
+					fmt.Fprintf(os.Stderr, "INTERFACE %d removed from BRIDGE %d\n", swIfIndex, bridgeDomain)
+					vppbridge.DumpBridge(os.Stderr, vppCh.Ch, bridgeDomain)
+				}
 			}
 		}
 	}
 
 	//
-	// Delete Local Interface
+	// Remove the routes through the interface. The addresses go with it.
+	//
+	delRouting(vppCh, &data)
+
+	//
+	// Delete Local Interface. In the Container, the socket file belongs to
+	// the host.
 	//
 	if conf.HostConf.IfType == "memif" {
-		return delLocalDeviceMemif(vppCh, conf, containerID, &data)
+		err = delLocalDeviceMemif(vppCh, conf, containerID, &data, cniVpp.inContainer == false)
 	} else if conf.HostConf.IfType == "vhostuser" {
 		return fmt.Errorf("GOOD: Found HostConf.Type:" + conf.HostConf.IfType)
 	} else {
 		return fmt.Errorf("ERROR: Unknown HostConf.Type:" + conf.HostConf.IfType)
 	}
 
+	//
+	// Delete the gateway loopback, once no longer borrowed from.
+	//
+	if err == nil {
+		err = releaseGatewayLoopback(vppCh, &data)
+	}
+
 	return err
 }
 
 func (cniVpp CniVpp) DelFromContainer(conf *usrsptypes.NetConf, containerID string) error {
+	if conf.ConfigDelivery == "annotation" {
+		return usrspk8s.DeleteRemoteConfigAnnotation(conf)
+	}
+
+	// Have vpp-app delete the interface from the VPP in the Container before
+	// its saved data goes with the directory. A Container that is already
+	// gone never reports back, so only wait so long.
+	deleted, err := vppdb.RequestRemoteDelete(conf, containerID, remoteDeleteTimeout)
+	if dbgInterface {
+		fmt.Fprintln(os.Stderr, "Container reported DEL back:", deleted, err)
+	}
+
 	vppdb.CleanupRemoteConfig(conf, containerID)
+	return err
+}
+
+// Status() - Report whether the local VPP instance can be reached. Used to
+//  answer CNI STATUS.
+func (cniVpp CniVpp) Status() error {
+	if cniVpp.VppCh != nil {
+		return nil
+	}
+
+	opts := vppinfra.DefaultConnectOptions()
+	opts.ConnectTimeout = statusConnectTimeout
+	opts.Retries = statusConnectRetries
+
+	vppCh, err := vppinfra.VppOpenChWithOptions(opts)
+	if err != nil {
+		return err
+	}
+	vppinfra.VppCloseCh(vppCh)
+
 	return nil
 }
 
-func CniContainerConfig() (bool, error) {
+// Process any remote config written by the host. vppCh is the caller's
+// Channel to the local VPP instance and is left open.
+func CniContainerConfig(vppCh *vppinfra.ConnectionData) (bool, error) {
 
-	vpp := CniVpp{}
+	vpp := CniVpp{VppCh: vppCh, inContainer: true}
 
 	found, conf, ipResult, containerId, err := vppdb.FindRemoteConfig()
 
 	if err == nil {
 		if found {
 			if dbgInterface {
-				fmt.Println("ipResult:")
-				fmt.Println(ipResult)
+				fmt.Fprintln(os.Stderr, "ipResult:")
+				fmt.Fprintln(os.Stderr, ipResult)
 			}
 
 			err = vpp.AddOnHost(&conf, containerId, &ipResult)
 
 			if err != nil {
 				if dbgInterface {
-					fmt.Println(err)
+					fmt.Fprintln(os.Stderr, err)
 				}
 			}
+
+			// Report the outcome back to the host, which may be waiting on it.
+			statusErr := saveContainerStatus(*vppCh, &conf, containerId, err)
+			if err == nil {
+				err = statusErr
+			}
 		}
 	}
 
 	return found, err
 }
 
+// Refresh the link state in the status reported back to the host for the
+// remote configs applied by CniContainerConfig(). Returns true if any
+// changed. vppCh is the caller's Channel and is left open.
+func CniContainerStatus(vppCh *vppinfra.ConnectionData) (bool, error) {
+	changed := false
+
+	list, err := vppdb.ListRemoteStatus()
+	if err != nil {
+		return false, err
+	}
+
+	for _, status := range list {
+		if status.State != usrsptypes.RemoteStatusApplied {
+			continue
+		}
+
+		// Deleted by a DEL in the meantime.
+		found, data, err := findSavedData(status.ContainerId, status.IfName)
+		if err != nil {
+			return changed, err
+		}
+		if found == false {
+			continue
+		}
+
+		linkUp, err := memifLinkUp(*vppCh, &data)
+		if err != nil {
+			return changed, err
+		}
+		if linkUp == status.LinkUp {
+			continue
+		}
+
+		changed = true
+		status.LinkUp = linkUp
+		if err = vppdb.SaveRemoteStatus(&status); err != nil {
+			return changed, err
+		}
+	}
+
+	return changed, nil
+}
+
+// WaitOnContainer() - On the host, wait up to timeout for vpp-app to apply
+//  the remote config written by AddOnContainer() and report the link of the
+//  interface up. Fails early if vpp-app reports it failed to apply it.
+func (cniVpp CniVpp) WaitOnContainer(conf *usrsptypes.NetConf, containerID string, timeout time.Duration) error {
+	var status usrsptypes.RemoteStatus
+	var found bool
+	var err error
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(containerStatusPoll) {
+		found, status, err = vppdb.LoadRemoteStatus(conf, containerID)
+		if err != nil {
+			return err
+		}
+		if found == false {
+			continue
+		}
+
+		if status.State == usrsptypes.RemoteStatusFailed {
+			return fmt.Errorf("ERROR: Container failed to apply the config for %s: %s", conf.If0name, status.Error)
+		}
+		if status.LinkUp {
+			return nil
+		}
+	}
+
+	if found == false {
+		return fmt.Errorf("ERROR: Container did not apply the config for %s within %v", conf.If0name, timeout)
+	}
+	return fmt.Errorf("ERROR: Link of %s in the Container not up within %v", conf.If0name, timeout)
+}
+
+// Process the remote configs delivered as pod annotations, read from the
+// downward API annotations file at path. Annotations already in done are
+// skipped, and the ones processed are added to done, since the file keeps
+// all of them. An annotation in done that was removed is a DEL, and its
+// interface is deleted. vppCh is the caller's Channel and is left open.
+func CniContainerConfigFromAnnotations(vppCh *vppinfra.ConnectionData, path string, done map[string]usrsptypes.RemoteConfig) (bool, error) {
+
+	vpp := CniVpp{VppCh: vppCh, inContainer: true}
+	found := false
+
+	configs, err := usrspk8s.ReadRemoteConfigAnnotations(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for key, remote := range configs {
+		if _, ok := done[key]; ok {
+			continue
+		}
+		found = true
+
+		if dbgInterface {
+			fmt.Fprintln(os.Stderr, "ipResult:")
+			fmt.Fprintln(os.Stderr, remote.IPResult)
+		}
+
+		err = vpp.AddOnHost(&remote.NetConf, remote.ContainerId, &remote.IPResult)
+		if err != nil {
+			return found, fmt.Errorf("ERROR: annotation %s: %v", key, err)
+		}
+		done[key] = remote
+	}
+
+	for key, remote := range done {
+		if _, ok := configs[key]; ok {
+			continue
+		}
+		found = true
+
+		// Not retried, the annotation is gone either way.
+		delete(done, key)
+		err = vpp.DelFromHost(&remote.NetConf, remote.ContainerId)
+		if err != nil {
+			return found, fmt.Errorf("ERROR: annotation %s: DEL: %v", key, err)
+		}
+	}
+
+	return found, nil
+}
+
+// Process the deletion markers written by the host on DEL: delete the
+// interface from the local VPP instance, then remove the marker to report
+// back to the host. vppCh is the caller's Channel and is left open.
+func CniContainerDelete(vppCh *vppinfra.ConnectionData) (bool, error) {
+
+	vpp := CniVpp{VppCh: vppCh, inContainer: true}
+
+	paths, err := vppdb.ListRemoteDelete()
+	if err != nil {
+		return false, err
+	}
+
+	for _, path := range paths {
+		remote, err := vppdb.ReadRemoteDelete(path)
+		if err == nil {
+			err = vpp.DelFromHost(&remote.NetConf, remote.ContainerId)
+		}
+
+		// The marker is removed even on error, the host cleans up the
+		// saved data either way and would otherwise wait for nothing.
+		os.Remove(path)
+
+		if err != nil {
+			return true, fmt.Errorf("ERROR: %s: %v", filepath.Base(path), err)
+		}
+	}
+
+	return len(paths) != 0, nil
+}
+
 //
 // Local Functions
 //
 
+// Return the Channel to use for a request and the function to release it.
+// A long-lived Channel supplied by the caller is reused and left open.
+func (cniVpp CniVpp) openCh() (vppinfra.ConnectionData, func(), error) {
+	if cniVpp.VppCh != nil {
+		return *cniVpp.VppCh, func() {}, nil
+	}
+
+	vppCh, err := vppinfra.VppOpenCh()
+	if err != nil {
+		return vppCh, nil, err
+	}
+
+	return vppCh, func() { vppinfra.VppCloseCh(vppCh) }, nil
+}
+
+// Write the status of the interface created from the remote config conf,
+// or of addErr if it could not be created, for the host to read.
+func saveContainerStatus(vppCh vppinfra.ConnectionData, conf *usrsptypes.NetConf, containerID string, addErr error) error {
+	status := usrsptypes.RemoteStatus{
+		ContainerId: containerID,
+		IfName:      conf.If0name,
+		State:       usrsptypes.RemoteStatusApplied,
+	}
+
+	if addErr != nil {
+		status.State = usrsptypes.RemoteStatusFailed
+		status.Error = addErr.Error()
+	} else {
+		found, data, err := findSavedData(containerID, conf.If0name)
+		if err != nil {
+			return err
+		}
+		if found {
+			if status.LinkUp, err = memifLinkUp(vppCh, &data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return vppdb.SaveRemoteStatus(&status)
+}
+
+// Return the data saved for interface ifName of the given container,
+// leaving it in place.
+func findSavedData(containerID string, ifName string) (bool, vppdb.VppSavedData, error) {
+	list, err := vppdb.ListVppConfig()
+	if err != nil {
+		return false, vppdb.VppSavedData{}, err
+	}
+
+	for _, data := range list {
+		if data.ContainerId == containerID && data.IfName == ifName {
+			return true, data, nil
+		}
+	}
+
+	return false, vppdb.VppSavedData{}, nil
+}
+
+// Return true if the link of every memif in data is up, which it is once
+// the other end connected.
+func memifLinkUp(vppCh vppinfra.ConnectionData, data *vppdb.VppSavedData) (bool, error) {
+	list, err := vppmemif.ListMemif(vppCh.Ch)
+	if err != nil {
+		return false, err
+	}
+
+	linkUp := make(map[uint32]bool)
+	for _, intf := range list {
+		linkUp[intf.SwIfIndex] = intf.LinkUp
+	}
+
+	for _, swIfIndex := range data.SwIfIndexList() {
+		if linkUp[swIfIndex] == false {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
 func compatibilityChecks(vppCh vppinfra.ConnectionData) (err error) {
 
 	// Compatibility Checks
@@ -276,20 +654,34 @@ func compatibilityChecks(vppCh vppinfra.ConnectionData) (err error) {
 		return
 	}
 
+	err = vppip.IpCompatibilityCheck(vppCh.Ch)
+	if err != nil {
+		return
+	}
+
 	return
 }
 
 func addLocalDeviceMemif(vppCh vppinfra.ConnectionData, conf *usrsptypes.NetConf, containerID string, data *vppdb.VppSavedData) (err error) {
-	var ok bool
-
 	// Validate and convert input data
-	var memifSocketFile string
 	var memifRole vppmemif.MemifRole
-	var memifMode vppmemif.MemifMode
+	var hwAddr net.HardwareAddr
 
-	if memifSocketFile, ok = os.LookupEnv("USERSPACE_MEMIF_SOCKFILE"); ok == false {
-		fileName := fmt.Sprintf("memif-%s-%s.sock", containerID[:12], conf.If0name)
-		memifSocketFile = filepath.Join(defaultVPPSocketDir, fileName)
+	memifSocketFile, perPod, err := getMemifSocketFile(conf, containerID)
+	if err != nil {
+		return err
+	}
+	if data.SocketDirOwned, err = conf.PrepareSocketDir(filepath.Dir(memifSocketFile), perPod); err != nil {
+		return err
+	}
+
+	// Pass the resolved path on to the container.
+	conf.RuntimeConfig.SocketPath = memifSocketFile
+
+	if conf.HostConf.Mac != "" {
+		if hwAddr, err = net.ParseMAC(conf.HostConf.Mac); err != nil {
+			return fmt.Errorf("ERROR: Invalid MEMIF MAC:%s", conf.HostConf.Mac)
+		}
 	}
 
 	if conf.HostConf.MemifConf.Role == "master" {
@@ -303,73 +695,391 @@ func addLocalDeviceMemif(vppCh vppinfra.ConnectionData, conf *usrsptypes.NetConf
 	if conf.HostConf.MemifConf.Mode == "" {
 		conf.HostConf.MemifConf.Mode = "ethernet"
 	}
-	if conf.HostConf.MemifConf.Mode == "ethernet" {
-		memifMode = vppmemif.ModeEthernet
-	} else if conf.HostConf.MemifConf.Mode == "ip" {
-		memifMode = vppmemif.ModeIP
-	} else if conf.HostConf.MemifConf.Mode == "inject-punt" {
-		memifMode = vppmemif.ModePuntInject
-	} else {
-		return fmt.Errorf("ERROR: Invalid MEMIF Mode:" + conf.HostConf.MemifConf.Mode)
+	memifList := conf.HostConf.MemifConf.InterfaceList(conf.If0name)
+	memifModes := make([]vppmemif.MemifMode, len(memifList))
+	for i, memifConf := range memifList {
+		if memifModes[i], err = getMemifMode(memifConf.Mode); err != nil {
+			return err
+		}
 	}
 
 	// Create Memif Socket
+	data.SocketFile = memifSocketFile
 	data.MemifSocketId, err = vppmemif.CreateMemifSocket(vppCh.Ch, memifSocketFile)
 	if err != nil {
 		if dbgInterface {
-			fmt.Println("Error:", err)
+			fmt.Fprintln(os.Stderr, "Error:", err)
 		}
 		return
 	} else {
 		if dbgInterface {
-			fmt.Println("MEMIF SOCKET", data.MemifSocketId, memifSocketFile, "created")
-			vppmemif.DumpMemifSocket(vppCh.Ch)
+			fmt.Fprintln(os.Stderr, "MEMIF SOCKET", data.MemifSocketId, memifSocketFile, "created")
+			vppmemif.DumpMemifSocket(os.Stderr, vppCh.Ch)
 		}
 	}
 
-	// Create MemIf Interface
-	data.SwIfIndex, err = vppmemif.CreateMemifInterface(vppCh.Ch, data.MemifSocketId, memifRole, memifMode)
-	if err != nil {
-		if dbgInterface {
-			fmt.Println("Error:", err)
+	// Create MemIf Interfaces, one per memif ID. The MAC is only used for the
+	// first one.
+	for i, memifConf := range memifList {
+		var swIfIndex uint32
+
+		swIfIndex, err = vppmemif.CreateMemifInterfaceWithId(vppCh.Ch, data.MemifSocketId, memifConf.Id, memifRole, memifModes[i],
+			uint8(memifConf.RxQueues), uint8(memifConf.TxQueues), hwAddr)
+		if err != nil {
+			if dbgInterface {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+			}
+			return
+		} else {
+			if dbgInterface {
+				fmt.Fprintln(os.Stderr, "MEMIF", swIfIndex, "created", memifConf.Name)
+				vppmemif.DumpMemif(os.Stderr, vppCh.Ch)
+			}
 		}
-		return
+
+		data.SwIfIndexes = append(data.SwIfIndexes, swIfIndex)
+		hwAddr = nil
+	}
+	data.SwIfIndex = data.SwIfIndexes[0]
+	if len(data.SwIfIndexes) == 1 {
+		data.SwIfIndexes = nil
+	}
+
+	// Make the socket usable by the configured uid, or back out.
+	err = conf.SetSocketPermissions(memifSocketFile)
+
+	return
+}
+
+// Delete what AddOnHost() created before failing, as recorded in data so
+// far, in the order of DelFromHost(): the routes, the bridge membership of
+// the interfaces in bridged, the interfaces with their unnumbered binding,
+// the socket, the gateway loopback and the socket directory created for the
+// pod. Errors are ignored, the failure that caused the undo is returned.
+func undoAddOnHost(vppCh vppinfra.ConnectionData, data *vppdb.VppSavedData, bridged []uint32) {
+
+	delRouting(vppCh, data)
+
+	// The bridge is deleted with its last interface.
+	for _, swIfIndex := range bridged {
+		if err := vppbridge.RemoveBridgeInterface(vppCh.Ch, data.BridgeId, swIfIndex); err != nil && dbgBridge {
+			fmt.Fprintln(os.Stderr, "Error removing INTERFACE", swIfIndex, "from BRIDGE", data.BridgeId, err)
+		}
+	}
+
+	// swIfIndex 0 is local0, never one of the interfaces.
+	created := data.SwIfIndexes
+	if len(created) == 0 && data.SwIfIndex != 0 {
+		created = []uint32{data.SwIfIndex}
+	}
+
+	// The socket is deleted with the last interface using it, or released
+	// here if no interface was created on it.
+	for _, swIfIndex := range created {
+		if err := vppmemif.DeleteMemifInterface(vppCh.Ch, swIfIndex); err != nil && dbgInterface {
+			fmt.Fprintln(os.Stderr, "Error deleting MEMIF", swIfIndex, err)
+		}
+	}
+	if len(created) == 0 {
+		vppmemif.ReleaseMemifSocket(vppCh.Ch, data.MemifSocketId)
+	}
+
+	// Nothing was saved for this interface, so only the saved data of others
+	// keeps the loopback.
+	if err := releaseGatewayLoopback(vppCh, data); err != nil && dbgInterface {
+		fmt.Fprintln(os.Stderr, "Error deleting LOOPBACK", data.GatewayLoopback, err)
+	}
+
+	// Only the pod uses a directory created for it.
+	if data.SocketDirOwned {
+		os.Remove(data.SocketFile)
+		os.Remove(filepath.Dir(data.SocketFile))
+	}
+}
+
+// Add the host side interfaces to the CNI result. The VPP interface names
+// and MACs are read back from VPP, and are left empty if the lookup fails.
+func addResultInterface(vppCh vppinfra.ConnectionData, data *vppdb.VppSavedData, ipResult *current.Result) {
+	var memifList []vppmemif.MemifInterface
+	var err error
+
+	if data.IfType == "memif" {
+		memifList, err = vppmemif.ListMemif(vppCh.Ch)
+		if err != nil && dbgInterface {
+			fmt.Fprintln(os.Stderr, "Unable to list MEMIF", err)
+		}
+	}
+
+	for i, swIfIndex := range data.SwIfIndexList() {
+		intf := &current.Interface{
+			Mtu:        data.Mtu,
+			SocketPath: data.SocketFile,
+		}
+
+		for _, memifIntf := range memifList {
+			if memifIntf.SwIfIndex == swIfIndex {
+				intf.Name = memifIntf.IfName
+				intf.Mac = memifIntf.HwAddr.String()
+				break
+			}
+		}
+		if i == 0 {
+			data.Mac = intf.Mac
+		}
+
+		ipResult.Interfaces = append(ipResult.Interfaces, intf)
+	}
+}
+
+// Point-to-point L3 on the host: the interface is unnumbered, borrowing
+// the gateways from a loopback shared by the pods with the same gateways, or
+// the address of the configured interface, and VPP routes each address of
+// the pod through it. AddOnHost() checked that there is a gateway when
+// needed.
+func addHostRouting(vppCh vppinfra.ConnectionData, conf *usrsptypes.NetConf, data *vppdb.VppSavedData, ipResult *current.Result) error {
+	var unnumbered uint32
+	var err error
+
+	if conf.HostConf.L3Conf.Unnumbered != "" {
+		unnumbered, err = vppinterface.FindInterface(vppCh.Ch, conf.HostConf.L3Conf.Unnumbered)
 	} else {
+		unnumbered, err = addGatewayLoopback(vppCh, data, ipResult)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = vppinterface.SetUnnumbered(vppCh.Ch, data.SwIfIndex, unnumbered, 1)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ipResult.IPs {
+		route := vppip.HostPrefix(ip.Address.IP)
+		err := vppip.AddDelRoute(vppCh.Ch, data.SwIfIndex, 1, route, ip.Address.IP)
+		if err != nil {
+			return err
+		}
+		data.Routes = append(data.Routes, route.String())
+	}
+
+	return nil
+}
+
+// Return the loopback holding the gateways of ipResult, created with the
+// gateways as /32 (/128) addresses if no pod uses them yet. VPP does not
+// allow the same address on two interfaces, so the pods share it.
+func addGatewayLoopback(vppCh vppinfra.ConnectionData, data *vppdb.VppSavedData, ipResult *current.Result) (uint32, error) {
+	var gateways []string
+
+	for _, ip := range ipResult.IPs {
+		gateways = append(gateways, ip.Gateway.String())
+	}
+	tag := gatewayLoopbackPrefix + strings.Join(gateways, ",")
+
+	found, swIfIndex, err := vppinterface.FindInterfaceByTag(vppCh.Ch, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	if found == false {
+		swIfIndex, err = vppinterface.CreateLoopback(vppCh.Ch, tag)
+		if err != nil {
+			return 0, err
+		}
+
+		err = vppinterface.SetState(vppCh.Ch, swIfIndex, 1)
+		for _, ip := range ipResult.IPs {
+			if err == nil {
+				err = vppinterface.AddDelAddress(vppCh.Ch, swIfIndex, 1, vppip.HostPrefix(ip.Gateway))
+			}
+		}
+		if err != nil {
+			vppinterface.DeleteLoopback(vppCh.Ch, swIfIndex)
+			return 0, err
+		}
+
 		if dbgInterface {
-			fmt.Println("MEMIF", data.SwIfIndex, "created", conf.If0name)
-			vppmemif.DumpMemif(vppCh.Ch)
+			fmt.Fprintln(os.Stderr, "LOOPBACK", swIfIndex, "created", tag)
 		}
 	}
 
-	return
+	data.GatewayLoopback = tag
+	return swIfIndex, nil
 }
 
-func delLocalDeviceMemif(vppCh vppinfra.ConnectionData, conf *usrsptypes.NetConf, containerID string, data *vppdb.VppSavedData) (err error) {
+// Delete the gateway loopback of the interface, unless the saved data of
+// another interface still uses it. Already gone, like after a VPP restart,
+// is not an error.
+func releaseGatewayLoopback(vppCh vppinfra.ConnectionData, data *vppdb.VppSavedData) error {
+	if data.GatewayLoopback == "" {
+		return nil
+	}
 
-	var ok bool
-	var memifSocketFile string
+	list, err := vppdb.ListVppConfig()
+	if err != nil {
+		return err
+	}
+	for _, saved := range list {
+		if saved.GatewayLoopback == data.GatewayLoopback &&
+			(saved.ContainerId != data.ContainerId || saved.IfName != data.IfName) {
+			return nil
+		}
+	}
+
+	found, swIfIndex, err := vppinterface.FindInterfaceByTag(vppCh.Ch, data.GatewayLoopback)
+	if err != nil || found == false {
+		return err
+	}
+
+	if dbgInterface {
+		fmt.Fprintln(os.Stderr, "LOOPBACK", swIfIndex, "deleted", data.GatewayLoopback)
+	}
+	return vppinterface.DeleteLoopback(vppCh.Ch, swIfIndex)
+}
+
+// Remove the routes added by addHostRouting() and addContainerRouting().
+func delRouting(vppCh vppinfra.ConnectionData, data *vppdb.VppSavedData) {
+
+	for _, routeStr := range data.Routes {
+		_, route, err := net.ParseCIDR(routeStr)
+		if err == nil {
+			err = vppip.AddDelRoute(vppCh.Ch, data.SwIfIndex, 0, *route, route.IP)
+		}
+		if err != nil && dbgInterface {
+			fmt.Fprintln(os.Stderr, "Error deleting route", routeStr, err)
+		}
+	}
+
+	for _, gatewayStr := range data.Gateways {
+		var err error
 
-	if memifSocketFile, ok = os.LookupEnv("USERSPACE_MEMIF_SOCKFILE"); ok == false {
-		fileName := fmt.Sprintf("memif-%s-%s.sock", containerID[:12], conf.If0name)
-		memifSocketFile = filepath.Join(defaultVPPSocketDir, fileName)
+		gateway := net.ParseIP(gatewayStr)
+		if gateway != nil {
+			err = vppip.AddDelRoute(vppCh.Ch, data.SwIfIndex, 0, vppip.DefaultPrefix(gateway), gateway)
+		}
+		if err != nil && dbgInterface {
+			fmt.Fprintln(os.Stderr, "Error deleting default route via", gatewayStr, err)
+		}
 	}
+}
 
-	err = vppmemif.DeleteMemifInterface(vppCh.Ch, data.SwIfIndex)
+// L3 in the container: the interface takes the pod address, with a default
+// route through the gateway on the host end.
+func addContainerRouting(vppCh vppinfra.ConnectionData, data *vppdb.VppSavedData, ipResult *current.Result) error {
+
+	err := vppinterface.AddDelIpAddress(vppCh.Ch, data.SwIfIndex, 1, ipResult)
 	if err != nil {
-		if dbgInterface {
-			fmt.Println("Error:", err)
+		return err
+	}
+
+	for _, ip := range ipResult.IPs {
+		if ip.Gateway != nil {
+			err = vppip.AddDelRoute(vppCh.Ch, data.SwIfIndex, 1, vppip.DefaultPrefix(ip.Gateway), ip.Gateway)
+			if err != nil {
+				return err
+			}
+			data.Gateways = append(data.Gateways, ip.Gateway.String())
 		}
-		return
-	} else {
-		if dbgInterface {
-			fmt.Printf("INTERFACE %d deleted\n", data.SwIfIndex)
-			vppmemif.DumpMemif(vppCh.Ch)
-			vppmemif.DumpMemifSocket(vppCh.Ch)
+	}
+
+	return nil
+}
+
+func delLocalDeviceMemif(vppCh vppinfra.ConnectionData, conf *usrsptypes.NetConf, containerID string, data *vppdb.VppSavedData,
+	removeSocketFile bool) (err error) {
+
+	// Use the socket file recorded on create, older saved data doesn't have it.
+	memifSocketFile := data.SocketFile
+	if memifSocketFile == "" {
+		if memifSocketFile, _, err = getMemifSocketFile(conf, containerID); err != nil {
+			return
+		}
+	}
+
+	// The socket is deleted with the last interface using it.
+	gone := false
+	for _, swIfIndex := range data.SwIfIndexList() {
+		err = vppmemif.DeleteMemifInterface(vppCh.Ch, swIfIndex)
+
+		// The interface is already gone, like after a VPP restart.
+		if vppinfra.IsVppError(err, vppinfra.VppErrInvalidSwIfIndex) {
+			gone = true
+			err = nil
+		}
+		if err != nil {
+			if dbgInterface {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+			}
+			return
+		} else {
+			if dbgInterface {
+				fmt.Fprintf(os.Stderr, "INTERFACE %d deleted\n", swIfIndex)
+				vppmemif.DumpMemif(os.Stderr, vppCh.Ch)
+				vppmemif.DumpMemifSocket(os.Stderr, vppCh.Ch)
+			}
+		}
+	}
+
+	// Still release the socket used by an interface that was already gone,
+	// then clean up the socket file.
+	if gone {
+		if err = vppmemif.ReleaseMemifSocket(vppCh.Ch, data.MemifSocketId); err != nil {
+			return
 		}
 	}
 
+	if removeSocketFile == false {
+		return
+	}
+
 	// Remove file
 	err = vppdb.FileCleanup("", memifSocketFile)
 
+	// Remove the per pod directory once its last socket is gone. Fails
+	// harmlessly while other sockets remain.
+	if data.SocketDirOwned {
+		os.Remove(filepath.Dir(memifSocketFile))
+	}
+
 	return
 }
+
+// Convert the memif mode from the NetConf.
+func getMemifMode(mode string) (vppmemif.MemifMode, error) {
+	if mode == "ethernet" {
+		return vppmemif.ModeEthernet, nil
+	} else if mode == "ip" {
+		return vppmemif.ModeIP, nil
+	} else if mode == "inject-punt" {
+		return vppmemif.ModePuntInject, nil
+	}
+	return vppmemif.ModeEthernet, fmt.Errorf("ERROR: Invalid MEMIF Mode:" + mode)
+}
+
+// Return the memif socket file for the interface. In order of precedence:
+// the per pod socketPath override, the USERSPACE_MEMIF_SOCKFILE environment
+// variable, or a file named after the container in the socketDir, which
+// defaults to the shared directory. Also returns whether the directory is
+// the pod's own, from the socketDir template.
+func getMemifSocketFile(conf *usrsptypes.NetConf, containerID string) (string, bool, error) {
+	if conf.RuntimeConfig.SocketPath != "" {
+		return conf.RuntimeConfig.SocketPath, false, nil
+	}
+
+	if memifSocketFile, ok := os.LookupEnv("USERSPACE_MEMIF_SOCKFILE"); ok {
+		return memifSocketFile, false, nil
+	}
+
+	dir, err := conf.ResolveSocketDir(containerID)
+	if err != nil {
+		return "", false, err
+	}
+	perPod := dir != ""
+	if dir == "" {
+		dir = defaultVPPSocketDir
+	}
+
+	fileName := fmt.Sprintf("memif-%s-%s.sock", containerID[:12], conf.If0name)
+	return filepath.Join(dir, fileName), perPod, nil
+}
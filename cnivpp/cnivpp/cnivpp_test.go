@@ -0,0 +1,1223 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnivpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"git.fd.io/govpp.git/api"
+	"git.fd.io/govpp.git/core/bin_api/interfaces"
+	"git.fd.io/govpp.git/core/bin_api/ip"
+	"git.fd.io/govpp.git/core/bin_api/l2"
+	"git.fd.io/govpp.git/core/bin_api/memif"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/memif"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/mock"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/vppdb"
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+const testContainerID = "0123456789abcdef0123456789abcdef"
+
+// Set up a mock VPP, a CniVpp using it and a temporary directory for the
+// saved data and socket files.
+func setup(t *testing.T) (*vppmock.VPP, CniVpp, string) {
+	dir, err := ioutil.TempDir("", "cnivpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vppdb.SetBaseDir(dir)
+
+	vpp, err := vppmock.NewVPP()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Unable to connect to mock VPP: %v", err)
+	}
+
+	return vpp, CniVpp{VppCh: &vppinfra.ConnectionData{Ch: vpp.Ch}}, dir
+}
+
+func memifBridgeConf(dir string) *usrsptypes.NetConf {
+	conf := &usrsptypes.NetConf{}
+	conf.Name = "userspace-vpp-net"
+	conf.If0name = "net1"
+	conf.Mtu = 9000
+	conf.HostConf.Engine = "vpp"
+	conf.HostConf.IfType = "memif"
+	conf.HostConf.NetType = "bridge"
+	conf.HostConf.MemifConf.Role = "master"
+	conf.HostConf.BridgeConf.BridgeId = 4
+	conf.RuntimeConfig.SocketPath = filepath.Join(dir, "pod", "memif-net1.sock")
+	return conf
+}
+
+func TestAddDelOnHostMemifBridge(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	socketFile := filepath.Join(dir, "pod", "memif-net1.sock")
+	conf := memifBridgeConf(dir)
+	ipResult := &current.Result{}
+
+	// The socket and bridge don't exist yet.
+	vpp.Reply("memif_socket_filename_dump")
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+	vpp.Reply("memif_create", &memif.MemifCreateReply{SwIfIndex: 5})
+	vpp.Reply("sw_interface_set_mtu", &interfaces.SwInterfaceSetMtuReply{})
+	vpp.Reply("sw_interface_set_flags", &interfaces.SwInterfaceSetFlagsReply{})
+	vpp.Reply("bridge_domain_dump")
+	vpp.Reply("bridge_domain_add_del", &l2.BridgeDomainAddDelReply{})
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+	vpp.Reply("memif_dump", &memif.MemifDetails{
+		SwIfIndex: 5,
+		IfName:    []byte("memif1/0"),
+		HwAddr:    []byte{0x02, 0xfe, 0x00, 0x00, 0x00, 0x05},
+		SocketID:  1,
+	})
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, ipResult); err != nil {
+		t.Fatalf("AddOnHost() failed: %v", err)
+	}
+
+	want := []string{
+		"memif_socket_filename_dump",
+		"memif_socket_filename_add_del",
+		"memif_create",
+		"sw_interface_set_mtu",
+		"sw_interface_set_flags",
+		"bridge_domain_dump",
+		"bridge_domain_add_del",
+		"sw_interface_set_l2_bridge",
+		"memif_dump",
+	}
+	if got := vpp.RequestNames(); reflect.DeepEqual(got, want) == false {
+		t.Errorf("AddOnHost() sent %v, want %v", got, want)
+	}
+	if unhandled := vpp.Unhandled(); len(unhandled) != 0 {
+		t.Errorf("AddOnHost() sent unexpected requests %v", unhandled)
+	}
+
+	if len(ipResult.Interfaces) != 1 {
+		t.Fatalf("got %d result interfaces, want 1", len(ipResult.Interfaces))
+	}
+	intf := ipResult.Interfaces[0]
+	if intf.Name != "memif1/0" || intf.Mac != "02:fe:00:00:00:05" || intf.Mtu != 9000 || intf.SocketPath != socketFile {
+		t.Errorf("unexpected result interface %+v", intf)
+	}
+
+	var data vppdb.VppSavedData
+	if err := vppdb.LoadVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.SwIfIndex != 5 || data.BridgeId != 4 || data.SocketFile != socketFile || data.Mtu != 9000 || data.Mac != intf.Mac {
+		t.Errorf("unexpected saved data %+v", data)
+	}
+	// The directory of a runtime socketPath is not the CNI's.
+	if data.SocketDirOwned {
+		t.Errorf("directory of the runtime socketPath owned")
+	}
+}
+
+func TestDelFromHostMemifBridge(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	socketFile := conf.RuntimeConfig.SocketPath
+
+	// As left by AddOnHost() and VPP.
+	data := vppdb.VppSavedData{SwIfIndex: 5, MemifSocketId: 1, IfType: "memif", SocketFile: socketFile, BridgeId: 4}
+	if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(socketFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(socketFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interface is the last one in the bridge and on the socket.
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+	vpp.Reply("bridge_domain_dump", &l2.BridgeDomainDetails{BdID: 4})
+	vpp.Reply("bridge_domain_add_del", &l2.BridgeDomainAddDelReply{})
+	dumps := 0
+	vpp.OnRequest("memif_dump", func(req *vppmock.Request) []api.Message {
+		dumps++
+		if dumps == 1 {
+			return []api.Message{&memif.MemifDetails{SwIfIndex: 5, SocketID: 1}}
+		}
+		return nil
+	})
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+	vpp.Reply("memif_socket_filename_dump", &memif.MemifSocketFilenameDetails{SocketID: 1, SocketFilename: []byte(socketFile)})
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+
+	err := cniVpp.DelFromHost(conf, testContainerID)
+	if err != nil {
+		t.Fatalf("DelFromHost() failed: %v", err)
+	}
+
+	req := &memif.MemifDelete{}
+	if reqs := vpp.Requests("memif_delete"); len(reqs) != 1 {
+		t.Fatalf("got %d memif_delete requests, want 1", len(reqs))
+	} else if err = reqs[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.SwIfIndex != 5 {
+		t.Errorf("deleted SwIfIndex %d, want 5", req.SwIfIndex)
+	}
+	if reqs := vpp.Requests("bridge_domain_add_del"); len(reqs) != 1 {
+		t.Errorf("empty bridge not deleted")
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 1 {
+		t.Errorf("unused socket file not deleted")
+	}
+	if unhandled := vpp.Unhandled(); len(unhandled) != 0 {
+		t.Errorf("DelFromHost() sent unexpected requests %v", unhandled)
+	}
+
+	if saved, _ := vppdb.ListVppConfig(); len(saved) != 0 {
+		t.Errorf("saved data not removed: %+v", saved)
+	}
+	if _, err = os.Stat(socketFile); os.IsNotExist(err) == false {
+		t.Errorf("socket file not removed")
+	}
+}
+
+// Only a socket directory created for the pod is removed with its socket.
+func TestDelFromHostSocketDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		owned bool
+	}{
+		{"created for the pod", true},
+		{"of the runtime", false},
+	}
+
+	for _, test := range tests {
+		vpp, cniVpp, dir := setup(t)
+
+		conf := memifBridgeConf(dir)
+		conf.HostConf.NetType = "interface"
+		socketFile := conf.RuntimeConfig.SocketPath
+
+		data := vppdb.VppSavedData{SwIfIndex: 5, MemifSocketId: 1, IfType: "memif", SocketFile: socketFile, SocketDirOwned: test.owned}
+		if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(socketFile), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(socketFile, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		vpp.Reply("memif_dump")
+		vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+
+		if err := cniVpp.DelFromHost(conf, testContainerID); err != nil {
+			t.Errorf("%s: DelFromHost() failed: %v", test.name, err)
+		}
+		_, err := os.Stat(filepath.Dir(socketFile))
+		if removed := os.IsNotExist(err); removed != test.owned {
+			t.Errorf("%s: directory removed %v, want %v", test.name, removed, test.owned)
+		}
+
+		vpp.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAddOnHostUnknownIfType(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.IfType = "tap"
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, &current.Result{}); err == nil {
+		t.Errorf("AddOnHost() accepted IfType tap")
+	}
+	if names := vpp.RequestNames(); len(names) != 0 {
+		t.Errorf("AddOnHost() sent %v for an unknown IfType", names)
+	}
+}
+
+func TestAddOnHostRetval(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+
+	vpp.Reply("memif_socket_filename_dump")
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+	vpp.Reply("memif_create", &memif.MemifCreateReply{Retval: vppinfra.VppErrInvalidValue, SwIfIndex: ^uint32(0)})
+	vpp.Reply("memif_dump")
+
+	// The socket added for the interface is released again.
+	dumps := 0
+	vpp.OnRequest("memif_socket_filename_dump", func(req *vppmock.Request) []api.Message {
+		dumps++
+		if dumps == 1 {
+			return nil
+		}
+		return []api.Message{&memif.MemifSocketFilenameDetails{SocketID: 1, SocketFilename: []byte(conf.RuntimeConfig.SocketPath)}}
+	})
+
+	err := cniVpp.AddOnHost(conf, testContainerID, &current.Result{})
+	if vppinfra.IsVppError(err, vppinfra.VppErrInvalidValue) == false {
+		t.Fatalf("AddOnHost() = %v, want VPP error %d", err, vppinfra.VppErrInvalidValue)
+	}
+
+	var isAdd []uint8
+	for _, r := range vpp.Requests("memif_socket_filename_add_del") {
+		req := &memif.MemifSocketFilenameAddDel{}
+		if err = r.Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		isAdd = append(isAdd, req.IsAdd)
+	}
+	if reflect.DeepEqual(isAdd, []uint8{1, 0}) == false {
+		t.Errorf("socket add and delete = %v, want [1 0]", isAdd)
+	}
+	if saved, _ := vppdb.ListVppConfig(); len(saved) != 0 {
+		t.Errorf("data saved for a failed ADD: %+v", saved)
+	}
+}
+
+func TestAddOnHostMtuFailed(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.RuntimeConfig.SocketPath = ""
+	conf.SocketDir = filepath.Join(dir, "pods", "{{.ContainerID}}")
+	socketDir := filepath.Join(dir, "pods", testContainerID)
+
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+	vpp.Reply("memif_create", &memif.MemifCreateReply{SwIfIndex: 5})
+	vpp.Reply("sw_interface_set_mtu", &interfaces.SwInterfaceSetMtuReply{Retval: vppinfra.VppErrInvalidValue})
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+
+	// The interface and socket exist until deleted again.
+	deleted := func() bool { return len(vpp.Requests("memif_delete")) != 0 }
+	vpp.OnRequest("memif_dump", func(req *vppmock.Request) []api.Message {
+		if deleted() {
+			return nil
+		}
+		return []api.Message{&memif.MemifDetails{SwIfIndex: 5, SocketID: 1}}
+	})
+	vpp.OnRequest("memif_socket_filename_dump", func(req *vppmock.Request) []api.Message {
+		if len(vpp.Requests("memif_socket_filename_add_del")) == 0 {
+			return nil
+		}
+		return []api.Message{&memif.MemifSocketFilenameDetails{SocketID: 1, SocketFilename: []byte(filepath.Join(socketDir, "memif.sock"))}}
+	})
+
+	err := cniVpp.AddOnHost(conf, testContainerID, &current.Result{})
+	if vppinfra.IsVppError(err, vppinfra.VppErrInvalidValue) == false {
+		t.Fatalf("AddOnHost() = %v, want VPP error %d", err, vppinfra.VppErrInvalidValue)
+	}
+
+	if deleted() == false {
+		t.Errorf("interface not deleted")
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 2 {
+		t.Errorf("socket not released")
+	}
+	if _, err = os.Stat(socketDir); os.IsNotExist(err) == false {
+		t.Errorf("socket directory created for the pod not removed")
+	}
+	if saved, _ := vppdb.ListVppConfig(); len(saved) != 0 {
+		t.Errorf("data saved for a failed ADD: %+v", saved)
+	}
+}
+
+func TestDelFromHostInterfaceGone(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	socketFile := conf.RuntimeConfig.SocketPath
+
+	data := vppdb.VppSavedData{SwIfIndex: 5, MemifSocketId: 1, IfType: "memif", SocketFile: socketFile, BridgeId: 4}
+	if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(socketFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(socketFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interface is gone from VPP.
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{Retval: vppinfra.VppErrInvalidSwIfIndex})
+	vpp.Reply("memif_dump")
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{Retval: vppinfra.VppErrInvalidSwIfIndex})
+
+	// The socket is left over from before the restart.
+	vpp.Reply("memif_socket_filename_dump", &memif.MemifSocketFilenameDetails{SocketID: 1, SocketFilename: []byte(socketFile)})
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+
+	if err := cniVpp.DelFromHost(conf, testContainerID); err != nil {
+		t.Fatalf("DelFromHost() failed: %v", err)
+	}
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 1 {
+		t.Errorf("socket of the missing interface not released")
+	}
+	if _, err := os.Stat(socketFile); os.IsNotExist(err) == false {
+		t.Errorf("socket file not removed")
+	}
+}
+
+func TestAddOnHostMultipleMemifs(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.MemifConf.Interfaces = []usrsptypes.MemifIfConf{{Id: 0, RxQueues: 2, TxQueues: 2}, {Id: 1, Mode: "ip"}}
+	ipResult := &current.Result{}
+
+	vpp.Reply("memif_socket_filename_dump")
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+	var swIfIndex uint32 = 5
+	vpp.OnRequest("memif_create", func(req *vppmock.Request) []api.Message {
+		reply := &memif.MemifCreateReply{SwIfIndex: swIfIndex}
+		swIfIndex++
+		return []api.Message{reply}
+	})
+	vpp.Reply("sw_interface_set_mtu", &interfaces.SwInterfaceSetMtuReply{})
+	vpp.Reply("sw_interface_set_flags", &interfaces.SwInterfaceSetFlagsReply{})
+	vpp.Reply("bridge_domain_dump", &l2.BridgeDomainDetails{BdID: 4})
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+	vpp.Reply("memif_dump",
+		&memif.MemifDetails{SwIfIndex: 5, IfName: []byte("memif1/0"), SocketID: 1},
+		&memif.MemifDetails{SwIfIndex: 6, IfName: []byte("memif1/1"), SocketID: 1})
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, ipResult); err != nil {
+		t.Fatalf("AddOnHost() failed: %v", err)
+	}
+
+	var created []memif.MemifCreate
+	for _, r := range vpp.Requests("memif_create") {
+		req := memif.MemifCreate{}
+		if err := r.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, req)
+	}
+	if len(created) != 2 {
+		t.Fatalf("got %d memif_create requests, want 2", len(created))
+	}
+	if created[0].ID != 0 || created[0].RxQueues != 2 || vppmemif.MemifMode(created[0].Mode) != vppmemif.ModeEthernet {
+		t.Errorf("unexpected first memif %+v", created[0])
+	}
+	if created[1].ID != 1 || created[1].RxQueues != 1 || vppmemif.MemifMode(created[1].Mode) != vppmemif.ModeIP {
+		t.Errorf("unexpected second memif %+v", created[1])
+	}
+	if created[0].SocketID != created[1].SocketID {
+		t.Errorf("memifs created on sockets %d and %d", created[0].SocketID, created[1].SocketID)
+	}
+
+	// Both interfaces are set up and added to the bridge.
+	for _, name := range []string{"sw_interface_set_mtu", "sw_interface_set_flags", "sw_interface_set_l2_bridge"} {
+		if reqs := vpp.Requests(name); len(reqs) != 2 {
+			t.Errorf("got %d %s requests, want 2", len(reqs), name)
+		}
+	}
+
+	if len(ipResult.Interfaces) != 2 || ipResult.Interfaces[0].Name != "memif1/0" || ipResult.Interfaces[1].Name != "memif1/1" {
+		t.Errorf("unexpected result interfaces %+v", ipResult.Interfaces)
+	}
+
+	var data vppdb.VppSavedData
+	if err := vppdb.LoadVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.SwIfIndex != 5 || reflect.DeepEqual(data.SwIfIndexList(), []uint32{5, 6}) == false {
+		t.Errorf("unexpected saved data %+v", data)
+	}
+}
+
+func TestDelFromHostMultipleMemifs(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	socketFile := conf.RuntimeConfig.SocketPath
+
+	data := vppdb.VppSavedData{SwIfIndex: 5, SwIfIndexes: []uint32{5, 6}, MemifSocketId: 1, IfType: "memif", SocketFile: socketFile, BridgeId: 4}
+	if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(socketFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(socketFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// VPP holds both interfaces until they are deleted.
+	live := map[uint32]bool{5: true, 6: true}
+	vpp.OnRequest("memif_dump", func(req *vppmock.Request) []api.Message {
+		var msgs []api.Message
+		for _, swIfIndex := range []uint32{5, 6} {
+			if live[swIfIndex] {
+				msgs = append(msgs, &memif.MemifDetails{SwIfIndex: swIfIndex, SocketID: 1})
+			}
+		}
+		return msgs
+	})
+	vpp.OnRequest("memif_delete", func(req *vppmock.Request) []api.Message {
+		del := &memif.MemifDelete{}
+		if err := req.Decode(del); err != nil {
+			t.Error(err)
+		}
+		delete(live, del.SwIfIndex)
+		return []api.Message{&memif.MemifDeleteReply{}}
+	})
+	vpp.Reply("sw_interface_set_l2_bridge", &l2.SwInterfaceSetL2BridgeReply{})
+	vpp.Reply("bridge_domain_dump", &l2.BridgeDomainDetails{BdID: 4})
+	vpp.Reply("bridge_domain_add_del", &l2.BridgeDomainAddDelReply{})
+	vpp.Reply("memif_socket_filename_dump", &memif.MemifSocketFilenameDetails{SocketID: 1, SocketFilename: []byte(socketFile)})
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+
+	if err := cniVpp.DelFromHost(conf, testContainerID); err != nil {
+		t.Fatalf("DelFromHost() failed: %v", err)
+	}
+
+	if len(live) != 0 {
+		t.Errorf("memifs %v not deleted", live)
+	}
+	if reqs := vpp.Requests("sw_interface_set_l2_bridge"); len(reqs) != 2 {
+		t.Errorf("got %d sw_interface_set_l2_bridge requests, want 2", len(reqs))
+	}
+
+	// The socket is only released with the last interface.
+	if reqs := vpp.Requests("memif_socket_filename_add_del"); len(reqs) != 1 {
+		t.Errorf("got %d memif_socket_filename_add_del requests, want 1", len(reqs))
+	}
+}
+
+// Script the replies to create a memif with NetType interface, for the
+// routing tests.
+func replyMemifInterface(vpp *vppmock.VPP) {
+	vpp.Reply("memif_socket_filename_dump")
+	vpp.Reply("memif_socket_filename_add_del", &memif.MemifSocketFilenameAddDelReply{})
+	vpp.Reply("memif_create", &memif.MemifCreateReply{SwIfIndex: 5})
+	vpp.Reply("sw_interface_set_mtu", &interfaces.SwInterfaceSetMtuReply{})
+	vpp.Reply("sw_interface_set_flags", &interfaces.SwInterfaceSetFlagsReply{})
+	vpp.Reply("sw_interface_add_del_address", &interfaces.SwInterfaceAddDelAddressReply{})
+	vpp.Reply("sw_interface_set_unnumbered", &interfaces.SwInterfaceSetUnnumberedReply{})
+	vpp.Reply("ip_add_del_route", &ip.IPAddDelRouteReply{})
+	vpp.Reply("memif_dump", &memif.MemifDetails{SwIfIndex: 5, SocketID: 1})
+
+	// No gateway loopback yet, the first one created is 7.
+	vpp.Reply("sw_interface_dump")
+	vpp.Reply("create_loopback", &interfaces.CreateLoopbackReply{SwIfIndex: 7})
+	vpp.Reply("sw_interface_tag_add_del", &interfaces.SwInterfaceTagAddDelReply{})
+	vpp.Reply("delete_loopback", &interfaces.DeleteLoopbackReply{})
+}
+
+// Details of a VPP interface, with the fixed length strings VPP sends.
+func interfaceDetails(swIfIndex uint32, name string, tag string) *interfaces.SwInterfaceDetails {
+	details := &interfaces.SwInterfaceDetails{
+		SwIfIndex:     swIfIndex,
+		InterfaceName: make([]byte, 64),
+		Tag:           make([]byte, 64),
+	}
+	copy(details.InterfaceName, name)
+	copy(details.Tag, tag)
+	return details
+}
+
+func decodeUnnumbered(t *testing.T, vpp *vppmock.VPP) *interfaces.SwInterfaceSetUnnumbered {
+	req := &interfaces.SwInterfaceSetUnnumbered{}
+	if reqs := vpp.Requests("sw_interface_set_unnumbered"); len(reqs) != 1 {
+		t.Fatalf("got %d sw_interface_set_unnumbered requests, want 1", len(reqs))
+	} else if err := reqs[0].Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func podResult() *current.Result {
+	return &current.Result{IPs: []*current.IPConfig{{
+		Address: net.IPNet{IP: net.ParseIP("10.1.1.5").To4(), Mask: net.CIDRMask(24, 32)},
+		Gateway: net.ParseIP("10.1.1.1").To4(),
+	}}}
+}
+
+func decodeRoutes(t *testing.T, vpp *vppmock.VPP) []*ip.IPAddDelRoute {
+	var list []*ip.IPAddDelRoute
+
+	for _, r := range vpp.Requests("ip_add_del_route") {
+		req := &ip.IPAddDelRoute{}
+		if err := r.Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, req)
+	}
+	return list
+}
+
+func TestAddOnHostRoutesToPod(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	replyMemifInterface(vpp)
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, podResult()); err != nil {
+		t.Fatalf("AddOnHost() failed: %v", err)
+	}
+
+	// The gateway goes on a new loopback, which the host end borrows it from.
+	tag := &interfaces.SwInterfaceTagAddDel{}
+	if reqs := vpp.Requests("sw_interface_tag_add_del"); len(reqs) != 1 {
+		t.Fatalf("got %d sw_interface_tag_add_del requests, want 1", len(reqs))
+	} else if err := reqs[0].Decode(tag); err != nil {
+		t.Fatal(err)
+	}
+	if tag.SwIfIndex != 7 || string(tag.Tag[:16]) != "cni-gw-10.1.1.1\x00" {
+		t.Errorf("unexpected loopback tag %+v", tag)
+	}
+
+	addrs := vpp.Requests("sw_interface_add_del_address")
+	if len(addrs) != 1 {
+		t.Fatalf("got %d sw_interface_add_del_address requests, want 1", len(addrs))
+	}
+	addr := &interfaces.SwInterfaceAddDelAddress{}
+	if err := addrs[0].Decode(addr); err != nil {
+		t.Fatal(err)
+	}
+	if addr.SwIfIndex != 7 || net.IP(addr.Address[:4]).String() != "10.1.1.1" || addr.AddressLength != 32 {
+		t.Errorf("unexpected loopback address %+v", addr)
+	}
+
+	if req := decodeUnnumbered(t, vpp); req.SwIfIndex != 7 || req.UnnumberedSwIfIndex != 5 {
+		t.Errorf("unexpected unnumbered request %+v", req)
+	}
+
+	routes := decodeRoutes(t, vpp)
+	if len(routes) != 1 {
+		t.Fatalf("got %d ip_add_del_route requests, want 1", len(routes))
+	}
+	if routes[0].IsAdd != 1 || routes[0].NextHopSwIfIndex != 5 || routes[0].DstAddressLength != 32 ||
+		net.IP(routes[0].DstAddress[:4]).String() != "10.1.1.5" {
+		t.Errorf("unexpected route %+v", routes[0])
+	}
+
+	var data vppdb.VppSavedData
+	if err := vppdb.LoadVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(data.Routes, []string{"10.1.1.5/32"}) == false {
+		t.Errorf("saved routes %v, want [10.1.1.5/32]", data.Routes)
+	}
+	if data.GatewayLoopback != "cni-gw-10.1.1.1" {
+		t.Errorf("saved gateway loopback %q, want cni-gw-10.1.1.1", data.GatewayLoopback)
+	}
+}
+
+// A failed route leaves nothing behind: the routes added so far, the
+// interface and the gateway loopback are deleted again.
+func TestAddOnHostRouteFailed(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	replyMemifInterface(vpp)
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+
+	ipResult := podResult()
+	ipResult.IPs = append(ipResult.IPs, &current.IPConfig{
+		Address: net.IPNet{IP: net.ParseIP("10.2.2.5").To4(), Mask: net.CIDRMask(24, 32)},
+		Gateway: net.ParseIP("10.2.2.1").To4(),
+	})
+
+	// The route to the second address fails.
+	vpp.OnRequest("ip_add_del_route", func(req *vppmock.Request) []api.Message {
+		if len(vpp.Requests("ip_add_del_route")) == 2 {
+			return []api.Message{&ip.IPAddDelRouteReply{Retval: vppinfra.VppErrInvalidValue}}
+		}
+		return []api.Message{&ip.IPAddDelRouteReply{}}
+	})
+	vpp.OnRequest("sw_interface_dump", func(req *vppmock.Request) []api.Message {
+		if len(vpp.Requests("create_loopback")) == 0 {
+			return nil
+		}
+		return []api.Message{interfaceDetails(7, "loop0", "cni-gw-10.1.1.1,10.2.2.1")}
+	})
+
+	err := cniVpp.AddOnHost(conf, testContainerID, ipResult)
+	if vppinfra.IsVppError(err, vppinfra.VppErrInvalidValue) == false {
+		t.Fatalf("AddOnHost() = %v, want VPP error %d", err, vppinfra.VppErrInvalidValue)
+	}
+
+	var isAdd []uint8
+	for _, route := range decodeRoutes(t, vpp) {
+		isAdd = append(isAdd, route.IsAdd)
+	}
+	if reflect.DeepEqual(isAdd, []uint8{1, 1, 0}) == false {
+		t.Errorf("route add and delete = %v, want [1 1 0]", isAdd)
+	}
+	if reqs := vpp.Requests("memif_delete"); len(reqs) != 1 {
+		t.Errorf("got %d memif_delete requests, want 1", len(reqs))
+	}
+	if reqs := vpp.Requests("delete_loopback"); len(reqs) != 1 {
+		t.Errorf("got %d delete_loopback requests, want 1", len(reqs))
+	}
+	if saved, _ := vppdb.ListVppConfig(); len(saved) != 0 {
+		t.Errorf("data saved for a failed ADD: %+v", saved)
+	}
+}
+
+// A failed bridge step removes the interfaces already in the bridge.
+func TestAddOnHostBridgeFailed(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.MemifConf.Interfaces = []usrsptypes.MemifIfConf{{Id: 0}, {Id: 1}}
+	replyMemifInterface(vpp)
+	var swIfIndex uint32 = 5
+	vpp.OnRequest("memif_create", func(req *vppmock.Request) []api.Message {
+		reply := &memif.MemifCreateReply{SwIfIndex: swIfIndex}
+		swIfIndex++
+		return []api.Message{reply}
+	})
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+	vpp.Reply("bridge_domain_dump", &l2.BridgeDomainDetails{BdID: 4})
+	vpp.Reply("bridge_domain_add_del", &l2.BridgeDomainAddDelReply{})
+
+	// The second interface can't be added to the bridge.
+	vpp.OnRequest("sw_interface_set_l2_bridge", func(req *vppmock.Request) []api.Message {
+		if len(vpp.Requests("sw_interface_set_l2_bridge")) == 2 {
+			return []api.Message{&l2.SwInterfaceSetL2BridgeReply{Retval: vppinfra.VppErrInvalidValue}}
+		}
+		return []api.Message{&l2.SwInterfaceSetL2BridgeReply{}}
+	})
+
+	err := cniVpp.AddOnHost(conf, testContainerID, &current.Result{})
+	if vppinfra.IsVppError(err, vppinfra.VppErrInvalidValue) == false {
+		t.Fatalf("AddOnHost() = %v, want VPP error %d", err, vppinfra.VppErrInvalidValue)
+	}
+
+	var bridged []string
+	for _, r := range vpp.Requests("sw_interface_set_l2_bridge") {
+		req := &l2.SwInterfaceSetL2Bridge{}
+		if err = r.Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		bridged = append(bridged, fmt.Sprintf("%d:%d", req.RxSwIfIndex, req.Enable))
+	}
+	if reflect.DeepEqual(bridged, []string{"5:1", "6:1", "5:0"}) == false {
+		t.Errorf("bridge add and remove = %v, want [5:1 6:1 5:0]", bridged)
+	}
+	if reqs := vpp.Requests("memif_delete"); len(reqs) != 2 {
+		t.Errorf("got %d memif_delete requests, want 2", len(reqs))
+	}
+}
+
+// A second pod with the same gateway borrows it from the same loopback,
+// which is only deleted with the last interface using it.
+func TestAddOnHostSharedGateway(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	replyMemifInterface(vpp)
+	vpp.Reply("sw_interface_dump", interfaceDetails(1, "loop0", ""), interfaceDetails(7, "loop1", "cni-gw-10.1.1.1"))
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, podResult()); err != nil {
+		t.Fatalf("AddOnHost() failed: %v", err)
+	}
+
+	if reqs := vpp.Requests("create_loopback"); len(reqs) != 0 {
+		t.Errorf("got %d create_loopback requests, want none", len(reqs))
+	}
+	if reqs := vpp.Requests("sw_interface_add_del_address"); len(reqs) != 0 {
+		t.Errorf("got %d sw_interface_add_del_address requests, want none", len(reqs))
+	}
+	if req := decodeUnnumbered(t, vpp); req.SwIfIndex != 7 || req.UnnumberedSwIfIndex != 5 {
+		t.Errorf("unexpected unnumbered request %+v", req)
+	}
+
+	// The other pod still uses the loopback.
+	var data vppdb.VppSavedData
+	if err := vppdb.LoadVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	other := vppdb.VppSavedData{SwIfIndex: 6, ContainerId: "fedcba9876543210", IfName: "net1", GatewayLoopback: data.GatewayLoopback}
+	if err := vppdb.SaveVppConfig(conf, other.ContainerId, &other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := releaseGatewayLoopback(*cniVpp.VppCh, &data); err != nil {
+		t.Fatalf("releaseGatewayLoopback() failed: %v", err)
+	}
+	if reqs := vpp.Requests("delete_loopback"); len(reqs) != 0 {
+		t.Fatalf("loopback deleted while in use")
+	}
+
+	// Its saved data is still in place when released, as by GC.
+	if err := releaseGatewayLoopback(*cniVpp.VppCh, &other); err != nil {
+		t.Fatalf("releaseGatewayLoopback() failed: %v", err)
+	}
+	del := &interfaces.DeleteLoopback{}
+	if reqs := vpp.Requests("delete_loopback"); len(reqs) != 1 {
+		t.Fatalf("got %d delete_loopback requests, want 1", len(reqs))
+	} else if err := reqs[0].Decode(del); err != nil {
+		t.Fatal(err)
+	}
+	if del.SwIfIndex != 7 {
+		t.Errorf("deleted loopback %d, want 7", del.SwIfIndex)
+	}
+}
+
+func TestAddOnHostUnnumbered(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	conf.HostConf.L3Conf.Unnumbered = "loop0"
+	replyMemifInterface(vpp)
+
+	// The name filter of VPP matches on a substring.
+	vpp.Reply("sw_interface_dump", interfaceDetails(3, "loop01", ""), interfaceDetails(1, "loop0", ""))
+
+	// No gateway is needed when borrowing an address.
+	result := podResult()
+	result.IPs[0].Gateway = nil
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, result); err != nil {
+		t.Fatalf("AddOnHost() failed: %v", err)
+	}
+
+	dump := &interfaces.SwInterfaceDump{}
+	if reqs := vpp.Requests("sw_interface_dump"); len(reqs) != 1 {
+		t.Fatalf("got %d sw_interface_dump requests, want 1", len(reqs))
+	} else if err := reqs[0].Decode(dump); err != nil {
+		t.Fatal(err)
+	}
+	if dump.NameFilterValid != 1 || string(dump.NameFilter[:6]) != "loop0\x00" {
+		t.Errorf("unexpected dump request %+v", dump)
+	}
+
+	if req := decodeUnnumbered(t, vpp); req.SwIfIndex != 1 || req.UnnumberedSwIfIndex != 5 {
+		t.Errorf("unexpected unnumbered request %+v", req)
+	}
+	if reqs := vpp.Requests("create_loopback"); len(reqs) != 0 {
+		t.Errorf("loopback created for an unnumbered interface")
+	}
+	if reqs := vpp.Requests("sw_interface_add_del_address"); len(reqs) != 0 {
+		t.Errorf("address added to an unnumbered interface")
+	}
+	if routes := decodeRoutes(t, vpp); len(routes) != 1 {
+		t.Errorf("got %d routes, want 1", len(routes))
+	}
+}
+
+func TestAddOnHostUnnumberedNotFound(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	conf.HostConf.L3Conf.Unnumbered = "loop0"
+	replyMemifInterface(vpp)
+	vpp.Reply("sw_interface_dump", interfaceDetails(3, "loop01", ""))
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, podResult()); err == nil {
+		t.Fatalf("AddOnHost() succeeded without the unnumbered interface")
+	}
+	if reqs := vpp.Requests("sw_interface_set_unnumbered"); len(reqs) != 0 {
+		t.Errorf("got %d sw_interface_set_unnumbered requests, want none", len(reqs))
+	}
+}
+
+func TestAddOnHostNoGateway(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	replyMemifInterface(vpp)
+
+	result := podResult()
+	result.IPs[0].Gateway = nil
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, result); err == nil {
+		t.Errorf("AddOnHost() succeeded without a gateway")
+	}
+	if names := vpp.RequestNames(); len(names) != 0 {
+		t.Errorf("AddOnHost() sent %v without a gateway", names)
+	}
+}
+
+func TestAddOnContainerDefaultRoute(t *testing.T) {
+	vpp, _, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	cniVpp := CniVpp{VppCh: &vppinfra.ConnectionData{Ch: vpp.Ch}, inContainer: true}
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	replyMemifInterface(vpp)
+
+	if err := cniVpp.AddOnHost(conf, testContainerID, podResult()); err != nil {
+		t.Fatalf("AddOnHost() failed: %v", err)
+	}
+
+	// The container end takes the pod address and routes through the gateway.
+	addr := &interfaces.SwInterfaceAddDelAddress{}
+	if err := vpp.Requests("sw_interface_add_del_address")[0].Decode(addr); err != nil {
+		t.Fatal(err)
+	}
+	if net.IP(addr.Address[:4]).String() != "10.1.1.5" || addr.AddressLength != 24 {
+		t.Errorf("unexpected container address %+v", addr)
+	}
+
+	routes := decodeRoutes(t, vpp)
+	if len(routes) != 1 {
+		t.Fatalf("got %d ip_add_del_route requests, want 1", len(routes))
+	}
+	if routes[0].DstAddressLength != 0 || net.IP(routes[0].NextHopAddress[:4]).String() != "10.1.1.1" {
+		t.Errorf("unexpected default route %+v", routes[0])
+	}
+}
+
+func TestDelFromHostRoutesToPod(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	socketFile := conf.RuntimeConfig.SocketPath
+
+	data := vppdb.VppSavedData{SwIfIndex: 5, MemifSocketId: 1, IfType: "memif", SocketFile: socketFile, Routes: []string{"10.1.1.5/32"}}
+	if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(socketFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(socketFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vpp.Reply("ip_add_del_route", &ip.IPAddDelRouteReply{})
+	vpp.Reply("memif_dump")
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+	vpp.Reply("memif_socket_filename_dump")
+
+	if err := cniVpp.DelFromHost(conf, testContainerID); err != nil {
+		t.Fatalf("DelFromHost() failed: %v", err)
+	}
+
+	routes := decodeRoutes(t, vpp)
+	if len(routes) != 1 {
+		t.Fatalf("got %d ip_add_del_route requests, want 1", len(routes))
+	}
+	if routes[0].IsAdd != 0 || routes[0].NextHopSwIfIndex != 5 || net.IP(routes[0].DstAddress[:4]).String() != "10.1.1.5" {
+		t.Errorf("unexpected route delete %+v", routes[0])
+	}
+}
+
+func TestContainerDelete(t *testing.T) {
+	vpp, _, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	// In the container, the saved data and the marker share the directory
+	// mapped from the host.
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	socketFile := conf.RuntimeConfig.SocketPath
+
+	data := vppdb.VppSavedData{SwIfIndex: 5, MemifSocketId: 1, IfType: "memif", SocketFile: socketFile, Gateways: []string{"10.1.1.1"}}
+	if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(socketFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(socketFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(dir, "data", "delete-net1.json")
+	dataBytes, _ := json.Marshal(usrsptypes.RemoteConfig{NetConf: *conf, ContainerId: testContainerID})
+	if err := ioutil.WriteFile(marker, dataBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vpp.Reply("ip_add_del_route", &ip.IPAddDelRouteReply{})
+	vpp.Reply("memif_dump", &memif.MemifDetails{SwIfIndex: 5, SocketID: 1})
+	vpp.Reply("memif_delete", &memif.MemifDeleteReply{})
+	vpp.Reply("memif_socket_filename_dump")
+
+	deleted, err := CniContainerDelete(&vppinfra.ConnectionData{Ch: vpp.Ch})
+	if err != nil || deleted == false {
+		t.Fatalf("CniContainerDelete() = %v, %v, want deleted", deleted, err)
+	}
+
+	if reqs := vpp.Requests("memif_delete"); len(reqs) != 1 {
+		t.Errorf("got %d memif_delete requests, want 1", len(reqs))
+	}
+	routes := decodeRoutes(t, vpp)
+	if len(routes) != 1 || routes[0].IsAdd != 0 || routes[0].DstAddressLength != 0 {
+		t.Errorf("default route not deleted: %+v", routes)
+	}
+	if _, err = os.Stat(marker); os.IsNotExist(err) == false {
+		t.Errorf("marker not removed")
+	}
+
+	// The socket file belongs to the host.
+	if _, err = os.Stat(socketFile); err != nil {
+		t.Errorf("socket file removed in the container: %v", err)
+	}
+
+	// Nothing left to do on the next poll.
+	if deleted, err = CniContainerDelete(&vppinfra.ConnectionData{Ch: vpp.Ch}); err != nil || deleted {
+		t.Errorf("CniContainerDelete() = %v, %v, want nothing deleted", deleted, err)
+	}
+}
+
+func TestDelFromContainerNotPickedUp(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	if err := vppdb.SaveRemoteConfig(conf, podResult(), testContainerID); err != nil {
+		t.Fatal(err)
+	}
+
+	// vpp-app never read the remote config, so there is nothing to wait for.
+	start := time.Now()
+	if err := cniVpp.DelFromContainer(conf, testContainerID); err != nil {
+		t.Fatalf("DelFromContainer() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= remoteDeleteTimeout {
+		t.Errorf("DelFromContainer() waited %v", elapsed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, testContainerID)); os.IsNotExist(err) == false {
+		t.Errorf("remote config directory not removed")
+	}
+}
+
+func TestDelFromContainerReportedBack(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	remoteDir := filepath.Join(dir, testContainerID)
+	if err := os.MkdirAll(remoteDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand in for vpp-app, removing the marker once it shows up.
+	marker := filepath.Join(remoteDir, "delete-net1.json")
+	go func() {
+		for i := 0; i < 100; i++ {
+			if os.Remove(marker) == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	if err := cniVpp.DelFromContainer(conf, testContainerID); err != nil {
+		t.Fatalf("DelFromContainer() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= remoteDeleteTimeout {
+		t.Errorf("DelFromContainer() did not see the marker removed, waited %v", elapsed)
+	}
+	if _, err := os.Stat(remoteDir); os.IsNotExist(err) == false {
+		t.Errorf("remote config directory not removed")
+	}
+}
+
+// Write the remote config as vpp-app sees it, in the directory mapped from
+// the host.
+func writeRemoteConfig(t *testing.T, dir string, conf *usrsptypes.NetConf) {
+	dataDir := filepath.Join(dir, "data")
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	dataBytes, _ := json.Marshal(conf)
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "remote-net1.json"), dataBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dataBytes, _ = json.Marshal(usrsptypes.RemoteConfig{ContainerId: testContainerID, IPResult: *podResult()})
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "addData-net1.json"), dataBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func containerStatus(t *testing.T) usrsptypes.RemoteStatus {
+	list, err := vppdb.ListRemoteStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("got %d status, want 1", len(list))
+	}
+	return list[0]
+}
+
+func TestContainerConfigStatus(t *testing.T) {
+	vpp, _, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	vppCh := &vppinfra.ConnectionData{Ch: vpp.Ch}
+	conf := memifBridgeConf(dir)
+	conf.HostConf.NetType = "interface"
+	writeRemoteConfig(t, dir, conf)
+	replyMemifInterface(vpp)
+
+	if found, err := CniContainerConfig(vppCh); err != nil || found == false {
+		t.Fatalf("CniContainerConfig() = %v, %v, want found", found, err)
+	}
+
+	// The host end is not connected yet.
+	want := usrsptypes.RemoteStatus{ContainerId: testContainerID, IfName: "net1", State: usrsptypes.RemoteStatusApplied}
+	if status := containerStatus(t); status != want {
+		t.Errorf("got status %+v, want %+v", status, want)
+	}
+
+	vpp.Reply("memif_dump", &memif.MemifDetails{SwIfIndex: 5, SocketID: 1, LinkUpDown: 1})
+	if changed, err := CniContainerStatus(vppCh); err != nil || changed == false {
+		t.Fatalf("CniContainerStatus() = %v, %v, want changed", changed, err)
+	}
+	want.LinkUp = true
+	if status := containerStatus(t); status != want {
+		t.Errorf("got status %+v, want %+v", status, want)
+	}
+
+	if changed, err := CniContainerStatus(vppCh); err != nil || changed {
+		t.Errorf("CniContainerStatus() = %v, %v, want nothing changed", changed, err)
+	}
+}
+
+func TestContainerConfigStatusFailed(t *testing.T) {
+	vpp, _, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	conf.HostConf.IfType = "tap"
+	writeRemoteConfig(t, dir, conf)
+
+	found, err := CniContainerConfig(&vppinfra.ConnectionData{Ch: vpp.Ch})
+	if err == nil || found == false {
+		t.Fatalf("CniContainerConfig() = %v, %v, want found and an error", found, err)
+	}
+
+	status := containerStatus(t)
+	if status.State != usrsptypes.RemoteStatusFailed || status.Error != err.Error() {
+		t.Errorf("got status %+v, want failed with %q", status, err)
+	}
+}
+
+func TestWaitOnContainer(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	conf := memifBridgeConf(dir)
+	remoteDir := filepath.Join(dir, testContainerID)
+	if err := os.MkdirAll(remoteDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// Replaced in one step, as vpp-app does, since it is read concurrently.
+	writeStatus := func(status usrsptypes.RemoteStatus) {
+		path := filepath.Join(remoteDir, "status-net1.json")
+		dataBytes, _ := json.Marshal(status)
+		ioutil.WriteFile(path+".tmp", dataBytes, 0644)
+		os.Rename(path+".tmp", path)
+	}
+	timeout := 3 * containerStatusPoll
+
+	// Nothing reported.
+	if err := cniVpp.WaitOnContainer(conf, testContainerID, timeout); err == nil {
+		t.Errorf("WaitOnContainer() succeeded without a status")
+	}
+
+	// Applied, but the link stays down.
+	writeStatus(usrsptypes.RemoteStatus{ContainerId: testContainerID, IfName: "net1", State: usrsptypes.RemoteStatusApplied})
+	if err := cniVpp.WaitOnContainer(conf, testContainerID, timeout); err == nil {
+		t.Errorf("WaitOnContainer() succeeded with the link down")
+	}
+
+	// Failed, without waiting for the timeout.
+	writeStatus(usrsptypes.RemoteStatus{ContainerId: testContainerID, IfName: "net1", State: usrsptypes.RemoteStatusFailed, Error: "no VPP"})
+	start := time.Now()
+	if err := cniVpp.WaitOnContainer(conf, testContainerID, time.Minute); err == nil {
+		t.Errorf("WaitOnContainer() succeeded on a failed status")
+	}
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Errorf("WaitOnContainer() waited %v on a failed status", elapsed)
+	}
+
+	// The link comes up while waiting.
+	writeStatus(usrsptypes.RemoteStatus{ContainerId: testContainerID, IfName: "net1", State: usrsptypes.RemoteStatusApplied})
+	go func() {
+		time.Sleep(containerStatusPoll)
+		writeStatus(usrsptypes.RemoteStatus{ContainerId: testContainerID, IfName: "net1", State: usrsptypes.RemoteStatusApplied, LinkUp: true})
+	}()
+	if err := cniVpp.WaitOnContainer(conf, testContainerID, time.Minute); err != nil {
+		t.Errorf("WaitOnContainer() failed: %v", err)
+	}
+}
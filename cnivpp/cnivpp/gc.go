@@ -0,0 +1,185 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module removes what the VPP UserSpace CNI implementation created for
+// containers that no longer exist, for example when the runtime never
+// called DEL after a node crash. It is used by CNI GC and userspace-ctl.
+//
+
+package cnivpp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/vppdb"
+)
+
+//
+// API Functions
+//
+
+// GarbageCollect() - Remove the VPP interfaces (and bridge membership),
+//  socket files, saved data and remote configs of every container not in
+//  liveIDs, and the per pod socket directories left empty. If netName is
+//  not empty, only interfaces created for that network are removed, and
+//  per container files are only removed once the container has no
+//  interface left in any network. Returns a description of each removed
+//  object.
+func (cniVpp CniVpp) GarbageCollect(netName string, liveIDs []string) ([]string, error) {
+	var removed []string
+
+	list, err := cniVpp.Inventory()
+	if err != nil {
+		return nil, err
+	}
+
+	// Containers, and socket directories, that still own an interface
+	// after this pass.
+	var inUse []string
+	inUseDirs := make(map[string]bool)
+
+	var savedSocketFiles []string
+	for _, entry := range list {
+		if entry.Saved != nil {
+			savedSocketFiles = append(savedSocketFiles, entry.Saved.SocketFile)
+		}
+	}
+	dirs := socketDirs(savedSocketFiles)
+
+	for _, entry := range list {
+		containerId := entry.ContainerId()
+
+		if containerId == "" || isLive(containerId, liveIDs) ||
+			(netName != "" && (entry.Saved == nil || entry.Saved.NetName != netName)) {
+			if containerId != "" {
+				inUse = append(inUse, containerId)
+			}
+			if entry.SocketFile != "" {
+				inUseDirs[filepath.Dir(entry.SocketFile)] = true
+			}
+			continue
+		}
+
+		if err = cniVpp.RemoveInventoryEntry(entry); err != nil {
+			return removed, err
+		}
+		removed = append(removed, fmt.Sprintf("vpp interface %d (container %s, socket %s)",
+			entry.SwIfIndex(), containerId, entry.SocketFile))
+	}
+
+	// Remote configs, /var/run/vpp/cni/<ContainerId>
+	remoteList, err := vppdb.ListRemoteConfig()
+	if err != nil {
+		return removed, err
+	}
+	for _, containerId := range remoteList {
+		if isLive(containerId, liveIDs) || isLive(containerId, inUse) {
+			continue
+		}
+		vppdb.CleanupRemoteConfig(nil, containerId)
+		removed = append(removed, "remote config "+containerId)
+	}
+
+	// Socket files no longer attached to a VPP interface, named
+	// memif-<ContainerId:12>-<If0name>.sock, in the socket directories of
+	// the CNI, /var/run/vpp/cni/shared by default.
+	var patterns []string
+	for dir := range dirs {
+		patterns = append(patterns, filepath.Join(dir, "memif-*.sock"))
+	}
+	for _, pattern := range cniVpp.SocketDirGlobs {
+		patterns = append(patterns, filepath.Join(pattern, "memif-*.sock"))
+	}
+	matches, err := globAll(patterns)
+	if err != nil {
+		return removed, err
+	}
+	for _, socketFile := range matches {
+		containerId := containerIdFromSocket(socketFile)
+		if containerId == "" || isLive(containerId, liveIDs) || isLive(containerId, inUse) {
+			continue
+		}
+		if err = os.Remove(socketFile); err != nil && os.IsNotExist(err) == false {
+			return removed, err
+		}
+		removed = append(removed, "socket "+socketFile)
+	}
+
+	// Per pod socket directories, once empty.
+	podDirs, err := globAll(cniVpp.SocketDirGlobs)
+	if err != nil {
+		return removed, err
+	}
+	for _, dir := range podDirs {
+		if inUseDirs[dir] || isEmptyDir(dir) == false {
+			continue
+		}
+		if err = os.Remove(dir); err != nil && os.IsNotExist(err) == false {
+			return removed, err
+		}
+		removed = append(removed, "socket directory "+dir)
+	}
+
+	return removed, nil
+}
+
+//
+// Local Functions
+//
+
+// Return the files matching any of the patterns, each only once.
+func globAll(patterns []string) ([]string, error) {
+	var files []string
+
+	found := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range matches {
+			if found[file] == false {
+				found[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func isEmptyDir(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	return len(files) == 0
+}
+
+// The CNI only records the first 12 characters of the ContainerId in some
+// places, so compare on the shorter of the two.
+func isLive(containerId string, liveIDs []string) bool {
+	for _, liveId := range liveIDs {
+		if liveId != "" && (strings.HasPrefix(liveId, containerId) || strings.HasPrefix(containerId, liveId)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnivpp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"git.fd.io/govpp.git/core/bin_api/memif"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/vppdb"
+)
+
+const deadContainerID = "fedcba9876543210fedcba9876543210"
+
+// Create a directory for socket files, apart from the saved data, which GC
+// would take for remote configs.
+func socketTempDir(t *testing.T) string {
+	sockDir, err := ioutil.TempDir("", "cnivpp-sockets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sockDir
+}
+
+func TestInventorySocketDirs(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	sockDir := socketTempDir(t)
+	defer os.RemoveAll(sockDir)
+
+	cniVpp.SocketDirGlobs = []string{filepath.Join(sockDir, "pods", "*", "*")}
+
+	// A runtime socketPath, recorded in the saved data.
+	conf := memifBridgeConf(dir)
+	runtimeSocket := filepath.Join(sockDir, "runtime", "memif-net1.sock")
+	data := vppdb.VppSavedData{SwIfIndex: 5, IfType: "memif", SocketFile: runtimeSocket}
+	if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	vpp.Reply("memif_dump",
+		&memif.MemifDetails{SwIfIndex: 5, SocketID: 1},
+		&memif.MemifDetails{SwIfIndex: 6, SocketID: 2},
+		&memif.MemifDetails{SwIfIndex: 7, SocketID: 3},
+		&memif.MemifDetails{SwIfIndex: 8, SocketID: 4})
+	vpp.Reply("memif_socket_filename_dump",
+		&memif.MemifSocketFilenameDetails{SocketID: 1, SocketFilename: []byte(runtimeSocket)},
+		// Lost its saved data, in the directory of the runtime socketPath.
+		&memif.MemifSocketFilenameDetails{SocketID: 2, SocketFilename: []byte(filepath.Join(sockDir, "runtime", "memif-net2.sock"))},
+		// Lost its saved data, in a per pod directory.
+		&memif.MemifSocketFilenameDetails{SocketID: 3, SocketFilename: []byte(filepath.Join(sockDir, "pods", "default", "pod", "memif-fedcba987654-net1.sock"))},
+		// Not created by the CNI.
+		&memif.MemifSocketFilenameDetails{SocketID: 4, SocketFilename: []byte(filepath.Join(sockDir, "other", "memif-fedcba987654-net1.sock"))})
+	vpp.Reply("sw_interface_vhost_user_dump")
+
+	list, err := cniVpp.Inventory()
+	if err != nil {
+		t.Fatalf("Inventory() failed: %v", err)
+	}
+
+	var swIfIndexes []int
+	for _, entry := range list {
+		swIfIndexes = append(swIfIndexes, int(entry.SwIfIndex()))
+	}
+	sort.Ints(swIfIndexes)
+	if len(swIfIndexes) != 3 || swIfIndexes[0] != 5 || swIfIndexes[1] != 6 || swIfIndexes[2] != 7 {
+		t.Errorf("got interfaces %v, want [5 6 7]", swIfIndexes)
+	}
+}
+
+func TestGarbageCollectSocketDirs(t *testing.T) {
+	vpp, cniVpp, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer vpp.Close()
+
+	sockDir := socketTempDir(t)
+	defer os.RemoveAll(sockDir)
+
+	podsDir := filepath.Join(sockDir, "pods")
+	cniVpp.SocketDirGlobs = []string{filepath.Join(podsDir, "*")}
+
+	// The socket of the live container is created by the container side,
+	// so its directory is still empty.
+	conf := memifBridgeConf(dir)
+	data := vppdb.VppSavedData{SwIfIndex: 5, IfType: "memif", SocketFile: filepath.Join(podsDir, "live", "memif-0123456789ab-net1.sock")}
+	if err := vppdb.SaveVppConfig(conf, testContainerID, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	deadSocket := filepath.Join(podsDir, "dead", "memif-fedcba987654-net1.sock")
+	otherFile := filepath.Join(podsDir, "other", "file")
+	for _, path := range []string{deadSocket, otherFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"live", "leaked"} {
+		if err := os.MkdirAll(filepath.Join(podsDir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vpp.Reply("memif_dump")
+	vpp.Reply("memif_socket_filename_dump")
+	vpp.Reply("sw_interface_vhost_user_dump")
+
+	if _, err := cniVpp.GarbageCollect("", []string{testContainerID}); err != nil {
+		t.Fatalf("GarbageCollect() failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		exists bool
+	}{
+		{"live", true},
+		{"dead", false},
+		{"leaked", false},
+		{"other", true},
+	}
+
+	for _, test := range tests {
+		_, err := os.Stat(filepath.Join(podsDir, test.name))
+		if exists := err == nil; exists != test.exists {
+			t.Errorf("%s: directory exists %v, want %v", test.name, exists, test.exists)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module compares the data saved by the VPP UserSpace CNI implementation
+// with the objects that actually exist in the local VPP instance. It is used
+// to inspect and repair node state (see userspace-ctl).
+//
+
+package cnivpp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/bridge"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/memif"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/vhostuser"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/vppdb"
+)
+
+//
+// Types
+//
+
+// An interface created by the UserSpace CNI on the local VPP instance, as
+// recorded in the saved data and as reported by VPP. Either side may be
+// missing, in which case the entry is orphaned.
+type InventoryEntry struct {
+	Saved      *vppdb.VppSavedData              // nil if the VPP interface has no saved data.
+	Memif      *vppmemif.MemifInterface         // nil if not a memif interface or missing from VPP.
+	Vhost      *vppvhostuser.VhostUserInterface // nil if not a vhost-user interface or missing from VPP.
+	SocketFile string                           // Socket file of the interface, "" if unknown.
+
+	// The other memif interfaces created on the same socket, when the
+	// saved data lists more than one.
+	ExtraMemifs []vppmemif.MemifInterface
+}
+
+// Returns the ContainerId (possibly only the first 12 characters) owning
+// the entry, "" if unknown.
+func (entry InventoryEntry) ContainerId() string {
+	if entry.Saved != nil {
+		return entry.Saved.ContainerId
+	}
+	return containerIdFromSocket(entry.SocketFile)
+}
+
+// Returns the swIfIndex of the interface in VPP, or from the saved data if
+// the interface is missing from VPP.
+func (entry InventoryEntry) SwIfIndex() uint32 {
+	if entry.Memif != nil {
+		return entry.Memif.SwIfIndex
+	}
+	if entry.Vhost != nil {
+		return entry.Vhost.SwIfIndex
+	}
+	if entry.Saved != nil {
+		return entry.Saved.SwIfIndex
+	}
+	return 0
+}
+
+// Returns true if the VPP interface exists.
+func (entry InventoryEntry) InVpp() bool {
+	return entry.Memif != nil || entry.Vhost != nil
+}
+
+// Returns true if only one of the saved data and the VPP interface exist.
+func (entry InventoryEntry) Orphaned() bool {
+	return entry.Saved == nil || entry.InVpp() == false
+}
+
+//
+// API Functions
+//
+
+// Inventory() - Return the interfaces owned by the UserSpace CNI, pairing
+//  each saved entry with its VPP interface. VPP interfaces are only
+//  considered owned by the CNI if their socket file is referenced by saved
+//  data, or is in a socket directory of the CNI: the default one, one of
+//  the saved data or a per pod one matching cniVpp.SocketDirGlobs.
+func (cniVpp CniVpp) Inventory() ([]InventoryEntry, error) {
+	var list []InventoryEntry
+
+	savedList, err := vppdb.ListVppConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	vppCh, closeCh, err := cniVpp.openCh()
+	if err != nil {
+		return nil, err
+	}
+	defer closeCh()
+
+	memifList, err := vppmemif.ListMemif(vppCh.Ch)
+	if err != nil {
+		return nil, err
+	}
+	socketList, err := vppmemif.ListMemifSocket(vppCh.Ch)
+	if err != nil {
+		return nil, err
+	}
+	vhostList, err := vppvhostuser.ListVhostUser(vppCh.Ch)
+	if err != nil {
+		return nil, err
+	}
+
+	socketFiles := make(map[uint32]string)
+	for _, socket := range socketList {
+		socketFiles[socket.SocketId] = socket.Filename
+	}
+
+	var savedSocketFiles []string
+	for _, saved := range savedList {
+		savedSocketFiles = append(savedSocketFiles, saved.SocketFile)
+	}
+	dirs := socketDirs(savedSocketFiles)
+
+	memifUsed := make([]bool, len(memifList))
+	vhostUsed := make([]bool, len(vhostList))
+
+	// Pair each saved entry with its VPP interface. The swIfIndex may have
+	// been reused by VPP, so the socket file must also match when known.
+	for i := range savedList {
+		entry := InventoryEntry{
+			Saved:      &savedList[i],
+			SocketFile: savedList[i].SocketFile,
+		}
+
+		if entry.Saved.IfType != "vhostuser" {
+			for j := range memifList {
+				if memifUsed[j] == false &&
+					memifList[j].SwIfIndex == entry.Saved.SwIfIndex &&
+					matchSocket(entry.Saved.SocketFile, socketFiles[memifList[j].SocketId]) {
+					memifUsed[j] = true
+					entry.Memif = &memifList[j]
+					entry.SocketFile = socketFiles[memifList[j].SocketId]
+					break
+				}
+			}
+		}
+		if entry.Memif != nil {
+			for _, swIfIndex := range entry.Saved.SwIfIndexList()[1:] {
+				for j := range memifList {
+					if memifUsed[j] == false &&
+						memifList[j].SwIfIndex == swIfIndex &&
+						memifList[j].SocketId == entry.Memif.SocketId {
+						memifUsed[j] = true
+						entry.ExtraMemifs = append(entry.ExtraMemifs, memifList[j])
+						break
+					}
+				}
+			}
+		}
+		if entry.Saved.IfType != "memif" && entry.Memif == nil {
+			for j := range vhostList {
+				if vhostUsed[j] == false &&
+					vhostList[j].SwIfIndex == entry.Saved.SwIfIndex &&
+					matchSocket(entry.Saved.SocketFile, vhostList[j].SockFilename) {
+					vhostUsed[j] = true
+					entry.Vhost = &vhostList[j]
+					entry.SocketFile = vhostList[j].SockFilename
+					break
+				}
+			}
+		}
+
+		list = append(list, entry)
+	}
+
+	// Any remaining interface using a socket in a socket directory of the
+	// CNI was created by the CNI but has lost its saved data.
+	for j := range memifList {
+		socketFile := socketFiles[memifList[j].SocketId]
+		if memifUsed[j] == false && cniVpp.inSocketDir(socketFile, dirs) {
+			list = append(list, InventoryEntry{
+				Memif:      &memifList[j],
+				SocketFile: socketFile,
+			})
+		}
+	}
+	for j := range vhostList {
+		if vhostUsed[j] == false && cniVpp.inSocketDir(vhostList[j].SockFilename, dirs) {
+			list = append(list, InventoryEntry{
+				Vhost:      &vhostList[j],
+				SocketFile: vhostList[j].SockFilename,
+			})
+		}
+	}
+
+	return list, nil
+}
+
+// RemoveInventoryEntry() - Delete whatever exists of the given entry: the
+//  VPP interface and its bridge membership, the socket file and the saved
+//  data.
+func (cniVpp CniVpp) RemoveInventoryEntry(entry InventoryEntry) error {
+
+	if entry.InVpp() {
+		vppCh, closeCh, err := cniVpp.openCh()
+		if err != nil {
+			return err
+		}
+		defer closeCh()
+
+		swIfIndexes := []uint32{entry.SwIfIndex()}
+		for _, memifIntf := range entry.ExtraMemifs {
+			swIfIndexes = append(swIfIndexes, memifIntf.SwIfIndex)
+		}
+
+		// Remove the interfaces from any Bridge. RemoveBridgeInterface()
+		// deletes the Bridge if it is no longer used.
+		bridgeList, err := vppbridge.ListBridge(vppCh.Ch)
+		if err != nil {
+			return err
+		}
+		for _, bridge := range bridgeList {
+			for _, member := range bridge.Members {
+				for _, swIfIndex := range swIfIndexes {
+					if member.SwIfIndex == swIfIndex {
+						err = vppbridge.RemoveBridgeInterface(vppCh.Ch, bridge.BdID, swIfIndex)
+						if err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+
+		// Remove the routes to the pod, if any were saved.
+		if entry.Saved != nil {
+			delRouting(vppCh, entry.Saved)
+		}
+
+		for _, swIfIndex := range swIfIndexes {
+			if entry.Memif != nil {
+				err = vppmemif.DeleteMemifInterface(vppCh.Ch, swIfIndex)
+			} else {
+				err = vppvhostuser.DeleteVhostUserInterface(vppCh.Ch, swIfIndex)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if entry.Saved != nil {
+			if err = releaseGatewayLoopback(vppCh, entry.Saved); err != nil {
+				return err
+			}
+		}
+	}
+
+	if entry.SocketFile != "" {
+		if err := os.Remove(entry.SocketFile); err != nil && os.IsNotExist(err) == false {
+			return err
+		}
+
+		// Remove the directory created for the pod if it is now empty.
+		if entry.Saved != nil && entry.Saved.SocketDirOwned {
+			os.Remove(filepath.Dir(entry.SocketFile))
+		}
+	}
+
+	if entry.Saved != nil {
+		if err := vppdb.DeleteVppConfig(entry.Saved.ContainerId, entry.Saved.IfName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//
+// Local Functions
+//
+
+// Socket files are only compared if the saved data recorded one.
+func matchSocket(savedSocketFile string, vppSocketFile string) bool {
+	return savedSocketFile == "" || savedSocketFile == vppSocketFile
+}
+
+// Return the socket directories of the CNI known without a template: the
+// default one and those of the given socket files.
+func socketDirs(socketFiles []string) map[string]bool {
+	dirs := map[string]bool{filepath.Clean(defaultVPPSocketDir): true}
+	for _, socketFile := range socketFiles {
+		if socketFile != "" {
+			dirs[filepath.Dir(socketFile)] = true
+		}
+	}
+	return dirs
+}
+
+// Socket files in dirs or in a per pod directory are owned by the CNI.
+func (cniVpp CniVpp) inSocketDir(socketFile string, dirs map[string]bool) bool {
+	if socketFile == "" {
+		return false
+	}
+
+	dir := filepath.Dir(socketFile)
+	if dirs[dir] {
+		return true
+	}
+	for _, pattern := range cniVpp.SocketDirGlobs {
+		if ok, _ := filepath.Match(pattern, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Socket files created by the CNI are named memif-<ContainerId:12>-<If0name>.sock
+func containerIdFromSocket(socketFile string) string {
+	name := strings.TrimPrefix(filepath.Base(socketFile), "memif-")
+	if len(name) < 13 || name[12] != '-' {
+		return ""
+	}
+	return name[:12]
+}
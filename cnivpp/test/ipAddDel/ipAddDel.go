@@ -98,19 +98,19 @@ func main() {
 	} else {
 		fmt.Println("MEMIF SOCKET", memifSocketId, memifSocketFile, "created")
 		if dbgMemif {
-			vppmemif.DumpMemifSocket(vppCh.Ch)
+			vppmemif.DumpMemifSocket(os.Stdout, vppCh.Ch)
 		}
 	}
 
 	// Create MemIf Interface
-	swIfIndex, err = vppmemif.CreateMemifInterface(vppCh.Ch, memifSocketId, memifRole, memifMode)
+	swIfIndex, err = vppmemif.CreateMemifInterface(vppCh.Ch, memifSocketId, memifRole, memifMode, nil)
 	if err != nil {
 		fmt.Println("Error:", err)
 		os.Exit(1)
 	} else {
 		fmt.Println("MEMIF", swIfIndex, "created")
 		if dbgMemif {
-			vppmemif.DumpMemif(vppCh.Ch)
+			vppmemif.DumpMemif(os.Stdout, vppCh.Ch)
 		}
 	}
 
@@ -156,8 +156,8 @@ func main() {
 	} else {
 		fmt.Printf("INTERFACE %d deleted\n", swIfIndex)
 		if dbgMemif {
-			vppmemif.DumpMemif(vppCh.Ch)
-			vppmemif.DumpMemifSocket(vppCh.Ch)
+			vppmemif.DumpMemif(os.Stdout, vppCh.Ch)
+			vppmemif.DumpMemifSocket(os.Stdout, vppCh.Ch)
 		}
 	}
 }
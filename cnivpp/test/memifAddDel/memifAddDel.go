@@ -96,19 +96,19 @@ func main() {
 	} else {
 		fmt.Println("MEMIF SOCKET", memifSocketId, memifSocketFile, "created")
 		if dbgMemif {
-			vppmemif.DumpMemifSocket(vppCh.Ch)
+			vppmemif.DumpMemifSocket(os.Stdout, vppCh.Ch)
 		}
 	}
 
 	// Create MemIf Interface
-	swIfIndex, err = vppmemif.CreateMemifInterface(vppCh.Ch, memifSocketId, memifRole, memifMode)
+	swIfIndex, err = vppmemif.CreateMemifInterface(vppCh.Ch, memifSocketId, memifRole, memifMode, nil)
 	if err != nil {
 		fmt.Println("Error:", err)
 		os.Exit(1)
 	} else {
 		fmt.Println("MEMIF", swIfIndex, "created")
 		if dbgMemif {
-			vppmemif.DumpMemif(vppCh.Ch)
+			vppmemif.DumpMemif(os.Stdout, vppCh.Ch)
 		}
 	}
 
@@ -121,7 +121,7 @@ func main() {
 	} else {
 		fmt.Printf("INTERFACE %d add to BRIDGE %d\n", swIfIndex, bridgeDomain)
 		if dbgBridge {
-			vppbridge.DumpBridge(vppCh.Ch, bridgeDomain)
+			vppbridge.DumpBridge(os.Stdout, vppCh.Ch, bridgeDomain)
 		}
 	}
 
@@ -139,7 +139,7 @@ func main() {
 	} else {
 		fmt.Printf("INTERFACE %d removed from BRIDGE %d\n", swIfIndex, bridgeDomain)
 		if dbgBridge {
-			vppbridge.DumpBridge(vppCh.Ch, bridgeDomain)
+			vppbridge.DumpBridge(os.Stdout, vppCh.Ch, bridgeDomain)
 		}
 	}
 
@@ -155,8 +155,8 @@ func main() {
 	} else {
 		fmt.Printf("INTERFACE %d deleted\n", swIfIndex)
 		if dbgMemif {
-			vppmemif.DumpMemif(vppCh.Ch)
-			vppmemif.DumpMemifSocket(vppCh.Ch)
+			vppmemif.DumpMemif(os.Stdout, vppCh.Ch)
+			vppmemif.DumpMemifSocket(os.Stdout, vppCh.Ch)
 		}
 	}
 }
@@ -94,7 +94,7 @@ func main() {
 	} else {
 		fmt.Println("Vhost-User", swIfIndex, "created")
 		if dbgVhostUser {
-			vppvhostuser.DumpVhostUser(vppCh.Ch)
+			vppvhostuser.DumpVhostUser(os.Stdout, vppCh.Ch)
 		}
 	}
 
@@ -107,7 +107,7 @@ func main() {
 	} else {
 		fmt.Printf("INTERFACE %d add to BRIDGE %d\n", swIfIndex, bridgeDomain)
 		if dbgBridge {
-			vppbridge.DumpBridge(vppCh.Ch, bridgeDomain)
+			vppbridge.DumpBridge(os.Stdout, vppCh.Ch, bridgeDomain)
 		}
 	}
 
@@ -125,7 +125,7 @@ func main() {
 	} else {
 		fmt.Printf("INTERFACE %d removed from BRIDGE %d\n", swIfIndex, bridgeDomain)
 		if dbgBridge {
-			vppbridge.DumpBridge(vppCh.Ch, bridgeDomain)
+			vppbridge.DumpBridge(os.Stdout, vppCh.Ch, bridgeDomain)
 		}
 	}
 
@@ -141,7 +141,7 @@ func main() {
 	} else {
 		fmt.Printf("INTERFACE %d deleted\n", swIfIndex)
 		if dbgVhostUser {
-			vppvhostuser.DumpVhostUser(vppCh.Ch)
+			vppvhostuser.DumpVhostUser(os.Stdout, vppCh.Ch)
 		}
 	}
 }
@@ -22,20 +22,46 @@
 // the container. All the work is done in the cnivpp library. This
 // is just a wrapper to access the library.
 //
+// If the host delivers the config as a pod annotation instead, set
+// USERSPACE_ANNOTATIONS_FILE to the annotations file of the downward API
+// volume, such as /etc/podinfo/annotations.
+//
+// vpp-app keeps running to process DELs: the host writes a deletion
+// marker, or removes the annotation, and vpp-app deletes the interface
+// from the local VPP instance. It also reports the status of each
+// interface, including the link state, back to the host, where ADD may be
+// waiting for the link to come up (linkUpTimeout).
+//
 
 package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 
+	"github.com/Billy99/user-space-net-plugin/cnivpp/api/infra"
 	"github.com/Billy99/user-space-net-plugin/cnivpp/cnivpp"
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
 )
 
 //
 // Constants
 //
 
+// vpp-app is normally started alongside VPP, so be more patient than the
+// CNI waiting for VPP to come up, but give up after about a minute.
+const (
+	connectRetries    = 10
+	connectBackoff    = time.Second
+	connectMaxBackoff = 10 * time.Second
+)
+
+const annotationsFileEnv = "USERSPACE_ANNOTATIONS_FILE"
+
+// The host waits for DELs to be reported back, so poll often.
+const pollInterval = time.Second
+
 //
 // Types
 //
@@ -45,36 +71,50 @@ import (
 //
 func main() {
 	var count int = 0
-	var processed bool = false
-	var processedCnt int = 0
+
+	// Open a single Channel to the local VPP and keep it for the life of
+	// the application.
+	opts := vppinfra.DefaultConnectOptions()
+	opts.Retries = connectRetries
+	opts.Backoff = connectBackoff
+	opts.MaxBackoff = connectMaxBackoff
+
+	vppCh, err := vppinfra.VppOpenChWithOptions(opts)
+	if err != nil {
+		fmt.Println("ERROR returned:", err)
+		os.Exit(1)
+	}
+	defer vppinfra.VppCloseCh(vppCh)
+
+	annotationsFile := os.Getenv(annotationsFileEnv)
+	done := make(map[string]usrsptypes.RemoteConfig)
 
 	for {
 		count++
 
-		found, err := cnivpp.CniContainerConfig()
+		var found, deleted, changed bool
+		if annotationsFile != "" {
+			found, err = cnivpp.CniContainerConfigFromAnnotations(&vppCh, annotationsFile, done)
+		} else {
+			found, err = cnivpp.CniContainerConfig(&vppCh)
+			if err != nil {
+				fmt.Println("ERROR returned:", err)
+			}
+			deleted, err = cnivpp.CniContainerDelete(&vppCh)
+			if err != nil {
+				fmt.Println("ERROR returned:", err)
+			}
+			changed, err = cnivpp.CniContainerStatus(&vppCh)
+		}
 
 		if err != nil {
 			fmt.Println("ERROR returned:", err)
 		}
 
-		fmt.Println("LOOP", count, " - FOUND:", found)
-
-		//
-		// Once files have been found, wait 1 more loop and exit.
-		//
-		if found {
-			processed = true
-		}
-
-		if processed {
-			processedCnt++
-
-			if processedCnt > 1 {
-				fmt.Println("DONE: Exiting vpp-app")
-				break
-			}
+		if found || deleted || changed {
+			fmt.Println("LOOP", count, " - FOUND:", found, " - DELETED:", deleted, " - LINK CHANGED:", changed)
 		}
 
-		time.Sleep(20 * time.Second)
+		time.Sleep(pollInterval)
 	}
 }
@@ -32,8 +32,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 
 	"github.com/Billy99/user-space-net-plugin/usrsptypes"
 )
@@ -41,10 +43,15 @@ import (
 //
 // Constants
 //
-const defaultBaseCNIDir = "/var/run/vpp/cni"
-const defaultLocalCNIDir = "/var/run/vpp/cni/data"
 const debugVppDb = false
 
+// How often RequestRemoteDelete() checks whether vpp-app removed the marker.
+const remoteDeletePoll = 100 * time.Millisecond
+
+// Directories the data is saved in. Changed by SetBaseDir().
+var defaultBaseCNIDir = "/var/run/vpp/cni"
+var defaultLocalCNIDir = "/var/run/vpp/cni/data"
+
 //
 // Types
 //
@@ -53,7 +60,50 @@ const debugVppDb = false
 // interfaces) that need to be preserved for later use.
 type VppSavedData struct {
 	SwIfIndex     uint32 `json:"swIfIndex"`     // Software Index, used to access the created interface, needed to delete interface.
-	MemifSocketId uint32 `json:"memifSocketId"` // Memif SocketId, used to access the created memif Socket File, used for debug only.
+	MemifSocketId uint32 `json:"memifSocketId"` // Memif SocketId, used to release the memif Socket File if the interface is already gone.
+
+	// Used to match the saved data against the objects in VPP. Not present in
+	// data saved by older versions.
+	ContainerId string `json:"containerId,omitempty"` // Full ContainerId, file name only contains the first 12 characters.
+	NetName     string `json:"netName,omitempty"`     // Network name from the NetConf, used to scope CNI GC.
+	IfName      string `json:"ifName,omitempty"`      // Interface name from the NetConf (If0name).
+	IfType      string `json:"ifType,omitempty"`      // Interface type {memif|vhostuser}
+	SocketFile  string `json:"socketFile,omitempty"`  // Socket file used by the interface.
+	BridgeId    uint32 `json:"bridgeId,omitempty"`    // Bridge the interface was added to, 0 if none.
+	Mtu         int    `json:"mtu,omitempty"`         // MTU set on the interface, 0 if left at the VPP default.
+
+	// The directory of SocketFile was created by the CNI for the pod, and is
+	// removed with its last socket. Other directories, like those of the
+	// runtime, are left in place.
+	SocketDirOwned bool `json:"socketDirOwned,omitempty"`
+
+	// All the interfaces created on the socket, when more than one. SwIfIndex
+	// is the first of them.
+	SwIfIndexes []uint32 `json:"swIfIndexes,omitempty"`
+
+	// Routes to the pod added through the interface for NetType interface,
+	// deleted with it.
+	Routes []string `json:"routes,omitempty"`
+
+	// Gateways of the default routes added through the interface in the
+	// container, deleted with it.
+	Gateways []string `json:"gateways,omitempty"`
+
+	// Tag of the loopback holding the gateways the interface borrows on the
+	// host, deleted with the last interface using it.
+	GatewayLoopback string `json:"gatewayLoopback,omitempty"`
+
+	// MACs in use, as set by the macPolicy or chosen by VPP.
+	Mac          string `json:"mac,omitempty"`          // MAC of the interface.
+	ContainerMac string `json:"containerMac,omitempty"` // MAC of the other end of the connection, in the container.
+}
+
+// Return the swIfIndex of every interface created.
+func (data *VppSavedData) SwIfIndexList() []uint32 {
+	if len(data.SwIfIndexes) != 0 {
+		return data.SwIfIndexes
+	}
+	return []uint32{data.SwIfIndex}
 }
 
 // This structure is used to pass additional data outside of the usrsptypes date into the container.
@@ -66,6 +116,13 @@ type additionalData struct {
 // API Functions
 //
 
+// SetBaseDir() - Save all data under dir instead of /var/run/vpp/cni. Used
+//  by tests, which can't write to /var/run.
+func SetBaseDir(dir string) {
+	defaultBaseCNIDir = dir
+	defaultLocalCNIDir = filepath.Join(dir, "data")
+}
+
 // saveVppConfig() - Some data needs to be saved, like the swIfIndex, for cmdDel().
 //  This function squirrels the data away to be retrieved later.
 func SaveVppConfig(conf *usrsptypes.NetConf, containerID string, data *VppSavedData) error {
@@ -91,7 +148,7 @@ func SaveVppConfig(conf *usrsptypes.NetConf, containerID string, data *VppSavedD
 		path := filepath.Join(sockDir, fileName)
 
 		if debugVppDb {
-			fmt.Printf("SAVE FILE: swIfIndex=%d path=%s dataBytes=%s\n", data.SwIfIndex, path, dataBytes)
+			fmt.Fprintf(os.Stderr, "SAVE FILE: swIfIndex=%d path=%s dataBytes=%s\n", data.SwIfIndex, path, dataBytes)
 		}
 		return ioutil.WriteFile(path, dataBytes, 0644)
 	} else {
@@ -124,6 +181,56 @@ func LoadVppConfig(conf *usrsptypes.NetConf, containerID string, data *VppSavedD
 	return nil
 }
 
+// ListVppConfig() - Return all the data saved by SaveVppConfig(). Unlike
+//  LoadVppConfig(), the files are left in place.
+func ListVppConfig() ([]VppSavedData, error) {
+	var list []VppSavedData
+
+	matches, err := filepath.Glob(filepath.Join(defaultLocalCNIDir, "local-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range matches {
+		var data VppSavedData
+
+		dataBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return list, fmt.Errorf("ERROR: Failed to read VPP saved data: %v", err)
+		}
+		if err = json.Unmarshal(dataBytes, &data); err != nil {
+			return list, fmt.Errorf("ERROR: Failed to parse VPP saved data %s: %v", path, err)
+		}
+
+		// Fill in what can be recovered from the file name for data
+		// saved by older versions.
+		containerId, ifName := parseLocalFileName(filepath.Base(path))
+		if data.ContainerId == "" {
+			data.ContainerId = containerId
+		}
+		if data.IfName == "" {
+			data.IfName = ifName
+		}
+
+		list = append(list, data)
+	}
+
+	return list, nil
+}
+
+// DeleteVppConfig() - Remove the data saved by SaveVppConfig() for the given
+//  container and interface without reading it.
+func DeleteVppConfig(containerID string, ifName string) error {
+	if len(containerID) < 12 {
+		return fmt.Errorf("ERROR: Invalid ContainerId: %s", containerID)
+	}
+
+	fileName := fmt.Sprintf("local-%s-%s.json", containerID[:12], ifName)
+	path := filepath.Join(defaultLocalCNIDir, fileName)
+
+	return FileCleanup(defaultLocalCNIDir, path)
+}
+
 //
 // Functions for processing Remote Configs (configs for within a Container)
 //
@@ -156,50 +263,14 @@ func SaveRemoteConfig(conf *usrsptypes.NetConf, ipResult *current.Result, contai
 	//
 	// Convert the remote configuration into a local configuration
 	//
-	dataCopy = *conf
-	dataCopy.HostConf = dataCopy.ContainerConf
-	dataCopy.ContainerConf = usrsptypes.UserSpaceConf{}
-
-	// IPAM is processed by the host and sent to the Container. So blank out what was already processed.
-	dataCopy.IPAM.Type = ""
-
-	// Convert empty variables to valid data based on the original HostConf
-	if dataCopy.HostConf.Engine == "" {
-		dataCopy.HostConf.Engine = conf.HostConf.Engine
-	}
-	if dataCopy.HostConf.IfType == "" {
-		dataCopy.HostConf.IfType = conf.HostConf.IfType
-	}
-	if dataCopy.HostConf.NetType == "" {
-		dataCopy.HostConf.NetType = "interface"
-	}
-
-	if dataCopy.HostConf.IfType == "memif" {
-		if dataCopy.HostConf.MemifConf.Role == "" {
-			if conf.HostConf.MemifConf.Role == "master" {
-				dataCopy.HostConf.MemifConf.Role = "slave"
-			} else {
-				dataCopy.HostConf.MemifConf.Role = "master"
-			}
-		}
-		if dataCopy.HostConf.MemifConf.Mode == "" {
-			dataCopy.HostConf.MemifConf.Mode = conf.HostConf.MemifConf.Mode
-		}
-	} else if dataCopy.HostConf.IfType == "vhostuser" {
-		if dataCopy.HostConf.VhostConf.Mode == "" {
-			if conf.HostConf.VhostConf.Mode == "client" {
-				dataCopy.HostConf.VhostConf.Mode = "server"
-			} else {
-				dataCopy.HostConf.VhostConf.Mode = "client"
-			}
-		}
-	}
+	remote := usrsptypes.NewRemoteConfig(conf, ipResult, containerID)
+	dataCopy = remote.NetConf
 
 	//
 	// Gather the additional data
 	//
-	addData.ContainerId = containerID
-	addData.IPResult = *ipResult
+	addData.ContainerId = remote.ContainerId
+	addData.IPResult = remote.IPResult
 
 	//
 	// Marshall data and write to file
@@ -211,7 +282,7 @@ func SaveRemoteConfig(conf *usrsptypes.NetConf, ipResult *current.Result, contai
 
 	if err == nil {
 		if debugVppDb {
-			fmt.Printf("SAVE FILE: path=%s dataBytes=%s", path, dataBytes)
+			fmt.Fprintf(os.Stderr, "SAVE FILE: path=%s dataBytes=%s", path, dataBytes)
 		}
 		err = ioutil.WriteFile(path, dataBytes, 0644)
 	} else {
@@ -226,7 +297,7 @@ func SaveRemoteConfig(conf *usrsptypes.NetConf, ipResult *current.Result, contai
 
 		if err == nil {
 			if debugVppDb {
-				fmt.Printf("SAVE FILE: path=%s dataBytes=%s", path, dataBytes)
+				fmt.Fprintf(os.Stderr, "SAVE FILE: path=%s dataBytes=%s", path, dataBytes)
 			}
 			err = ioutil.WriteFile(path, dataBytes, 0644)
 		} else {
@@ -265,14 +336,57 @@ func FindRemoteConfig() (bool, usrsptypes.NetConf, current.Result, string, error
 					return found, conf, addData.IPResult, addData.ContainerId, fmt.Errorf("failed to read AddData config: %v", err)
 				}
 			}
-		} else {
-			return found, conf, addData.IPResult, addData.ContainerId, fmt.Errorf("failed to read Remote config: %v", err)
 		}
 	}
 
 	return found, conf, addData.IPResult, addData.ContainerId, err
 }
 
+// ReadRemoteConfigs() - In the Container, return every remote config
+//  written by SaveRemoteConfig() to dir, the directory it is mounted at,
+//  in order of interface name. Unlike FindRemoteConfig(), the files are
+//  left in place. A remote config without its additional data is still
+//  being written and is skipped.
+func ReadRemoteConfigs(dir string) ([]usrsptypes.RemoteConfig, error) {
+	var list []usrsptypes.RemoteConfig
+
+	matches, err := filepath.Glob(filepath.Join(dir, "remote-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range matches {
+		var remote usrsptypes.RemoteConfig
+		var addData additionalData
+
+		dataBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return list, fmt.Errorf("failed to read Remote config: %v", err)
+		}
+		if err = json.Unmarshal(dataBytes, &remote.NetConf); err != nil {
+			return list, fmt.Errorf("failed to parse Remote config: %v", err)
+		}
+
+		addPath := filepath.Join(dir, fmt.Sprintf("addData-%s.json", remote.NetConf.If0name))
+		dataBytes, err = ioutil.ReadFile(addPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return list, fmt.Errorf("failed to read AddData config: %v", err)
+		}
+		if err = json.Unmarshal(dataBytes, &addData); err != nil {
+			return list, fmt.Errorf("failed to parse AddData config: %v", err)
+		}
+
+		remote.ContainerId = addData.ContainerId
+		remote.IPResult = addData.IPResult
+		list = append(list, remote)
+	}
+
+	return list, nil
+}
+
 // CleanupRemoteConfig() - When a config read on the host is for a Container,
 //      the data to a file. This function cleans up the remaining files.
 func CleanupRemoteConfig(conf *usrsptypes.NetConf, containerID string) {
@@ -283,10 +397,179 @@ func CleanupRemoteConfig(conf *usrsptypes.NetConf, containerID string) {
 	sockDir := filepath.Join(defaultBaseCNIDir, containerID)
 
 	if err := os.RemoveAll(sockDir); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 	}
 }
 
+// RequestRemoteDelete() - Ask vpp-app in the Container to delete the
+//  interface it created from the remote config, by writing a deletion
+//  marker next to the remote config:
+//    /var/run/vpp/cni/<ContainerId>/delete-<If0name>.json
+//  vpp-app reports the delete back by removing the marker. Waits up to
+//  timeout for it and returns true if it was reported back. Nothing is
+//  asked if the Container never picked up the remote config.
+func RequestRemoteDelete(conf *usrsptypes.NetConf, containerID string, timeout time.Duration) (bool, error) {
+
+	sockDir := filepath.Join(defaultBaseCNIDir, containerID)
+
+	if _, err := os.Stat(sockDir); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// The remote config is deleted by vpp-app once processed.
+	if _, err := os.Stat(filepath.Join(sockDir, fmt.Sprintf("remote-%s.json", conf.If0name))); err == nil {
+		return false, nil
+	}
+
+	remote := usrsptypes.NewRemoteConfig(conf, nil, containerID)
+	dataBytes, err := json.Marshal(remote)
+	if err != nil {
+		return false, fmt.Errorf("ERROR: serializing REMOTE delete data: %v", err)
+	}
+
+	path := filepath.Join(sockDir, fmt.Sprintf("delete-%s.json", conf.If0name))
+	if debugVppDb {
+		fmt.Fprintf(os.Stderr, "SAVE FILE: path=%s dataBytes=%s", path, dataBytes)
+	}
+	if err = ioutil.WriteFile(path, dataBytes, 0644); err != nil {
+		return false, err
+	}
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(remoteDeletePoll) {
+		if _, err = os.Stat(path); os.IsNotExist(err) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ListRemoteDelete() - In the Container, return the deletion markers written
+//  by RequestRemoteDelete() and not yet processed.
+func ListRemoteDelete() ([]string, error) {
+	return filepath.Glob(filepath.Join(defaultLocalCNIDir, "delete-*.json"))
+}
+
+// ReadRemoteDelete() - Read the remote config of the interface to delete
+//  from a deletion marker. The marker is left in place, removing it reports
+//  the delete back to the host.
+func ReadRemoteDelete(path string) (usrsptypes.RemoteConfig, error) {
+	var remote usrsptypes.RemoteConfig
+
+	dataBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return remote, fmt.Errorf("failed to read Remote delete: %v", err)
+	}
+	if err = json.Unmarshal(dataBytes, &remote); err != nil {
+		return remote, fmt.Errorf("failed to parse Remote delete: %v", err)
+	}
+
+	return remote, nil
+}
+
+// SaveRemoteStatus() - In the Container, report the status of the interface
+//  created from a remote config back to the host, in the file:
+//    /var/run/vpp/cni/<ContainerId>/status-<If0name>.json
+//  The file is replaced in one step so the host never reads it half written.
+func SaveRemoteStatus(status *usrsptypes.RemoteStatus) error {
+
+	if _, err := os.Stat(defaultLocalCNIDir); err != nil {
+		return err
+	}
+
+	dataBytes, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("ERROR: serializing REMOTE status data: %v", err)
+	}
+
+	path := filepath.Join(defaultLocalCNIDir, fmt.Sprintf("status-%s.json", status.IfName))
+	if debugVppDb {
+		fmt.Fprintf(os.Stderr, "SAVE FILE: path=%s dataBytes=%s", path, dataBytes)
+	}
+	if err = ioutil.WriteFile(path+".tmp", dataBytes, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadRemoteStatus() - On the host, read the status the Container reported
+//  for the interface of conf. Returns false if nothing was reported yet.
+func LoadRemoteStatus(conf *usrsptypes.NetConf, containerID string) (bool, usrsptypes.RemoteStatus, error) {
+	var status usrsptypes.RemoteStatus
+
+	path := filepath.Join(defaultBaseCNIDir, containerID, fmt.Sprintf("status-%s.json", conf.If0name))
+
+	dataBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, status, nil
+		}
+		return false, status, fmt.Errorf("failed to read Remote status: %v", err)
+	}
+	if err = json.Unmarshal(dataBytes, &status); err != nil {
+		return false, status, fmt.Errorf("failed to parse Remote status: %v", err)
+	}
+
+	return true, status, nil
+}
+
+// ListRemoteStatus() - In the Container, return the status reported for
+//  every interface created from a remote config.
+func ListRemoteStatus() ([]usrsptypes.RemoteStatus, error) {
+	var list []usrsptypes.RemoteStatus
+
+	matches, err := filepath.Glob(filepath.Join(defaultLocalCNIDir, "status-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range matches {
+		var status usrsptypes.RemoteStatus
+
+		dataBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return list, fmt.Errorf("failed to read Remote status: %v", err)
+		}
+		if err = json.Unmarshal(dataBytes, &status); err != nil {
+			return list, fmt.Errorf("failed to parse Remote status: %v", err)
+		}
+		list = append(list, status)
+	}
+
+	return list, nil
+}
+
+// ListRemoteConfig() - Return the ContainerIds that have a remote config
+//  directory, written by SaveRemoteConfig().
+func ListRemoteConfig() ([]string, error) {
+	var list []string
+
+	files, err := ioutil.ReadDir(defaultBaseCNIDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() == false {
+			continue
+		}
+		// Skip the directories used by the host itself.
+		if file.Name() == filepath.Base(defaultLocalCNIDir) || file.Name() == "shared" {
+			continue
+		}
+		list = append(list, file.Name())
+	}
+
+	return list, nil
+}
+
 //
 // Utility Functions
 //
@@ -322,35 +605,45 @@ func FileCleanup(directory string, filepath string) (err error) {
 	return
 }
 
+// Split "local-<ContainerId:12>-<If0name>.json" into its parts.
+func parseLocalFileName(fileName string) (containerId string, ifName string) {
+	name := strings.TrimSuffix(strings.TrimPrefix(fileName, "local-"), ".json")
+	if len(name) < 14 || name[12] != '-' {
+		return "", ""
+	}
+
+	return name[:12], name[13:]
+}
+
 func findFile(filePath string) (bool, []byte, error) {
 	var found bool = false
 
 	if debugVppDb {
-		fmt.Println(filePath)
+		fmt.Fprintln(os.Stderr, filePath)
 	}
 	matches, err := filepath.Glob(filePath)
 
 	if err != nil {
 		if debugVppDb {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 		}
 		return found, nil, err
 	}
 
 	if debugVppDb {
-		fmt.Println(matches)
+		fmt.Fprintln(os.Stderr, matches)
 	}
 
 	for i := range matches {
 		if debugVppDb {
-			fmt.Printf("PROCESSING FILE: path=%s\n", matches[i])
+			fmt.Fprintf(os.Stderr, "PROCESSING FILE: path=%s\n", matches[i])
 		}
 
 		found = true
 
 		if dataBytes, err := ioutil.ReadFile(matches[i]); err == nil {
 			if debugVppDb {
-				fmt.Printf("FILE DATA:\n%s\n", dataBytes)
+				fmt.Fprintf(os.Stderr, "FILE DATA:\n%s\n", dataBytes)
 			}
 
 			// Delete file (and directory if empty)
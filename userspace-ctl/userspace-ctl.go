@@ -0,0 +1,345 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// userspace-ctl inspects and repairs the node state left by the UserSpace
+// CNI. It reads the data saved by the cnivpp and cniovs libraries, queries
+// the local VPP and OVS instances for the objects that actually exist, and
+// shows them side by side. Objects only present on one side are orphaned
+// and can be removed with the 'gc' command.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Billy99/user-space-net-plugin/cniovs/cniovs"
+	"github.com/Billy99/user-space-net-plugin/cnivpp/cnivpp"
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+//
+// Types
+//
+
+// One interface, from either engine, as displayed by userspace-ctl.
+type entry struct {
+	engine      string
+	containerId string
+	ifName      string
+	ifType      string
+	ref         string // swIfIndex for VPP, port name for OVS.
+	socketFile  string
+	state       string
+	orphaned    bool
+	detail      interface{}  // Engine specific inventory entry, printed by 'show'.
+	remove      func() error // Deletes whatever exists of the entry.
+}
+
+//
+// Functions
+//
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <command>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  list                 List all interfaces created by the UserSpace CNI.\n")
+	fmt.Fprintf(os.Stderr, "  show <containerID>   Show saved data and live objects of a container.\n")
+	fmt.Fprintf(os.Stderr, "  verify               List orphaned entries, exit with 1 if any are found.\n")
+	fmt.Fprintf(os.Stderr, "  gc                   Delete orphaned entries. With --live, also delete everything\n")
+	fmt.Fprintf(os.Stderr, "                       belonging to containers not in the list.\n")
+	fmt.Fprintf(os.Stderr, "Options:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	engine := flag.String("engine", "all", "Engine to inspect {vpp|ovs-dpdk|all}")
+	dryRun := flag.Bool("dry-run", false, "gc: only print what would be deleted")
+	live := flag.String("live", "", "gc: comma separated list of live container IDs")
+	socketDir := flag.String("socket-dir", "", "socketDir template of the networks, to also inspect the per pod socket directories")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	vpp := cnivpp.CniVpp{}
+	if *socketDir != "" {
+		conf := usrsptypes.NetConf{SocketDir: *socketDir}
+		socketDirGlob, err := conf.SocketDirGlob()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid socket-dir:", err)
+			os.Exit(2)
+		}
+		vpp.SocketDirGlobs = []string{socketDirGlob}
+	}
+
+	entries := collect(*engine, vpp)
+
+	switch flag.Arg(0) {
+	case "list":
+		printTable(entries)
+	case "show":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if show(entries, flag.Arg(1)) == false {
+			fmt.Fprintf(os.Stderr, "No entries found for container %s\n", flag.Arg(1))
+			os.Exit(1)
+		}
+	case "verify":
+		orphans := filterOrphans(entries)
+		printTable(orphans)
+		if len(orphans) != 0 {
+			os.Exit(1)
+		}
+	case "gc":
+		ok := gc(filterOrphans(entries), *dryRun)
+		if *live != "" {
+			ok = gcDeadContainers(*engine, vpp, strings.Split(*live, ","), *dryRun) && ok
+		}
+		if ok == false {
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// Gather the inventory of the requested engines. An engine that can't be
+// queried is reported, but doesn't prevent the others from being shown.
+func collect(engine string, vpp cnivpp.CniVpp) []entry {
+	var entries []entry
+
+	if engine == "all" || engine == "vpp" {
+		list, err := vpp.Inventory()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "VPP:", err)
+		}
+		for i := range list {
+			item := list[i]
+			ref := fmt.Sprintf("%d", item.SwIfIndex())
+			for _, memifIntf := range item.ExtraMemifs {
+				ref += fmt.Sprintf(",%d", memifIntf.SwIfIndex)
+			}
+			e := entry{
+				engine:      "vpp",
+				containerId: item.ContainerId(),
+				ref:         ref,
+				socketFile:  item.SocketFile,
+				orphaned:    item.Orphaned(),
+				detail:      item,
+				remove:      func() error { return vpp.RemoveInventoryEntry(item) },
+			}
+			if item.Saved != nil {
+				e.ifName = item.Saved.IfName
+				e.ifType = item.Saved.IfType
+			}
+			if item.Memif != nil {
+				e.ifType = "memif"
+			} else if item.Vhost != nil {
+				e.ifType = "vhostuser"
+			}
+			e.state = vppState(item)
+			entries = append(entries, e)
+		}
+	}
+
+	if engine == "all" || engine == "ovs-dpdk" {
+		ovs := cniovs.CniOvs{}
+		list, err := ovs.Inventory()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "OVS:", err)
+		}
+		for i := range list {
+			item := list[i]
+			e := entry{
+				engine:      "ovs-dpdk",
+				containerId: item.ContainerId(),
+				ifType:      "vhostuser",
+				ref:         item.Port,
+				socketFile:  item.SocketFile,
+				orphaned:    item.Orphaned(),
+				detail:      item,
+				remove:      func() error { return ovs.RemoveInventoryEntry(item) },
+			}
+			if item.Saved != nil {
+				e.ifName = item.Saved.Ifname
+				if e.ref == "" {
+					e.ref = item.Saved.Vhostname
+				}
+			}
+			if item.Saved == nil {
+				e.state = "no-saved-data"
+			} else if item.InOvs() == false {
+				e.state = "missing-in-ovs"
+			} else {
+				e.state = "ok"
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+func vppState(item cnivpp.InventoryEntry) string {
+	if item.Saved == nil {
+		return "no-saved-data"
+	}
+	if item.InVpp() == false {
+		return "missing-in-vpp"
+	}
+	if item.Memif != nil && item.Memif.LinkUp == false {
+		return "ok (link down)"
+	}
+	return "ok"
+}
+
+func filterOrphans(entries []entry) []entry {
+	var orphans []entry
+
+	for _, e := range entries {
+		if e.orphaned {
+			orphans = append(orphans, e)
+		}
+	}
+	return orphans
+}
+
+func printTable(entries []entry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ENGINE\tCONTAINER\tIFNAME\tIFTYPE\tREF\tSOCKET\tSTATE")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.engine, shortId(e.containerId), dash(e.ifName), dash(e.ifType), dash(e.ref), dash(e.socketFile), e.state)
+	}
+	w.Flush()
+}
+
+// Print every entry belonging to the given container. Saved data may only
+// hold the first 12 characters of the ContainerId, so match on the prefix.
+func show(entries []entry, containerId string) bool {
+	var found bool
+
+	for _, e := range entries {
+		if e.containerId == "" ||
+			(strings.HasPrefix(containerId, e.containerId) == false &&
+				strings.HasPrefix(e.containerId, containerId) == false) {
+			continue
+		}
+		found = true
+
+		fmt.Printf("%s %s (%s):\n", e.engine, dash(e.ifName), e.state)
+		if dataBytes, err := json.MarshalIndent(e.detail, "  ", "  "); err == nil {
+			fmt.Printf("  %s\n", dataBytes)
+		}
+	}
+
+	return found
+}
+
+func gc(orphans []entry, dryRun bool) bool {
+	var ok bool = true
+
+	for _, e := range orphans {
+		if dryRun {
+			fmt.Printf("Would remove %s %s %s %s\n", e.engine, shortId(e.containerId), dash(e.ifName), dash(e.ref))
+			continue
+		}
+
+		if err := e.remove(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove %s %s %s: %v\n", e.engine, shortId(e.containerId), dash(e.ref), err)
+			ok = false
+		} else {
+			fmt.Printf("Removed %s %s %s %s\n", e.engine, shortId(e.containerId), dash(e.ifName), dash(e.ref))
+		}
+	}
+
+	return ok
+}
+
+// Remove everything belonging to containers not in liveIDs, in all
+// networks.
+func gcDeadContainers(engine string, vpp cnivpp.CniVpp, liveIDs []string, dryRun bool) bool {
+	var ok bool = true
+
+	if dryRun {
+		entries := collect(engine, vpp)
+		for _, e := range entries {
+			if e.containerId != "" && isLive(e.containerId, liveIDs) == false {
+				fmt.Printf("Would remove %s %s %s %s\n", e.engine, shortId(e.containerId), dash(e.ifName), dash(e.ref))
+			}
+		}
+		return ok
+	}
+
+	if engine == "all" || engine == "vpp" {
+		removed, err := vpp.GarbageCollect("", liveIDs)
+		for _, item := range removed {
+			fmt.Println("Removed", item)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "VPP:", err)
+			ok = false
+		}
+	}
+
+	if engine == "all" || engine == "ovs-dpdk" {
+		ovs := cniovs.CniOvs{}
+		removed, err := ovs.GarbageCollect("", liveIDs)
+		for _, item := range removed {
+			fmt.Println("Removed", item)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "OVS:", err)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+func isLive(containerId string, liveIDs []string) bool {
+	for _, liveId := range liveIDs {
+		if liveId != "" && (strings.HasPrefix(liveId, containerId) || strings.HasPrefix(containerId, liveId)) {
+			return true
+		}
+	}
+	return false
+}
+
+func shortId(containerId string) string {
+	if len(containerId) > 12 {
+		return containerId[:12]
+	}
+	return dash(containerId)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -15,16 +15,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"runtime"
+	"time"
 
+	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
 	cnitypes "github.com/containernetworking/cni/pkg/types"
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	cniSpecVersion "github.com/containernetworking/cni/pkg/version"
-	"github.com/containernetworking/plugins/pkg/ip"
-	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/ns"
 
 	"github.com/Billy99/user-space-net-plugin/cniovs/cniovs"
@@ -41,6 +43,18 @@ func init() {
 	runtime.LockOSThread()
 }
 
+//
+// Constants
+//
+
+// CNI Spec 1.1: STATUS error when the plugin can't service ADD requests.
+// Not yet defined in the vendored cni types.
+const errPluginNotAvailable = 50
+
+// CNI versions this plugin supports. 0.1.0 and 0.2.0 results can't
+// describe interfaces, so are not offered.
+var supportedVersions = cniSpecVersion.PluginSupports(usrsptypes.SupportedCNIVersions...)
+
 //
 // Local functions
 //
@@ -49,74 +63,267 @@ func init() {
 func loadNetConf(bytes []byte) (*usrsptypes.NetConf, error) {
 	n := &usrsptypes.NetConf{}
 	if err := json.Unmarshal(bytes, n); err != nil {
-		return nil, fmt.Errorf("failed to load netconf: %v", err)
+		return nil, cnitypes.NewError(cnitypes.ErrDecodingFailure, "failed to load netconf", err.Error())
+	}
+
+	if n.Strict {
+		if err := usrsptypes.CheckUnknownFields(bytes); err != nil {
+			return nil, cnitypes.NewError(cnitypes.ErrInvalidNetworkConfig, err.Error(), "")
+		}
+	}
+
+	// Parse the result of the previous plugin when run in a chain.
+	if err := cniSpecVersion.ParsePrevResult(&n.NetConf); err != nil {
+		return nil, cnitypes.NewError(cnitypes.ErrDecodingFailure, "failed to load prevResult", err.Error())
 	}
 
 	return n, nil
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
+// chainPrevResult() - Carry the result of the previous plugin in a chain
+//  forward into result. Interfaces, routes and DNS are kept as is. When no
+//  IPAM is configured, IPs not yet attached to an interface are adopted for
+//  the userspace interface. All other IPs are returned, to be added back to
+//  the result after the Engines have run so they are not applied to the
+//  userspace interface.
+func chainPrevResult(netConf *usrsptypes.NetConf, result *current.Result) ([]*current.IPConfig, error) {
+	var chainedIPs []*current.IPConfig
+
+	if netConf.PrevResult == nil {
+		return nil, nil
+	}
+
+	prevResult, err := current.NewResultFromResult(netConf.PrevResult)
+	if err != nil {
+		return nil, cnitypes.NewError(cnitypes.ErrDecodingFailure, "failed to convert prevResult", err.Error())
+	}
+
+	result.Interfaces = append(prevResult.Interfaces, result.Interfaces...)
+	result.Routes = append(prevResult.Routes, result.Routes...)
+	if len(result.DNS.Nameservers) == 0 {
+		result.DNS = prevResult.DNS
+	}
+
+	for _, ip := range prevResult.IPs {
+		if ip.Interface == nil && netConf.IPAM.Type == "" {
+			result.IPs = append(result.IPs, ip)
+		} else {
+			chainedIPs = append(chainedIPs, ip)
+		}
+	}
+
+	return chainedIPs, nil
+}
+
+// unknownEngine() - Spec error returned when the config names an Engine
+//  this plugin doesn't implement.
+func unknownEngine(kind string, engine string) error {
+	return cnitypes.NewError(cnitypes.ErrInvalidNetworkConfig, "ERROR: Unknown "+kind+" Engine:"+engine, "")
+}
+
+// addContainerInterface() - Add the container side interfaces to the CNI
+//  result and point each IP at the first of them. The container shares the
+//  socket of the host side interfaces added by the Host Engine, with one
+//  interface per memif ID.
+func addContainerInterface(netConf *usrsptypes.NetConf, args *skel.CmdArgs, result *current.Result) {
+	var socketPath string
+
+	ifName := netConf.If0name
+	if ifName == "" {
+		ifName = args.IfName
+	}
+	names := []string{ifName}
+
+	// The container end defaults to the interface type and memif IDs of
+	// the host end.
+	ifType := netConf.ContainerConf.IfType
+	if ifType == "" {
+		ifType = netConf.HostConf.IfType
+	}
+	if ifType == "memif" {
+		memifConf := netConf.ContainerConf.MemifConf
+		if len(memifConf.Interfaces) == 0 {
+			memifConf.Interfaces = netConf.HostConf.MemifConf.Interfaces
+		}
+
+		names = nil
+		for _, intf := range memifConf.InterfaceList(ifName) {
+			names = append(names, intf.Name)
+		}
+	}
+
+	if len(result.Interfaces) != 0 {
+		socketPath = result.Interfaces[len(result.Interfaces)-1].SocketPath
+	}
+
+	index := len(result.Interfaces)
+	for i, name := range names {
+		intf := &current.Interface{
+			Name:       name,
+			Mtu:        netConf.Mtu,
+			Sandbox:    args.Netns,
+			SocketPath: socketPath,
+		}
+		if i == 0 {
+			intf.Mac = netConf.ContainerConf.Mac
+		}
+		result.Interfaces = append(result.Interfaces, intf)
+	}
+
+	for _, ip := range result.IPs {
+		ip.Interface = current.Int(index)
+	}
+}
+
+// setKernelMtu() - Set the MTU of the kernel interface ifName in the
+//  container, if there is one. Userspace interfaces are normally consumed
+//  by an application in the container and have no kernel interface.
+func setKernelMtu(netns string, ifName string, mtu int) error {
+	if netns == "" || mtu == 0 {
+		return nil
+	}
+
+	return ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok {
+				return nil
+			}
+			return err
+		}
+		return netlink.LinkSetMTU(link, mtu)
+	})
+}
+
+func cmdAdd(args *skel.CmdArgs) (err error) {
 	var result *current.Result
 	var netConf *usrsptypes.NetConf
 	var containerEngine string
+	var ipamAdded, hostAdded, containerAdded bool
 
 	vpp := cnivpp.CniVpp{}
 	ovs := cniovs.CniOvs{}
 
 	// Convert the input bytestream into local NetConf structure
-	netConf, err := loadNetConf(args.StdinData)
+	netConf, err = loadNetConf(args.StdinData)
 	if err != nil {
 		return err
 	}
 
-	//
-	// HOST:
-	//
-
-	// Add the requested interface and network
-	if netConf.HostConf.Engine == "vpp" {
-		err = vpp.AddOnHost(netConf, args.ContainerID, result)
-	} else if netConf.HostConf.Engine == "ovs-dpdk" {
-		err = ovs.AddOnHost(netConf, args.ContainerID, result)
-	} else {
-		return fmt.Errorf("ERROR: Unknown Host Engine:" + netConf.HostConf.Engine)
+	// Apply the per pod overrides, then reject a bad configuration before
+	// any Engine changes state.
+	if err = netConf.ApplyOverrides(args.Args); err != nil {
+		return cnitypes.NewError(cnitypes.ErrInvalidNetworkConfig, err.Error(), "")
 	}
-	if err != nil {
+	if err = netConf.Validate(); err != nil {
+		return cnitypes.NewError(cnitypes.ErrInvalidNetworkConfig, err.Error(), "")
+	}
+	if err = netConf.ResolveMacs(args.ContainerID); err != nil {
 		return err
 	}
 
+	// When a step fails, undo the ones before it, so the failed ADD leaves
+	// neither end of the interface, the device-info nor the IPAM lease
+	// behind. AddOnHost() undoes its own steps.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if containerAdded {
+			if containerEngine == "vpp" {
+				vpp.DelFromContainer(netConf, args.ContainerID)
+			} else {
+				ovs.DelFromContainer(netConf, args.ContainerID)
+			}
+		}
+		if hostAdded {
+			if netConf.HostConf.Engine == "vpp" {
+				vpp.DelFromHost(netConf, args.ContainerID)
+			} else {
+				ovs.DelFromHost(netConf, args.ContainerID)
+			}
+			netConf.CleanDeviceInfo()
+		}
+		if ipamAdded {
+			invoke.DelegateDel(context.TODO(), netConf.IPAM.Type, args.StdinData, nil)
+		}
+	}()
+
 	//
-	// CONTAINER:
+	// IPAM:
 	//
 
-	// Get IPAM data for Container Interface, if provided.
+	// Get IPAM data for Container Interface, if provided. Done first because
+	// the Host Engine passes the result on to the container.
 	if netConf.IPAM.Type != "" {
 
 		// run the IPAM plugin and get back the config to apply
-		ipamResult, err := ipam.ExecAdd(netConf.IPAM.Type, args.StdinData)
+		ipamResult, err := invoke.DelegateAdd(context.TODO(), netConf.IPAM.Type, args.StdinData, nil)
 		if err != nil {
 			return err
 		}
+		ipamAdded = true
 
 		// Convert whatever the IPAM result was into the current Result type
 		result, err = current.NewResultFromResult(ipamResult)
 		if err != nil {
-			// TBD: CLEAN-UP
 			return err
 		}
 
 		if len(result.IPs) == 0 {
-			// TBD: CLEAN-UP
 			return fmt.Errorf("ERROR: Unable to get IP Address")
 		}
 
-		// Clear out the Gateway if set by IPAM, not being used.
-		for _, ip := range result.IPs {
-			ip.Gateway = nil
+		// Clear out the Gateway if set by IPAM, unless used by the point-to-point
+		// L3 model of VPP NetType interface: the host end takes the gateway and
+		// the pod routes through it.
+		if netConf.HostConf.Engine != "vpp" || netConf.HostConf.NetType != "interface" {
+			for _, ip := range result.IPs {
+				ip.Gateway = nil
+			}
 		}
 
+	} else {
+		result = &current.Result{CNIVersion: current.ImplementedSpecVersion}
+
+		// Without IPAM, use the IPs requested by the runtime, if any.
+		for _, ipStr := range netConf.RuntimeConfig.IPs {
+			ipAddr, ipNet, err := net.ParseCIDR(ipStr)
+			if err != nil {
+				return cnitypes.NewError(cnitypes.ErrInvalidNetworkConfig, "invalid runtimeConfig ips", err.Error())
+			}
+			ipNet.IP = ipAddr
+			result.IPs = append(result.IPs, &current.IPConfig{Address: *ipNet})
+		}
 	}
 
+	chainedIPs, err := chainPrevResult(netConf, result)
+	if err != nil {
+		return err
+	}
+
+	//
+	// HOST:
+	//
+
+	// Add the requested interface and network
+	if netConf.HostConf.Engine == "vpp" {
+		err = vpp.AddOnHost(netConf, args.ContainerID, result)
+	} else {
+		err = ovs.AddOnHost(netConf, args.ContainerID, result)
+	}
+	if err != nil {
+		return err
+	}
+	hostAdded = true
+
+	// Describe the container side of the connection and attach its IPs to it.
+	addContainerInterface(netConf, args, result)
+
+	//
+	// CONTAINER:
+	//
+
 	// Determine the Engine that will process the request. Default to host
 	// if not provided.
 	if netConf.ContainerConf.Engine != "" {
@@ -131,11 +338,32 @@ func cmdAdd(args *skel.CmdArgs) error {
 	} else if containerEngine == "ovs-dpdk" {
 		err = ovs.AddOnContainer(netConf, args.ContainerID, result)
 	} else {
-		return fmt.Errorf("ERROR: Unknown Container Engine:" + containerEngine)
+		return unknownEngine("Container", containerEngine)
 	}
 	if err != nil {
 		return err
 	}
+	containerAdded = true
+
+	// Optionally hold the pod back until vpp-app connected its end.
+	if netConf.LinkUpTimeout != 0 && containerEngine == "vpp" {
+		timeout := time.Duration(netConf.LinkUpTimeout) * time.Second
+		if err = vpp.WaitOnContainer(netConf, args.ContainerID, timeout); err != nil {
+			return err
+		}
+	}
+
+	if err = setKernelMtu(args.Netns, args.IfName, netConf.Mtu); err != nil {
+		return err
+	}
+
+	// Publish the socket of the container interface for Multus.
+	socketPath := result.Interfaces[len(result.Interfaces)-1].SocketPath
+	if err = netConf.SaveDeviceInfo(netConf.NewDeviceInfo(socketPath)); err != nil {
+		return err
+	}
+
+	result.IPs = append(result.IPs, chainedIPs...)
 
 	return cnitypes.PrintResult(result, netConf.CNIVersion)
 }
@@ -153,6 +381,11 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
+	// The socket and bridge may have been overridden for this pod.
+	if err = netConf.ApplyOverrides(args.Args); err != nil {
+		return cnitypes.NewError(cnitypes.ErrInvalidNetworkConfig, err.Error(), "")
+	}
+
 	//
 	// HOST:
 	//
@@ -163,7 +396,7 @@ func cmdDel(args *skel.CmdArgs) error {
 	} else if netConf.HostConf.Engine == "ovs-dpdk" {
 		err = ovs.DelFromHost(netConf, args.ContainerID)
 	} else {
-		return fmt.Errorf("ERROR: Unknown Host Engine:" + netConf.HostConf.Engine)
+		return unknownEngine("Host", netConf.HostConf.Engine)
 	}
 	if err != nil {
 		return err
@@ -187,17 +420,21 @@ func cmdDel(args *skel.CmdArgs) error {
 	} else if containerEngine == "ovs-dpdk" {
 		err = ovs.DelFromContainer(netConf, args.ContainerID)
 	} else {
-		return fmt.Errorf("ERROR: Unknown Container Engine:" + containerEngine)
+		return unknownEngine("Container", containerEngine)
 	}
 	if err != nil {
 		return err
 	}
 
+	if err = netConf.CleanDeviceInfo(); err != nil {
+		return err
+	}
+
 	//
 	// Cleanup IPAM data, if provided.
 	//
 	if netConf.IPAM.Type != "" {
-		err = ipam.ExecDel(netConf.IPAM.Type, args.StdinData)
+		err = invoke.DelegateDel(context.TODO(), netConf.IPAM.Type, args.StdinData, nil)
 		if err != nil {
 			return err
 		}
@@ -210,13 +447,16 @@ func cmdDel(args *skel.CmdArgs) error {
 		return nil
 	}
 
+	// Remove the link whatever address family it was given.
 	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
-		var err error
-		_, err = ip.DelLinkByNameAddr(args.IfName, netlink.FAMILY_V4)
-		if err != nil && err == ip.ErrLinkNotFound {
-			return nil
+		link, err := netlink.LinkByName(args.IfName)
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok {
+				return nil
+			}
+			return err
 		}
-		return err
+		return netlink.LinkDel(link)
 	})
 
 	if err != nil {
@@ -226,6 +466,75 @@ func cmdDel(args *skel.CmdArgs) error {
 	return nil
 }
 
+// cmdGC() - CNI GC (CNI Spec 1.1). Remove everything created by this network
+//  for containers that are not in the list of valid attachments.
+func cmdGC(args *skel.CmdArgs) error {
+	var liveIDs []string
+
+	vpp := cnivpp.CniVpp{}
+	ovs := cniovs.CniOvs{}
+
+	// Convert the input bytestream into local NetConf structure
+	netConf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	for _, attachment := range netConf.ValidAttachments {
+		liveIDs = append(liveIDs, attachment.ContainerID)
+	}
+
+	// Also collect the per pod socket directories of the network.
+	socketDirGlob, err := netConf.SocketDirGlob()
+	if err != nil {
+		return err
+	}
+	if socketDirGlob != "" {
+		vpp.SocketDirGlobs = []string{socketDirGlob}
+	}
+
+	if netConf.HostConf.Engine == "vpp" {
+		_, err = vpp.GarbageCollect(netConf.Name, liveIDs)
+	} else if netConf.HostConf.Engine == "ovs-dpdk" {
+		_, err = ovs.GarbageCollect(netConf.Name, liveIDs)
+	} else {
+		return unknownEngine("Host", netConf.HostConf.Engine)
+	}
+
+	return err
+}
+
+// cmdStatus() - CNI STATUS (CNI Spec 1.1). Report whether the Host Engine
+//  is reachable and ADD requests can be serviced.
+func cmdStatus(args *skel.CmdArgs) error {
+	vpp := cnivpp.CniVpp{}
+	ovs := cniovs.CniOvs{}
+
+	// Convert the input bytestream into local NetConf structure
+	netConf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if netConf.HostConf.Engine == "vpp" {
+		err = vpp.Status()
+	} else if netConf.HostConf.Engine == "ovs-dpdk" {
+		err = ovs.Status()
+	} else {
+		return unknownEngine("Host", netConf.HostConf.Engine)
+	}
+	if err != nil {
+		return cnitypes.NewError(errPluginNotAvailable, netConf.HostConf.Engine+" not available", err.Error())
+	}
+
+	return nil
+}
+
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel, cniSpecVersion.All)
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:    cmdAdd,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	}, supportedVersions, "CNI userspace plugin")
 }
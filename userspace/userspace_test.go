@@ -0,0 +1,95 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+func TestLoadNetConfUnknownFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"known fields", `{"cniVersion": "1.0.0", "name": "n", "type": "userspace", "strict": true,
+			"host": {"engine": "vpp", "iftype": "memif"}}`, true},
+		{"unknown field, not strict", `{"cniVersion": "1.0.0", "name": "n", "type": "userspace",
+			"host": {"engine": "vpp", "ifType": "memif"}}`, true},
+		{"unknown field, strict", `{"cniVersion": "1.0.0", "name": "n", "type": "userspace", "strict": true,
+			"host": {"engine": "vpp", "ifType": "memif"}}`, false},
+	}
+
+	for _, test := range tests {
+		_, err := loadNetConf([]byte(test.config))
+		if test.valid && err != nil {
+			t.Errorf("%s: loadNetConf() failed: %v", test.name, err)
+		} else if test.valid == false && err == nil {
+			t.Errorf("%s: loadNetConf() accepted the config", test.name)
+		}
+	}
+}
+
+func TestAddContainerInterface(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      usrsptypes.UserSpaceConf
+		container usrsptypes.UserSpaceConf
+		names     []string
+	}{
+		{"vhostuser", usrsptypes.UserSpaceConf{IfType: "vhostuser"}, usrsptypes.UserSpaceConf{}, []string{"net1"}},
+		{"memif", usrsptypes.UserSpaceConf{IfType: "memif"}, usrsptypes.UserSpaceConf{}, []string{"net1"}},
+		{"memif IDs of the host",
+			usrsptypes.UserSpaceConf{IfType: "memif", MemifConf: usrsptypes.MemifConf{Interfaces: []usrsptypes.MemifIfConf{{Id: 0}, {Id: 1}}}},
+			usrsptypes.UserSpaceConf{}, []string{"net1", "net1-1"}},
+		{"memif IDs of the container",
+			usrsptypes.UserSpaceConf{IfType: "memif", MemifConf: usrsptypes.MemifConf{Interfaces: []usrsptypes.MemifIfConf{{Id: 0}, {Id: 1}}}},
+			usrsptypes.UserSpaceConf{MemifConf: usrsptypes.MemifConf{Interfaces: []usrsptypes.MemifIfConf{{Id: 0}, {Id: 1, Name: "data"}}}},
+			[]string{"net1", "data"}},
+		{"vhostuser in the container",
+			usrsptypes.UserSpaceConf{IfType: "memif", MemifConf: usrsptypes.MemifConf{Interfaces: []usrsptypes.MemifIfConf{{Id: 0}, {Id: 1}}}},
+			usrsptypes.UserSpaceConf{IfType: "vhostuser"}, []string{"net1"}},
+	}
+
+	for _, test := range tests {
+		netConf := &usrsptypes.NetConf{HostConf: test.host, ContainerConf: test.container}
+		result := &current.Result{
+			Interfaces: []*current.Interface{{Name: "memif1/0", SocketPath: "/var/run/vpp/cni/shared/memif.sock"}},
+			IPs:        []*current.IPConfig{{}},
+		}
+
+		addContainerInterface(netConf, &skel.CmdArgs{IfName: "net1", Netns: "/var/run/netns/pod"}, result)
+
+		var names []string
+		for _, intf := range result.Interfaces[1:] {
+			names = append(names, intf.Name)
+			if intf.SocketPath != "/var/run/vpp/cni/shared/memif.sock" || intf.Sandbox != "/var/run/netns/pod" {
+				t.Errorf("%s: unexpected interface %+v", test.name, intf)
+			}
+		}
+		if reflect.DeepEqual(names, test.names) == false {
+			t.Errorf("%s: got interfaces %v, want %v", test.name, names, test.names)
+		}
+		if result.IPs[0].Interface == nil || *result.IPs[0].Interface != 1 {
+			t.Errorf("%s: IP not on the first container interface", test.name)
+		}
+	}
+}
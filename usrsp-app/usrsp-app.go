@@ -0,0 +1,240 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// usrsp-app is the container side helper for DPDK applications, such as
+// testpmd or l3fwd, that attach to the UserSpace CNI interfaces without a
+// VPP instance in the container. It reads the remote config written by the
+// host and prints the DPDK EAL arguments that create the matching virtual
+// devices:
+//
+//   testpmd $(usrsp-app) -- -i
+//
+// The devices can also be written as JSON or as a file of shell variables
+// for the application to source. The remote config is left in place, so
+// usrsp-app can be run any number of times. It replaces vpp-app, which
+// removes the config files as it applies them.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+	"github.com/Billy99/user-space-net-plugin/usrsptypes/appconfig"
+)
+
+//
+// Constants
+//
+
+// The remote config may show up a little after the container starts.
+const pollInterval = time.Second
+
+//
+// Functions
+//
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Print the DPDK EAL arguments of the UserSpace CNI interfaces of this container.\n")
+	fmt.Fprintf(os.Stderr, "Options:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	format := flag.String("format", "eal", "Output format {eal|json|env}")
+	output := flag.String("o", "", "Write the output to this file instead of stdout")
+	ifNames := flag.String("ifname", "", "Comma separated list of interfaces to include, default all")
+	opts := appconfig.DefaultOptions()
+	flag.StringVar(&opts.Dir, "dir", opts.Dir, "Directory the remote config is mounted at")
+	flag.StringVar(&opts.AnnotationsFile, "annotations", opts.AnnotationsFile, "Read the remote config from this downward API annotations file")
+	wait := flag.Duration("wait", 0, "Wait up to this long for the interfaces to be present")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var wanted []string
+	if *ifNames != "" {
+		wanted = strings.Split(*ifNames, ",")
+	}
+
+	devices, err := waitDevices(opts, wanted, *wait)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+
+	var out string
+	switch *format {
+	case "eal":
+		out = formatEal(devices)
+	case "json":
+		out, err = formatJson(devices)
+	case "env":
+		out = formatEnv(devices)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err == nil {
+		err = writeOutput(*output, out)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+}
+
+// Read the remote configs until all the wanted interfaces, or at least one
+// if none were named, are present, or the wait is over.
+func waitDevices(opts appconfig.Options, wanted []string, wait time.Duration) ([]usrsptypes.DpdkDevice, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		remotes, err := appconfig.RemoteConfigs(opts)
+		if err == nil {
+			remotes, err = selectRemoteConfigs(remotes, wanted)
+		}
+		if err == nil {
+			return remoteDevices(remotes)
+		}
+
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Return the remote configs of the wanted interfaces, in the order given,
+// or all of them if none are named. An error if any is missing.
+func selectRemoteConfigs(remotes []usrsptypes.RemoteConfig, wanted []string) ([]usrsptypes.RemoteConfig, error) {
+	if len(wanted) == 0 {
+		if len(remotes) == 0 {
+			return nil, fmt.Errorf("no interfaces found")
+		}
+		return remotes, nil
+	}
+
+	var list []usrsptypes.RemoteConfig
+	for _, ifName := range wanted {
+		found := false
+		for _, remote := range remotes {
+			if remote.NetConf.If0name == ifName {
+				list = append(list, remote)
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return nil, fmt.Errorf("interface %s not found", ifName)
+		}
+	}
+	return list, nil
+}
+
+// Return the DPDK devices of all the remote configs, numbered in order.
+func remoteDevices(remotes []usrsptypes.RemoteConfig) ([]usrsptypes.DpdkDevice, error) {
+	var list []usrsptypes.DpdkDevice
+	var memifIndex, vhostIndex int
+
+	for i := range remotes {
+		remote := &remotes[i]
+
+		// Devices of each driver are numbered separately.
+		index := &memifIndex
+		if remote.NetConf.HostConf.IfType == "vhostuser" {
+			index = &vhostIndex
+		}
+
+		devices, err := remote.DpdkDevices(*index)
+		if err != nil {
+			return nil, err
+		}
+		*index += len(devices)
+		list = append(list, devices...)
+	}
+
+	return list, nil
+}
+
+// One --vdev option per device, on a single line for the command line of
+// the application.
+func formatEal(devices []usrsptypes.DpdkDevice) string {
+	var args []string
+
+	for _, dev := range devices {
+		args = append(args, "--vdev="+dev.Vdev)
+	}
+	return strings.Join(args, " ") + "\n"
+}
+
+func formatJson(devices []usrsptypes.DpdkDevice) (string, error) {
+	dataBytes, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(dataBytes) + "\n", nil
+}
+
+// Shell variables, USRSP_EAL_ARGS with all the --vdev options and
+// USRSP_DEV<n>_* with the details of each device.
+func formatEnv(devices []usrsptypes.DpdkDevice) string {
+	var lines []string
+
+	lines = append(lines, "USRSP_EAL_ARGS="+shellQuote(strings.TrimSuffix(formatEal(devices), "\n")))
+	lines = append(lines, fmt.Sprintf("USRSP_DEV_COUNT=%d", len(devices)))
+	for i, dev := range devices {
+		prefix := fmt.Sprintf("USRSP_DEV%d_", i)
+		lines = append(lines, prefix+"NAME="+shellQuote(dev.Name))
+		lines = append(lines, prefix+"IFTYPE="+shellQuote(dev.IfType))
+		lines = append(lines, prefix+"VDEV="+shellQuote(dev.Vdev))
+		lines = append(lines, prefix+"SOCKET="+shellQuote(dev.SocketPath))
+		lines = append(lines, prefix+"MAC="+shellQuote(dev.Mac))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// Write the output to stdout, or replace the file in one step, since the
+// application may be waiting on it.
+func writeOutput(path string, out string) error {
+	if path == "" {
+		_, err := fmt.Print(out)
+		return err
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmpPath, []byte(out), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module delivers the container config as a pod annotation instead of
+// a file in a directory mounted into the container. The host writes the
+// annotation through the Kubernetes API, using the pod name and namespace
+// from CNI_ARGS. The container reads it back from the annotations file
+// provided by the downward API. Only a pod PATCH is needed, so a plain
+// HTTP client is used instead of a full Kubernetes client library.
+//
+
+package usrspk8s
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+//
+// Constants
+//
+
+// Annotations written by the plugin are named AnnotationPrefix + If0name.
+const AnnotationPrefix = "userspace-cni.io/config-"
+
+const (
+	requestTimeout = 10 * time.Second
+	maxErrorBody   = 512
+	mergePatchType = "application/merge-patch+json"
+)
+
+//
+// Types
+//
+
+// Client for the few Kubernetes API calls the plugin makes.
+type Client struct {
+	server     string
+	tokenFile  string
+	httpClient *http.Client
+}
+
+// Returned when the API server rejects a request.
+type StatusError struct {
+	Code int
+	Msg  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ERROR: Kubernetes API returned %d: %s", e.Code, e.Msg)
+}
+
+//
+// API Functions
+//
+
+// NewClient() - Create a Client from the kubernetes section of the config.
+func NewClient(kubeConf usrsptypes.KubernetesConf) (*Client, error) {
+	if kubeConf.ApiServer == "" {
+		return nil, fmt.Errorf("ERROR: kubernetes.apiServer not provided")
+	}
+
+	tlsConfig := &tls.Config{}
+	if kubeConf.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(kubeConf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: Unable to read kubernetes.caFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(caBytes) == false {
+			return nil, fmt.Errorf("ERROR: No certificates found in kubernetes.caFile %s", kubeConf.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &Client{
+		server:    strings.TrimSuffix(kubeConf.ApiServer, "/"),
+		tokenFile: kubeConf.TokenFile,
+		httpClient: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// SetPodAnnotation() - Set an annotation on a pod. A nil value removes
+//  the annotation.
+func (c *Client) SetPodAnnotation(namespace string, podName string, key string, value *string) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{key: value},
+		},
+	}
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s", c.server, url.PathEscape(namespace), url.PathEscape(podName))
+	req, err := http.NewRequest("PATCH", path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mergePatchType)
+	req.Header.Set("Accept", "application/json")
+
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return fmt.Errorf("ERROR: Unable to read kubernetes.tokenFile: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ERROR: Kubernetes API request for pod %s/%s failed: %v", namespace, podName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return &StatusError{Code: resp.StatusCode, Msg: strings.TrimSpace(string(respBody))}
+	}
+
+	return nil
+}
+
+// SaveRemoteConfigAnnotation() - Write the container config as an
+//  annotation on the pod the interface is added to.
+func SaveRemoteConfigAnnotation(conf *usrsptypes.NetConf, ipResult *current.Result, containerID string) error {
+	if conf.PodName == "" || conf.PodNamespace == "" {
+		return fmt.Errorf("ERROR: K8S_POD_NAME and K8S_POD_NAMESPACE are needed to annotate the pod")
+	}
+
+	client, err := NewClient(conf.Kubernetes)
+	if err != nil {
+		return err
+	}
+
+	remote := usrsptypes.NewRemoteConfig(conf, ipResult, containerID)
+	dataBytes, err := json.Marshal(remote)
+	if err != nil {
+		return fmt.Errorf("ERROR: serializing REMOTE NetConf data: %v", err)
+	}
+	value := string(dataBytes)
+
+	return client.SetPodAnnotation(conf.PodNamespace, conf.PodName, AnnotationPrefix+conf.If0name, &value)
+}
+
+// DeleteRemoteConfigAnnotation() - Remove the container config annotation
+//  from the pod. A pod that is already gone is not an error.
+func DeleteRemoteConfigAnnotation(conf *usrsptypes.NetConf) error {
+	if conf.PodName == "" || conf.PodNamespace == "" {
+		return nil
+	}
+
+	client, err := NewClient(conf.Kubernetes)
+	if err != nil {
+		return err
+	}
+
+	err = client.SetPodAnnotation(conf.PodNamespace, conf.PodName, AnnotationPrefix+conf.If0name, nil)
+	if statusErr, ok := err.(*StatusError); ok && statusErr.Code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// ReadRemoteConfigAnnotations() - Read the container configs from the
+//  annotations file of the downward API, keyed by annotation name.
+func ReadRemoteConfigAnnotations(path string) (map[string]usrsptypes.RemoteConfig, error) {
+	annotations, err := ReadDownwardAPIFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]usrsptypes.RemoteConfig)
+	for key, value := range annotations {
+		if strings.HasPrefix(key, AnnotationPrefix) == false {
+			continue
+		}
+
+		var remote usrsptypes.RemoteConfig
+		if err := json.Unmarshal([]byte(value), &remote); err != nil {
+			return nil, fmt.Errorf("failed to parse annotation %s: %v", key, err)
+		}
+		configs[key] = remote
+	}
+
+	return configs, nil
+}
+
+// ReadDownwardAPIFile() - Parse a downward API labels or annotations file,
+//  which holds one key="quoted value" pair per line.
+func ReadDownwardAPIFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("ERROR: Invalid line in %s: %s", path, line)
+		}
+		value, err := strconv.Unquote(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: Invalid value for %s in %s: %v", line[:i], path, err)
+		}
+		values[line[:i]] = value
+	}
+
+	return values, scanner.Err()
+}
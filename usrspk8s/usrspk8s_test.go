@@ -0,0 +1,265 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usrspk8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+// Fake API server holding the annotations of a single pod.
+type fakeAPIServer struct {
+	namespace   string
+	podName     string
+	token       string
+	annotations map[string]string
+	requests    int
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+
+	if r.Method != "PATCH" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != mergePatchType {
+		http.Error(w, "unsupported patch type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", f.namespace, f.podName) {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return
+	}
+
+	var patch struct {
+		Metadata struct {
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for key, value := range patch.Metadata.Annotations {
+		if value == nil {
+			delete(f.annotations, key)
+		} else {
+			f.annotations[key] = *value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+func newFakeAPIServer(t *testing.T) (*fakeAPIServer, *httptest.Server, string) {
+	dir, err := ioutil.TempDir("", "usrspk8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err = ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeAPIServer{
+		namespace:   "default",
+		podName:     "pod-1",
+		token:       "secret",
+		annotations: make(map[string]string),
+	}
+	server := httptest.NewServer(fake)
+
+	t.Cleanup(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	return fake, server, tokenFile
+}
+
+func testNetConf(apiServer string, tokenFile string) *usrsptypes.NetConf {
+	conf := &usrsptypes.NetConf{
+		Name:           "userspace-net",
+		If0name:        "net1",
+		ConfigDelivery: "annotation",
+		Kubernetes: usrsptypes.KubernetesConf{
+			ApiServer: apiServer,
+			TokenFile: tokenFile,
+		},
+		PodName:      "pod-1",
+		PodNamespace: "default",
+	}
+	conf.HostConf.Engine = "vpp"
+	conf.HostConf.IfType = "memif"
+	conf.HostConf.NetType = "bridge"
+	conf.HostConf.MemifConf.Role = "master"
+	conf.HostConf.MemifConf.Mode = "ethernet"
+	return conf
+}
+
+func TestSaveAndDeleteRemoteConfigAnnotation(t *testing.T) {
+	fake, server, tokenFile := newFakeAPIServer(t)
+	conf := testNetConf(server.URL, tokenFile)
+
+	ipResult := &current.Result{CNIVersion: current.ImplementedSpecVersion}
+	if err := SaveRemoteConfigAnnotation(conf, ipResult, "0123456789abcdef"); err != nil {
+		t.Fatalf("SaveRemoteConfigAnnotation() failed: %v", err)
+	}
+
+	value, ok := fake.annotations[AnnotationPrefix+"net1"]
+	if ok == false {
+		t.Fatalf("annotation %s not set, have %v", AnnotationPrefix+"net1", fake.annotations)
+	}
+
+	var remote usrsptypes.RemoteConfig
+	if err := json.Unmarshal([]byte(value), &remote); err != nil {
+		t.Fatalf("annotation is not a RemoteConfig: %v", err)
+	}
+	if remote.ContainerId != "0123456789abcdef" {
+		t.Errorf("ContainerId = %q, want 0123456789abcdef", remote.ContainerId)
+	}
+	if remote.NetConf.HostConf.MemifConf.Role != "slave" {
+		t.Errorf("container memif role = %q, want slave", remote.NetConf.HostConf.MemifConf.Role)
+	}
+	if remote.NetConf.HostConf.MemifConf.Mode != "ethernet" {
+		t.Errorf("container memif mode = %q, want ethernet", remote.NetConf.HostConf.MemifConf.Mode)
+	}
+
+	if err := DeleteRemoteConfigAnnotation(conf); err != nil {
+		t.Fatalf("DeleteRemoteConfigAnnotation() failed: %v", err)
+	}
+	if _, ok := fake.annotations[AnnotationPrefix+"net1"]; ok {
+		t.Errorf("annotation still set after delete")
+	}
+}
+
+func TestDeleteRemoteConfigAnnotationPodGone(t *testing.T) {
+	_, server, tokenFile := newFakeAPIServer(t)
+	conf := testNetConf(server.URL, tokenFile)
+	conf.PodName = "pod-2"
+
+	if err := DeleteRemoteConfigAnnotation(conf); err != nil {
+		t.Errorf("DeleteRemoteConfigAnnotation() of a missing pod failed: %v", err)
+	}
+
+	if err := SaveRemoteConfigAnnotation(conf, nil, "0123456789abcdef"); err == nil {
+		t.Errorf("SaveRemoteConfigAnnotation() of a missing pod succeeded")
+	} else if statusErr, ok := err.(*StatusError); ok == false || statusErr.Code != http.StatusNotFound {
+		t.Errorf("SaveRemoteConfigAnnotation() error = %v, want a 404 StatusError", err)
+	}
+}
+
+func TestSaveRemoteConfigAnnotationErrors(t *testing.T) {
+	fake, server, _ := newFakeAPIServer(t)
+
+	conf := testNetConf(server.URL, "")
+	err := SaveRemoteConfigAnnotation(conf, nil, "0123456789abcdef")
+	if statusErr, ok := err.(*StatusError); ok == false || statusErr.Code != http.StatusUnauthorized {
+		t.Errorf("SaveRemoteConfigAnnotation() without token error = %v, want a 401 StatusError", err)
+	}
+
+	conf = testNetConf(server.URL, "")
+	conf.PodNamespace = ""
+	requests := fake.requests
+	if err := SaveRemoteConfigAnnotation(conf, nil, "0123456789abcdef"); err == nil {
+		t.Errorf("SaveRemoteConfigAnnotation() without a pod namespace succeeded")
+	}
+	if fake.requests != requests {
+		t.Errorf("SaveRemoteConfigAnnotation() without a pod namespace sent a request")
+	}
+
+	conf = testNetConf("", "")
+	if err := SaveRemoteConfigAnnotation(conf, nil, "0123456789abcdef"); err == nil {
+		t.Errorf("SaveRemoteConfigAnnotation() without an API server succeeded")
+	}
+}
+
+func TestReadRemoteConfigAnnotations(t *testing.T) {
+	fake, server, tokenFile := newFakeAPIServer(t)
+	conf := testNetConf(server.URL, tokenFile)
+
+	if err := SaveRemoteConfigAnnotation(conf, nil, "0123456789abcdef"); err != nil {
+		t.Fatalf("SaveRemoteConfigAnnotation() failed: %v", err)
+	}
+
+	// Write the annotations the way the downward API does.
+	fake.annotations["kubernetes.io/config.source"] = "api"
+	var lines []string
+	for key, value := range fake.annotations {
+		lines = append(lines, key+"="+strconv.Quote(value))
+	}
+
+	dir, err := ioutil.TempDir("", "usrspk8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "annotations")
+	if err = ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := ReadRemoteConfigAnnotations(path)
+	if err != nil {
+		t.Fatalf("ReadRemoteConfigAnnotations() failed: %v", err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("ReadRemoteConfigAnnotations() returned %d configs, want 1", len(configs))
+	}
+
+	remote, ok := configs[AnnotationPrefix+"net1"]
+	if ok == false {
+		t.Fatalf("config for net1 not found in %v", configs)
+	}
+	if remote.NetConf.If0name != "net1" || remote.ContainerId != "0123456789abcdef" {
+		t.Errorf("unexpected config read back: %+v", remote)
+	}
+}
+
+func TestReadDownwardAPIFileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usrspk8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "annotations")
+	if err = ioutil.WriteFile(path, []byte("key=unquoted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadDownwardAPIFile(path); err == nil {
+		t.Errorf("ReadDownwardAPIFile() accepted an unquoted value")
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module is the client library for applications in a container to
+// discover the userspace interfaces the UserSpace CNI created for them,
+// without knowing how the container config is delivered. The config is
+// only read, never consumed, so any number of applications can read it.
+// With the default delivery, vpp-app removes the config files as it applies
+// them, so in a pod running vpp-app only the annotation delivery can be
+// read.
+//
+
+package appconfig
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Billy99/user-space-net-plugin/cnivpp/vppdb"
+	"github.com/Billy99/user-space-net-plugin/usrspk8s"
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+//
+// Constants
+//
+
+// Directory the host config is mounted at in the container by default.
+const DefaultDir = "/var/run/vpp/cni/data"
+
+// Set to the downward API annotations file when the config is delivered
+// as pod annotations, as for vpp-app.
+const AnnotationsFileEnv = "USERSPACE_ANNOTATIONS_FILE"
+
+// How often Wait() reads the config again.
+const waitPoll = 200 * time.Millisecond
+
+//
+// Types
+//
+
+// Options controls where the container config is read from.
+type Options struct {
+	Dir             string // Directory the host config is mounted at.
+	AnnotationsFile string // Downward API annotations file, if delivered as pod annotations. Takes precedence over Dir.
+}
+
+// IP address of an interface, as assigned by IPAM.
+type IP struct {
+	Address net.IPNet `json:"address"`
+	Gateway net.IP    `json:"gateway,omitempty"`
+}
+
+// Route through an interface, as returned by IPAM.
+type Route struct {
+	Dst net.IPNet `json:"dst"`
+	GW  net.IP    `json:"gw,omitempty"`
+}
+
+// Container end of a userspace interface. A memif config with several
+// interfaces on the socket file results in one Interface per memif ID.
+type Interface struct {
+	Name        string  `json:"name"`               // Interface name, If0name or the name of the memif interface
+	Network     string  `json:"network"`            // Name of the network from the NetConf
+	ContainerId string  `json:"containerId"`        // ContainerId used in the socket file names
+	IfType      string  `json:"ifType"`             // Type of interface {memif|vhostuser}
+	SocketPath  string  `json:"socketPath"`         // Socket file shared with the host
+	Role        string  `json:"role"`               // memif role {master|slave}, vhost-user mode {client|server}
+	Mode        string  `json:"mode,omitempty"`     // memif mode {ethernet|ip|inject-punt}
+	MemifId     uint32  `json:"memifId,omitempty"`  // memif ID, unique on the socket file
+	RxQueues    int     `json:"rxQueues,omitempty"` // Number of memif receive queues
+	TxQueues    int     `json:"txQueues,omitempty"` // Number of memif transmit queues
+	Mac         string  `json:"mac,omitempty"`      // MAC, if set by the host
+	Mtu         int     `json:"mtu,omitempty"`      // MTU, 0 for the default
+	IPs         []IP    `json:"ips,omitempty"`      // Addresses, on the first interface of a socket file
+	Routes      []Route `json:"routes,omitempty"`   // Routes, on the first interface of a socket file
+}
+
+//
+// API Functions
+//
+
+// DefaultOptions() - Return the options used by List() and Wait(): the
+//  annotations file from USERSPACE_ANNOTATIONS_FILE if set, DefaultDir
+//  otherwise.
+func DefaultOptions() Options {
+	return Options{
+		Dir:             DefaultDir,
+		AnnotationsFile: os.Getenv(AnnotationsFileEnv),
+	}
+}
+
+// List() - Return the interfaces of the container, with DefaultOptions().
+func List() ([]Interface, error) {
+	return ListWithOptions(DefaultOptions())
+}
+
+// ListWithOptions() - Return the interfaces of the container, in a stable
+//  order. No interfaces is not an error, the config may not be there yet.
+func ListWithOptions(opts Options) ([]Interface, error) {
+	var list []Interface
+
+	remotes, err := RemoteConfigs(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range remotes {
+		list = append(list, remoteInterfaces(&remotes[i])...)
+	}
+
+	return list, nil
+}
+
+// Wait() - Wait up to timeout for the named interface, with
+//  DefaultOptions().
+func Wait(name string, timeout time.Duration) (Interface, error) {
+	return WaitWithOptions(DefaultOptions(), name, timeout)
+}
+
+// WaitWithOptions() - Wait up to timeout for the named interface to be
+//  present and return it. Errors reading the config are retried, since it
+//  may be read while being written, and the last one is returned on
+//  timeout.
+func WaitWithOptions(opts Options, name string, timeout time.Duration) (Interface, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		list, err := ListWithOptions(opts)
+		if err == nil {
+			for _, intf := range list {
+				if intf.Name == name {
+					return intf, nil
+				}
+			}
+			err = fmt.Errorf("ERROR: Interface %s not found", name)
+		}
+
+		if time.Now().After(deadline) {
+			return Interface{}, err
+		}
+		time.Sleep(waitPoll)
+	}
+}
+
+// RemoteConfigs() - Return the container configs as written by the host,
+//  in a stable order, for callers that need more than List() returns.
+func RemoteConfigs(opts Options) ([]usrsptypes.RemoteConfig, error) {
+	if opts.AnnotationsFile == "" {
+		return vppdb.ReadRemoteConfigs(opts.Dir)
+	}
+
+	configs, err := usrspk8s.ReadRemoteConfigAnnotations(opts.AnnotationsFile)
+	if err != nil {
+		// The kubelet may not have written the file yet.
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var list []usrsptypes.RemoteConfig
+	for _, key := range keys {
+		list = append(list, configs[key])
+	}
+	return list, nil
+}
+
+//
+// Local Functions
+//
+
+// Return the interfaces of a remote config, which holds the container end
+// in HostConf.
+func remoteInterfaces(remote *usrsptypes.RemoteConfig) []Interface {
+	var list []Interface
+
+	conf := &remote.NetConf
+	usConf := &conf.HostConf
+
+	base := Interface{
+		Name:        conf.If0name,
+		Network:     conf.Name,
+		ContainerId: remote.ContainerId,
+		IfType:      usConf.IfType,
+		SocketPath:  conf.RuntimeConfig.SocketPath,
+		Mac:         usConf.Mac,
+		Mtu:         conf.Mtu,
+	}
+
+	for _, ipConf := range remote.IPResult.IPs {
+		base.IPs = append(base.IPs, IP{Address: ipConf.Address, Gateway: ipConf.Gateway})
+	}
+	for _, route := range remote.IPResult.Routes {
+		base.Routes = append(base.Routes, Route{Dst: route.Dst, GW: route.GW})
+	}
+
+	if usConf.IfType != "memif" {
+		base.Role = usConf.VhostConf.Mode
+		return append(list, base)
+	}
+
+	// The MAC and addresses go on the first interface, as on VPP.
+	for i, memifConf := range usConf.MemifConf.InterfaceList(conf.If0name) {
+		intf := base
+		if i != 0 {
+			intf.Mac = ""
+			intf.IPs = nil
+			intf.Routes = nil
+		}
+
+		intf.Name = memifConf.Name
+		intf.Role = usConf.MemifConf.Role
+		intf.Mode = memifConf.Mode
+		intf.MemifId = memifConf.Id
+		intf.RxQueues = memifConf.RxQueues
+		intf.TxQueues = memifConf.TxQueues
+
+		list = append(list, intf)
+	}
+
+	return list
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+const testContainerID = "0123456789abcdef0123456789abcdef"
+
+func testRemoteConfig() usrsptypes.RemoteConfig {
+	conf := usrsptypes.NetConf{Name: "userspace-vpp-net", If0name: "net1", Mtu: 9000}
+	conf.HostConf.IfType = "memif"
+	conf.HostConf.Mac = "02:fe:00:00:00:01"
+	conf.HostConf.MemifConf.Role = "slave"
+	conf.HostConf.MemifConf.Interfaces = []usrsptypes.MemifIfConf{{Id: 0}, {Id: 3, TxQueues: 2}}
+	conf.RuntimeConfig.SocketPath = "/var/run/vpp/cni/shared/memif-net1.sock"
+
+	return usrsptypes.RemoteConfig{
+		NetConf:     conf,
+		ContainerId: testContainerID,
+		IPResult: current.Result{IPs: []*current.IPConfig{{
+			Address: net.IPNet{IP: net.ParseIP("10.1.1.5").To4(), Mask: net.CIDRMask(24, 32)},
+			Gateway: net.ParseIP("10.1.1.1").To4(),
+		}}},
+	}
+}
+
+// Write the remote config as the host does with the default delivery.
+func writeRemoteConfig(t *testing.T, dir string, remote usrsptypes.RemoteConfig) {
+	dataBytes, _ := json.Marshal(remote.NetConf)
+	if err := ioutil.WriteFile(filepath.Join(dir, "remote-net1.json"), dataBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dataBytes, _ = json.Marshal(remote)
+	if err := ioutil.WriteFile(filepath.Join(dir, "addData-net1.json"), dataBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkInterfaces(t *testing.T, list []Interface) {
+	if len(list) != 2 {
+		t.Fatalf("got %d interfaces, want 2", len(list))
+	}
+
+	first, second := list[0], list[1]
+	if first.Name != "net1" || first.Network != "userspace-vpp-net" || first.ContainerId != testContainerID ||
+		first.IfType != "memif" || first.Role != "slave" || first.Mode != "ethernet" || first.MemifId != 0 ||
+		first.Mac != "02:fe:00:00:00:01" || first.Mtu != 9000 || first.SocketPath != "/var/run/vpp/cni/shared/memif-net1.sock" {
+		t.Errorf("unexpected first interface %+v", first)
+	}
+	if len(first.IPs) != 1 || first.IPs[0].Address.String() != "10.1.1.5/24" || first.IPs[0].Gateway.String() != "10.1.1.1" {
+		t.Errorf("unexpected addresses %+v", first.IPs)
+	}
+
+	// Same socket file, but without the MAC and addresses.
+	if second.Name != "net1-3" || second.MemifId != 3 || second.RxQueues != 1 || second.TxQueues != 2 ||
+		second.SocketPath != first.SocketPath || second.Mac != "" || len(second.IPs) != 0 {
+		t.Errorf("unexpected second interface %+v", second)
+	}
+}
+
+func TestListFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Options{Dir: dir}
+
+	// Nothing delivered yet.
+	if list, err := ListWithOptions(opts); err != nil || len(list) != 0 {
+		t.Errorf("ListWithOptions() = %v, %v, want no interfaces", list, err)
+	}
+
+	writeRemoteConfig(t, dir, testRemoteConfig())
+
+	list, err := ListWithOptions(opts)
+	if err != nil {
+		t.Fatalf("ListWithOptions() failed: %v", err)
+	}
+	checkInterfaces(t, list)
+
+	// Read only, the config is still there for the next reader.
+	if list, err = ListWithOptions(opts); err != nil || len(list) != 2 {
+		t.Errorf("second ListWithOptions() = %v, %v, want 2 interfaces", list, err)
+	}
+}
+
+func TestListAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "annotations")
+	opts := Options{AnnotationsFile: path}
+
+	// The kubelet did not write the file yet.
+	if list, err := ListWithOptions(opts); err != nil || len(list) != 0 {
+		t.Errorf("ListWithOptions() = %v, %v, want no interfaces", list, err)
+	}
+
+	dataBytes, _ := json.Marshal(testRemoteConfig())
+	annotations := fmt.Sprintf("kubernetes.io/config.seen=%q\nuserspace-cni.io/config-net1=%s\n",
+		"2018-06-01T00:00:00Z", strconv.Quote(string(dataBytes)))
+	if err = ioutil.WriteFile(path, []byte(annotations), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ListWithOptions(opts)
+	if err != nil {
+		t.Fatalf("ListWithOptions() failed: %v", err)
+	}
+	checkInterfaces(t, list)
+}
+
+func TestWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Options{Dir: dir}
+
+	if _, err = WaitWithOptions(opts, "net1", 2*waitPoll); err == nil {
+		t.Errorf("WaitWithOptions() succeeded without a config")
+	}
+
+	// The config shows up while waiting.
+	go func() {
+		time.Sleep(waitPoll)
+		remote := testRemoteConfig()
+		dataBytes, _ := json.Marshal(remote)
+		ioutil.WriteFile(filepath.Join(dir, "addData-net1.json"), dataBytes, 0644)
+		dataBytes, _ = json.Marshal(remote.NetConf)
+		ioutil.WriteFile(filepath.Join(dir, ".remote-net1.json"), dataBytes, 0644)
+		os.Rename(filepath.Join(dir, ".remote-net1.json"), filepath.Join(dir, "remote-net1.json"))
+	}()
+
+	intf, err := WaitWithOptions(opts, "net1-3", time.Minute)
+	if err != nil {
+		t.Fatalf("WaitWithOptions() failed: %v", err)
+	}
+	if intf.MemifId != 3 {
+		t.Errorf("got interface %+v, want memif ID 3", intf)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module writes the device-info of the container interface, as
+// defined by the Kubernetes Network Plumbing Working Group Device Info
+// Specification. Multus passes the file name in runtimeConfig and copies
+// the device-info into the pod's network-status annotation, so apps in
+// the pod can find their memif or vhost-user socket.
+//
+
+package usrsptypes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//
+// Constants
+//
+
+// Version of the Device Info Specification written.
+const deviceInfoVersion = "1.1.0"
+
+const (
+	deviceInfoTypeVhostUser = "vhost-user"
+	deviceInfoTypeMemif     = "memif"
+)
+
+// The spec names some memif values differently than the config.
+var deviceInfoMemifValues = map[string]string{
+	"master":      "primary",
+	"slave":       "secondary",
+	"inject-punt": "punt",
+}
+
+//
+// Exported Types
+//
+
+// Device-info of the container interface. Modes and roles are those of the
+// interface in the pod.
+type DeviceInfo struct {
+	Type      string           `json:"type"`
+	Version   string           `json:"version"`
+	VhostUser *VhostDeviceInfo `json:"vhost-user,omitempty"`
+	Memif     *MemifDeviceInfo `json:"memif,omitempty"`
+}
+
+type VhostDeviceInfo struct {
+	Mode string `json:"mode"` // client or server
+	Path string `json:"path"` // Socket file
+}
+
+type MemifDeviceInfo struct {
+	Role string `json:"role"` // primary or secondary
+	Path string `json:"path"` // Socket file
+	Mode string `json:"mode"` // ethernet, ip or punt
+}
+
+//
+// API Functions
+//
+
+// NewDeviceInfo() - Build the device-info of the container interface,
+//  which shares the socket at socketPath with the host interface. Returns
+//  nil for interface types without a device-info type.
+func (conf *NetConf) NewDeviceInfo(socketPath string) *DeviceInfo {
+	// The container's values, with the defaults taken from the host.
+	container := NewRemoteConfig(conf, nil, "").NetConf.HostConf
+
+	if container.IfType == "memif" {
+		// The spec has a single mode, so give that of the first interface.
+		mode := container.MemifConf.InterfaceList("")[0].Mode
+		return &DeviceInfo{
+			Type:    deviceInfoTypeMemif,
+			Version: deviceInfoVersion,
+			Memif: &MemifDeviceInfo{
+				Role: deviceInfoMemifValue(container.MemifConf.Role),
+				Path: socketPath,
+				Mode: deviceInfoMemifValue(mode),
+			},
+		}
+	} else if container.IfType == "vhostuser" {
+		return &DeviceInfo{
+			Type:    deviceInfoTypeVhostUser,
+			Version: deviceInfoVersion,
+			VhostUser: &VhostDeviceInfo{
+				Mode: container.VhostConf.Mode,
+				Path: socketPath,
+			},
+		}
+	}
+
+	return nil
+}
+
+// SaveDeviceInfo() - Write the device-info to the file passed in
+//  runtimeConfig CNIDeviceInfoFile, if any.
+func (conf *NetConf) SaveDeviceInfo(info *DeviceInfo) error {
+	path := conf.RuntimeConfig.CNIDeviceInfoFile
+	if path == "" || info == nil {
+		return nil
+	}
+
+	dataBytes, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("ERROR: serializing device-info: %v", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, dataBytes, 0644)
+}
+
+// CleanDeviceInfo() - Remove the file written by SaveDeviceInfo(), if any.
+func (conf *NetConf) CleanDeviceInfo() error {
+	path := conf.RuntimeConfig.CNIDeviceInfoFile
+	if path == "" {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	return nil
+}
+
+//
+// Local Functions
+//
+
+// Return the spec name of a memif role or mode.
+func deviceInfoMemifValue(value string) string {
+	if specValue, ok := deviceInfoMemifValues[value]; ok {
+		return specValue
+	}
+	return value
+}
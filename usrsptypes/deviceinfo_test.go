@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usrsptypes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveDeviceInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deviceinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memifPunt := validConf()
+	memifPunt.HostConf.MemifConf.Role = "slave"
+	memifPunt.HostConf.MemifConf.Mode = "inject-punt"
+
+	tests := []struct {
+		name string
+		conf *NetConf
+		json string
+	}{
+		{"memif", validConf(),
+			`{"type":"memif","version":"1.1.0","memif":{"role":"secondary","path":"/run/net1.sock","mode":"ethernet"}}`},
+		{"memif punt", memifPunt,
+			`{"type":"memif","version":"1.1.0","memif":{"role":"primary","path":"/run/net1.sock","mode":"punt"}}`},
+		{"vhost-user", validOvsConf(),
+			`{"type":"vhost-user","version":"1.1.0","vhost-user":{"mode":"server","path":"/run/net1.sock"}}`},
+	}
+
+	for _, test := range tests {
+		test.conf.RuntimeConfig.CNIDeviceInfoFile = filepath.Join(dir, "device-info", test.name+".json")
+
+		if err = test.conf.SaveDeviceInfo(test.conf.NewDeviceInfo("/run/net1.sock")); err != nil {
+			t.Errorf("%s: SaveDeviceInfo() failed: %v", test.name, err)
+			continue
+		}
+
+		dataBytes, err := ioutil.ReadFile(test.conf.RuntimeConfig.CNIDeviceInfoFile)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if string(dataBytes) != test.json {
+			t.Errorf("%s: got %s, want %s", test.name, dataBytes, test.json)
+		}
+
+		if err = test.conf.CleanDeviceInfo(); err != nil {
+			t.Errorf("%s: CleanDeviceInfo() failed: %v", test.name, err)
+		} else if _, err = os.Stat(test.conf.RuntimeConfig.CNIDeviceInfoFile); os.IsNotExist(err) == false {
+			t.Errorf("%s: device-info not removed", test.name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module converts a remote config into the DPDK virtual devices that
+// connect a DPDK application in the container, such as testpmd or l3fwd,
+// to the host end of the interface, instead of a VPP instance.
+//
+
+package usrsptypes
+
+import (
+	"fmt"
+)
+
+//
+// Types
+//
+
+// DPDK virtual device for one interface of a remote config.
+type DpdkDevice struct {
+	Name       string `json:"name"`               // Interface name, If0name or the name of the memif interface
+	IfType     string `json:"ifType"`             // Type of interface {memif|vhostuser}
+	Vdev       string `json:"vdev"`               // Value of the EAL --vdev option
+	SocketPath string `json:"socketPath"`         // Socket file shared with the host
+	Mac        string `json:"mac,omitempty"`      // MAC of the interface, if set by the host
+	RxQueues   int    `json:"rxQueues,omitempty"` // Number of receive queues of a memif
+	TxQueues   int    `json:"txQueues,omitempty"` // Number of transmit queues of a memif
+}
+
+//
+// API Functions
+//
+
+// DpdkDevices() - Return the DPDK virtual devices of the interfaces of the
+//  remote config. DPDK requires a unique name per device, so devices are
+//  numbered from index, one per memif interface.
+func (remote *RemoteConfig) DpdkDevices(index int) ([]DpdkDevice, error) {
+	var list []DpdkDevice
+
+	// The remote config holds the Container end in HostConf.
+	conf := &remote.NetConf
+	usConf := &conf.HostConf
+
+	socketPath := conf.RuntimeConfig.SocketPath
+	if socketPath == "" {
+		return nil, fmt.Errorf("ERROR: No socket file for %s", conf.If0name)
+	}
+
+	if usConf.IfType == "memif" {
+		for i, intf := range usConf.MemifConf.InterfaceList(conf.If0name) {
+			// The memif PMD only implements ethernet mode.
+			if intf.Mode != "ethernet" {
+				return nil, fmt.Errorf("ERROR: memif mode %s of %s not supported by DPDK", intf.Mode, intf.Name)
+			}
+
+			dev := DpdkDevice{
+				Name:       intf.Name,
+				IfType:     usConf.IfType,
+				Vdev:       fmt.Sprintf("net_memif%d,role=%s,id=%d,socket=%s", index, usConf.MemifConf.Role, intf.Id, socketPath),
+				SocketPath: socketPath,
+				RxQueues:   intf.RxQueues,
+				TxQueues:   intf.TxQueues,
+			}
+
+			// The MAC applies to the first interface, as on VPP.
+			if i == 0 && usConf.Mac != "" {
+				dev.Mac = usConf.Mac
+				dev.Vdev += ",mac=" + usConf.Mac
+			}
+
+			list = append(list, dev)
+			index++
+		}
+	} else if usConf.IfType == "vhostuser" {
+		dev := DpdkDevice{
+			Name:       conf.If0name,
+			IfType:     usConf.IfType,
+			Vdev:       fmt.Sprintf("virtio_user%d,path=%s", index, socketPath),
+			SocketPath: socketPath,
+			Mac:        usConf.Mac,
+		}
+
+		// virtio_user connects to the socket unless it is the server.
+		if usConf.VhostConf.Mode == "server" {
+			dev.Vdev += ",server=1"
+		}
+		if usConf.Mac != "" {
+			dev.Vdev += ",mac=" + usConf.Mac
+		}
+
+		list = append(list, dev)
+	} else {
+		return nil, fmt.Errorf("ERROR: Unknown IfType:%s of %s", usConf.IfType, conf.If0name)
+	}
+
+	return list, nil
+}
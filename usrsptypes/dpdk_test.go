@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usrsptypes
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Remote config of the container end, which is in HostConf.
+func dpdkRemoteConfig(ifType string) *RemoteConfig {
+	remote := &RemoteConfig{ContainerId: testContainerID}
+	remote.NetConf.If0name = "net1"
+	remote.NetConf.HostConf.IfType = ifType
+	remote.NetConf.RuntimeConfig.SocketPath = "/run/net1.sock"
+	return remote
+}
+
+func TestDpdkDevices(t *testing.T) {
+	memifs := dpdkRemoteConfig("memif")
+	memifs.NetConf.HostConf.Mac = "02:00:00:00:00:02"
+	memifs.NetConf.HostConf.MemifConf.Role = "slave"
+	memifs.NetConf.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 0, RxQueues: 2}, {Id: 3}}
+
+	vhostClient := dpdkRemoteConfig("vhostuser")
+	vhostClient.NetConf.HostConf.VhostConf.Mode = "client"
+
+	vhostServer := dpdkRemoteConfig("vhostuser")
+	vhostServer.NetConf.HostConf.VhostConf.Mode = "server"
+	vhostServer.NetConf.HostConf.Mac = "02:00:00:00:00:02"
+
+	tests := []struct {
+		name    string
+		remote  *RemoteConfig
+		index   int
+		devices []DpdkDevice
+	}{
+		{"memif", memifs, 1, []DpdkDevice{
+			{Name: "net1", IfType: "memif", SocketPath: "/run/net1.sock", Mac: "02:00:00:00:00:02", RxQueues: 2, TxQueues: 1,
+				Vdev: "net_memif1,role=slave,id=0,socket=/run/net1.sock,mac=02:00:00:00:00:02"},
+			{Name: "net1-3", IfType: "memif", SocketPath: "/run/net1.sock", RxQueues: 1, TxQueues: 1,
+				Vdev: "net_memif2,role=slave,id=3,socket=/run/net1.sock"},
+		}},
+		{"vhost-user client", vhostClient, 0, []DpdkDevice{
+			{Name: "net1", IfType: "vhostuser", SocketPath: "/run/net1.sock",
+				Vdev: "virtio_user0,path=/run/net1.sock"},
+		}},
+		{"vhost-user server", vhostServer, 2, []DpdkDevice{
+			{Name: "net1", IfType: "vhostuser", SocketPath: "/run/net1.sock", Mac: "02:00:00:00:00:02",
+				Vdev: "virtio_user2,path=/run/net1.sock,server=1,mac=02:00:00:00:00:02"},
+		}},
+	}
+
+	for _, test := range tests {
+		devices, err := test.remote.DpdkDevices(test.index)
+		if err != nil {
+			t.Errorf("%s: DpdkDevices() failed: %v", test.name, err)
+		} else if reflect.DeepEqual(devices, test.devices) == false {
+			t.Errorf("%s: got %+v, want %+v", test.name, devices, test.devices)
+		}
+	}
+}
+
+func TestDpdkDevicesRejected(t *testing.T) {
+	noSocket := dpdkRemoteConfig("memif")
+	noSocket.NetConf.RuntimeConfig.SocketPath = ""
+
+	ipMode := dpdkRemoteConfig("memif")
+	ipMode.NetConf.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 0}, {Id: 1, Mode: "ip"}}
+
+	tests := []struct {
+		name   string
+		remote *RemoteConfig
+	}{
+		{"no socket", noSocket},
+		{"memif mode not supported", ipMode},
+		{"unknown iftype", dpdkRemoteConfig("tap")},
+	}
+
+	for _, test := range tests {
+		if devices, err := test.remote.DpdkDevices(0); err == nil {
+			t.Errorf("%s: got %+v, want an error", test.name, devices)
+		}
+	}
+}
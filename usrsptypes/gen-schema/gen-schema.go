@@ -0,0 +1,47 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// gen-schema writes the JSON Schema of the userspace NetConf to the given
+// file, or to stdout if no file is given.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Billy99/user-space-net-plugin/usrsptypes"
+)
+
+func main() {
+	data, err := usrsptypes.JSONSchema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: Unable to build schema:", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if len(os.Args) < 2 {
+		os.Stdout.Write(data)
+		return
+	}
+
+	if err = ioutil.WriteFile(os.Args[1], data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module picks the MACs of the host and container interfaces from the
+// macPolicy of the NetConf, so ARP caches and L2 ACLs can rely on MACs that
+// don't change each time a pod or the Engine restarts.
+//
+
+package usrsptypes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net"
+)
+
+//
+// Constants
+//
+
+// Values of macPolicy. The default leaves MACs that are not provided to the
+// Engine.
+const (
+	MacPolicyRandom   = "random"   // New locally administered MAC on each ADD.
+	MacPolicyDerived  = "derived"  // Hash of the ContainerId, If0name and side.
+	MacPolicyExplicit = "explicit" // host.mac and container.mac must be provided.
+	MacPolicyRuntime  = "runtime"  // Container MAC must come from runtimeConfig or CNI_ARGS mac.
+)
+
+var macPolicies = []string{"", MacPolicyRandom, MacPolicyDerived, MacPolicyExplicit, MacPolicyRuntime}
+
+// Engines that can set the MAC of the host interface.
+var hostMacEngines = []string{"vpp"}
+
+//
+// API Functions
+//
+
+// ResolveMacs() - Fill in the MACs of the host and container interfaces
+//  that were not provided, as set by macPolicy. A MAC from runtimeConfig or
+//  CNI_ARGS, already applied by ApplyOverrides(), or from the config is
+//  always kept. The host MAC is only filled in for an Engine that can set
+//  it, so on ovs-dpdk the policy applies to the container end. Call after
+//  Validate(), which checks the explicit and runtime policies have the
+//  MACs they need.
+func (conf *NetConf) ResolveMacs(containerID string) error {
+	var err error
+
+	if conf.MacPolicy != MacPolicyRandom && conf.MacPolicy != MacPolicyDerived {
+		return nil
+	}
+
+	if conf.HostConf.Mac == "" && contains(hostMacEngines, conf.HostConf.Engine) {
+		if conf.HostConf.Mac, err = conf.newMac(containerID, "host"); err != nil {
+			return err
+		}
+	}
+	if conf.ContainerConf.Mac == "" {
+		if conf.ContainerConf.Mac, err = conf.newMac(containerID, "container"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeriveMac() - Return the MAC derived from a ContainerId, interface name
+//  and side ("host" or "container"). The same input always gives the same
+//  locally administered unicast MAC.
+func DeriveMac(containerID string, ifName string, side string) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(containerID + "/" + ifName + "/" + side))
+	return toLocalUnicast(sum[:6])
+}
+
+// RandomMac() - Return a random locally administered unicast MAC.
+func RandomMac() (net.HardwareAddr, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("ERROR: Unable to generate MAC: %v", err)
+	}
+	return toLocalUnicast(buf), nil
+}
+
+//
+// Local Functions
+//
+
+func (conf *NetConf) newMac(containerID string, side string) (string, error) {
+	if conf.MacPolicy == MacPolicyDerived {
+		return DeriveMac(containerID, conf.If0name, side).String(), nil
+	}
+
+	mac, err := RandomMac()
+	if err != nil {
+		return "", err
+	}
+	return mac.String(), nil
+}
+
+// Set the locally administered bit and clear the multicast bit.
+func toLocalUnicast(buf []byte) net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, buf)
+	mac[0] = (mac[0] | 0x02) & 0xfe
+	return mac
+}
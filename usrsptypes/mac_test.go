@@ -0,0 +1,94 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usrsptypes
+
+import (
+	"net"
+	"testing"
+)
+
+// Check mac is a locally administered unicast MAC.
+func checkLocalUnicast(t *testing.T, name string, mac string) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		t.Errorf("%s: invalid MAC %q: %v", name, mac, err)
+	} else if hwAddr[0]&0x01 != 0 || hwAddr[0]&0x02 == 0 {
+		t.Errorf("%s: %s is not a locally administered unicast MAC", name, mac)
+	}
+}
+
+func TestResolveMacs(t *testing.T) {
+	tests := []struct {
+		policy    string
+		ovs       bool
+		host      bool // Host MAC chosen
+		container bool // Container MAC chosen
+	}{
+		{"", false, false, false},
+		{MacPolicyRandom, false, true, true},
+		{MacPolicyDerived, false, true, true},
+		{MacPolicyRuntime, false, false, false},
+		// ovs-dpdk can't set the host MAC.
+		{MacPolicyRandom, true, false, true},
+		{MacPolicyDerived, true, false, true},
+	}
+
+	for _, test := range tests {
+		conf := validConf()
+		if test.ovs {
+			conf = validOvsConf()
+		}
+		conf.MacPolicy = test.policy
+
+		if err := conf.ResolveMacs(testContainerID); err != nil {
+			t.Errorf("%q: ResolveMacs() failed: %v", test.policy, err)
+			continue
+		}
+
+		if test.host {
+			checkLocalUnicast(t, test.policy, conf.HostConf.Mac)
+		} else if conf.HostConf.Mac != "" {
+			t.Errorf("%q: got host MAC %s, want none", test.policy, conf.HostConf.Mac)
+		}
+		if test.container {
+			checkLocalUnicast(t, test.policy, conf.ContainerConf.Mac)
+		} else if conf.ContainerConf.Mac != "" {
+			t.Errorf("%q: got container MAC %s, want none", test.policy, conf.ContainerConf.Mac)
+		}
+	}
+}
+
+// Provided MACs are kept, derived ones are stable.
+func TestResolveMacsDerived(t *testing.T) {
+	conf := validConf()
+	conf.MacPolicy = MacPolicyDerived
+	conf.ContainerConf.Mac = "02:00:00:00:00:02"
+
+	if err := conf.ResolveMacs(testContainerID); err != nil {
+		t.Fatalf("ResolveMacs() failed: %v", err)
+	}
+	if conf.ContainerConf.Mac != "02:00:00:00:00:02" {
+		t.Errorf("provided container MAC replaced with %s", conf.ContainerConf.Mac)
+	}
+
+	want := DeriveMac(testContainerID, "net1", "host").String()
+	if conf.HostConf.Mac != want {
+		t.Errorf("got host MAC %s, want %s", conf.HostConf.Mac, want)
+	}
+	if DeriveMac(testContainerID, "net1", "container").String() == want ||
+		DeriveMac(testContainerID, "net2", "host").String() == want {
+		t.Errorf("derived MAC %s not unique to the interface and side", want)
+	}
+}
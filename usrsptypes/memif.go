@@ -0,0 +1,94 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module expands the memif config into the list of memif interfaces
+// sharing the socket file, so the host and the container create the same
+// set of memif IDs.
+//
+
+package usrsptypes
+
+import (
+	"fmt"
+)
+
+//
+// Constants
+//
+
+const (
+	defaultMemifMode   = "ethernet"
+	defaultMemifQueues = 1
+	maxMemifQueues     = 255 // Queue counts are 8 bits in the VPP API.
+)
+
+//
+// API Functions
+//
+
+// InterfaceList() - Return the memif interfaces to create on the socket
+//  file, with the defaults filled in. Without a list of interfaces, a
+//  single interface with memif ID 0 is returned. The first interface is
+//  named ifName, the others <ifName>-<id> unless given a name.
+func (memifConf *MemifConf) InterfaceList(ifName string) []MemifIfConf {
+	list := memifConf.Interfaces
+	if len(list) == 0 {
+		list = []MemifIfConf{{Id: 0}}
+	}
+
+	mode := memifConf.Mode
+	if mode == "" {
+		mode = defaultMemifMode
+	}
+
+	result := make([]MemifIfConf, len(list))
+	for i, intf := range list {
+		if intf.Name == "" {
+			if i == 0 {
+				intf.Name = ifName
+			} else {
+				intf.Name = fmt.Sprintf("%s-%d", ifName, intf.Id)
+			}
+		}
+		if intf.Mode == "" {
+			intf.Mode = mode
+		}
+		if intf.RxQueues == 0 {
+			intf.RxQueues = defaultMemifQueues
+		}
+		if intf.TxQueues == 0 {
+			intf.TxQueues = defaultMemifQueues
+		}
+		result[i] = intf
+	}
+
+	return result
+}
+
+//
+// Local Functions
+//
+
+// Return the memif interfaces of the other end of the connection: the same
+// memif IDs, with the receive and transmit queues swapped.
+func mirrorMemifInterfaces(list []MemifIfConf) []MemifIfConf {
+	var mirror []MemifIfConf
+
+	for _, intf := range list {
+		intf.RxQueues, intf.TxQueues = intf.TxQueues, intf.RxQueues
+		mirror = append(mirror, intf)
+	}
+	return mirror
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module applies the per pod overrides passed in CNI_ARGS and
+// runtimeConfig on top of the static NetConf, so one network definition
+// can serve pods that need different roles, bridges or sockets.
+//
+
+package usrsptypes
+
+import (
+	"strconv"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+//
+// Exported Types
+//
+
+// Keys accepted in CNI_ARGS. Unknown keys are ignored, the runtime passes
+// the same CNI_ARGS to every plugin in a chain.
+type UserSpaceArgs struct {
+	types.CommonArgs
+	K8S_POD_NAME               types.UnmarshallableString
+	K8S_POD_NAMESPACE          types.UnmarshallableString
+	K8S_POD_INFRA_CONTAINER_ID types.UnmarshallableString
+	K8S_POD_UID                types.UnmarshallableString
+
+	MEMIF_ROLE  types.UnmarshallableString // Role of the memif in the pod, the host takes the other role
+	MEMIF_MODE  types.UnmarshallableString // Mode of the memif on both sides
+	VHOST_MODE  types.UnmarshallableString // vhost-user mode in the pod, the host takes the other mode
+	BRIDGE_ID   types.UnmarshallableString // Same as runtimeConfig bridgeId
+	SOCKET_PATH types.UnmarshallableString // Same as runtimeConfig socketPath
+	MAC         types.UnmarshallableString // Same as runtimeConfig mac
+}
+
+//
+// API Functions
+//
+
+// ApplyOverrides() - Apply the CNI_ARGS string and the runtimeConfig
+//  already loaded into conf on top of the static config. runtimeConfig
+//  takes precedence over CNI_ARGS. Call before Validate(), which checks
+//  the result.
+func (conf *NetConf) ApplyOverrides(cniArgs string) error {
+	args := UserSpaceArgs{}
+	args.IgnoreUnknown = true
+
+	if err := types.LoadArgs(cniArgs, &args); err != nil {
+		return err
+	}
+
+	conf.PodName = string(args.K8S_POD_NAME)
+	conf.PodNamespace = string(args.K8S_POD_NAMESPACE)
+	conf.PodUID = string(args.K8S_POD_UID)
+
+	if role := string(args.MEMIF_ROLE); role != "" {
+		conf.ContainerConf.MemifConf.Role = role
+		conf.HostConf.MemifConf.Role = oppositeOf(role, "master", "slave")
+	}
+	if mode := string(args.MEMIF_MODE); mode != "" {
+		conf.HostConf.MemifConf.Mode = mode
+		conf.ContainerConf.MemifConf.Mode = mode
+	}
+	if mode := string(args.VHOST_MODE); mode != "" {
+		conf.ContainerConf.VhostConf.Mode = mode
+		conf.HostConf.VhostConf.Mode = oppositeOf(mode, "client", "server")
+	}
+
+	// CNI_ARGS only fill in what runtimeConfig didn't provide.
+	rc := &conf.RuntimeConfig
+	if bridgeId := string(args.BRIDGE_ID); bridgeId != "" && rc.BridgeId == 0 {
+		id, err := strconv.Atoi(bridgeId)
+		if err != nil {
+			v := &ValidationError{}
+			v.add("CNI_ARGS.BRIDGE_ID", bridgeId, "must be an integer")
+			return v
+		}
+		rc.BridgeId = id
+	}
+	if rc.SocketPath == "" {
+		rc.SocketPath = string(args.SOCKET_PATH)
+	}
+	if rc.Mac == "" {
+		rc.Mac = string(args.MAC)
+	}
+
+	if rc.BridgeId != 0 {
+		conf.HostConf.BridgeConf.BridgeId = rc.BridgeId
+	}
+	if rc.Mac != "" {
+		conf.ContainerConf.Mac = rc.Mac
+	}
+
+	return nil
+}
+
+//
+// Local Functions
+//
+
+// Return the other value of a two value setting. An unknown value is
+// returned as is and reported by Validate().
+func oppositeOf(value string, first string, second string) string {
+	if value == first {
+		return second
+	} else if value == second {
+		return first
+	}
+	return value
+}
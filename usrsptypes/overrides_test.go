@@ -0,0 +1,112 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usrsptypes
+
+import (
+	"testing"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	conf := validConf()
+	args := "IgnoreUnknown=1;K8S_POD_NAME=pod;K8S_POD_NAMESPACE=default;K8S_POD_UID=1234;" +
+		"MEMIF_ROLE=master;MEMIF_MODE=ip;BRIDGE_ID=7;SOCKET_PATH=/run/memif.sock;MAC=02:00:00:00:00:02;FOO=bar"
+
+	if err := conf.ApplyOverrides(args); err != nil {
+		t.Fatalf("ApplyOverrides() failed: %v", err)
+	}
+
+	if conf.PodName != "pod" || conf.PodNamespace != "default" || conf.PodUID != "1234" {
+		t.Errorf("got pod %s/%s uid %s", conf.PodNamespace, conf.PodName, conf.PodUID)
+	}
+	if conf.ContainerConf.MemifConf.Role != "master" || conf.HostConf.MemifConf.Role != "slave" {
+		t.Errorf("got container role %s, host role %s, want master and slave",
+			conf.ContainerConf.MemifConf.Role, conf.HostConf.MemifConf.Role)
+	}
+	if conf.ContainerConf.MemifConf.Mode != "ip" || conf.HostConf.MemifConf.Mode != "ip" {
+		t.Errorf("got container mode %s, host mode %s, want ip",
+			conf.ContainerConf.MemifConf.Mode, conf.HostConf.MemifConf.Mode)
+	}
+	if conf.HostConf.BridgeConf.BridgeId != 7 || conf.RuntimeConfig.SocketPath != "/run/memif.sock" ||
+		conf.ContainerConf.Mac != "02:00:00:00:00:02" {
+		t.Errorf("got bridge %d, socket %s, mac %s", conf.HostConf.BridgeConf.BridgeId,
+			conf.RuntimeConfig.SocketPath, conf.ContainerConf.Mac)
+	}
+}
+
+// runtimeConfig takes precedence over CNI_ARGS.
+func TestApplyOverridesRuntimeConfig(t *testing.T) {
+	conf := validConf()
+	conf.RuntimeConfig.BridgeId = 9
+	conf.RuntimeConfig.SocketPath = "/run/runtime.sock"
+	conf.RuntimeConfig.Mac = "02:00:00:00:00:09"
+
+	if err := conf.ApplyOverrides("BRIDGE_ID=7;SOCKET_PATH=/run/memif.sock;MAC=02:00:00:00:00:02"); err != nil {
+		t.Fatalf("ApplyOverrides() failed: %v", err)
+	}
+
+	if conf.HostConf.BridgeConf.BridgeId != 9 || conf.RuntimeConfig.SocketPath != "/run/runtime.sock" ||
+		conf.ContainerConf.Mac != "02:00:00:00:00:09" {
+		t.Errorf("got bridge %d, socket %s, mac %s", conf.HostConf.BridgeConf.BridgeId,
+			conf.RuntimeConfig.SocketPath, conf.ContainerConf.Mac)
+	}
+}
+
+func TestApplyOverridesVhost(t *testing.T) {
+	conf := validOvsConf()
+
+	if err := conf.ApplyOverrides("VHOST_MODE=client"); err != nil {
+		t.Fatalf("ApplyOverrides() failed: %v", err)
+	}
+	if conf.ContainerConf.VhostConf.Mode != "client" || conf.HostConf.VhostConf.Mode != "server" {
+		t.Errorf("got container mode %s, host mode %s, want client and server",
+			conf.ContainerConf.VhostConf.Mode, conf.HostConf.VhostConf.Mode)
+	}
+}
+
+func TestApplyOverridesRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  string
+		field string
+	}{
+		{"bad bridge id", "BRIDGE_ID=seven", "CNI_ARGS.BRIDGE_ID"},
+		// An unknown role is passed on as is for Validate() to report.
+		{"bad memif role", "MEMIF_ROLE=primary", "host.memif.role"},
+		{"bad vhost mode", "VHOST_MODE=both", "container.vhost.mode"},
+		{"relative socket path", "SOCKET_PATH=memif.sock", "runtimeConfig.socketPath"},
+	}
+
+	for _, test := range tests {
+		conf := validConf()
+		err := conf.ApplyOverrides(test.args)
+		if err == nil {
+			err = conf.Validate()
+		}
+
+		found := false
+		for _, field := range errorFields(t, err) {
+			if field == test.field {
+				found = true
+			}
+		}
+		if found == false {
+			t.Errorf("%s: got %v, want an error for %s", test.name, err, test.field)
+		}
+	}
+
+	if err := validConf().ApplyOverrides("MAC"); err == nil {
+		t.Errorf("ApplyOverrides() accepted malformed CNI_ARGS")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module builds the config passed from the host to the container,
+// however it is delivered.
+//
+
+package usrsptypes
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+//
+// Exported Types
+//
+
+// Config passed to the container. NetConf is from the container's point of
+// view: its HostConf is the ContainerConf of the original config.
+type RemoteConfig struct {
+	NetConf     NetConf        `json:"netConf"`
+	ContainerId string         `json:"containerId"` // ContainerId used locally. Used in several place, namely in the socket filenames.
+	IPResult    current.Result `json:"ipResult"`    // Data structure returned from IPAM plugin.
+}
+
+// States of RemoteStatus.
+const (
+	RemoteStatusApplied = "applied"
+	RemoteStatusFailed  = "failed"
+)
+
+// Status of the container end of an interface, written by the container
+// agent once it processed the RemoteConfig.
+type RemoteStatus struct {
+	ContainerId string `json:"containerId"`
+	IfName      string `json:"ifName"`          // If0name of the RemoteConfig.
+	State       string `json:"state"`           // applied|failed
+	Error       string `json:"error,omitempty"` // Why the RemoteConfig failed to apply.
+	LinkUp      bool   `json:"linkUp"`          // A memif is up once connected to the host end.
+}
+
+//
+// API Functions
+//
+
+// NewRemoteConfig() - Convert the host config into the config of the
+//  container. Values not provided for the container default to those of
+//  the host, and the roles default to the opposite of the host.
+func NewRemoteConfig(conf *NetConf, ipResult *current.Result, containerID string) RemoteConfig {
+	var remote RemoteConfig
+
+	dataCopy := *conf
+	dataCopy.HostConf = dataCopy.ContainerConf
+	dataCopy.ContainerConf = UserSpaceConf{}
+
+	// IPAM is processed by the host and sent to the Container. So blank out what was already processed.
+	dataCopy.IPAM.Type = ""
+
+	// The socket ownership is applied by the host. In the container the
+	// socket is on a mount the container can't chown or relabel.
+	dataCopy.SocketConf = SocketConf{}
+
+	// Convert empty variables to valid data based on the original HostConf
+	if dataCopy.HostConf.Engine == "" {
+		dataCopy.HostConf.Engine = conf.HostConf.Engine
+	}
+	if dataCopy.HostConf.IfType == "" {
+		dataCopy.HostConf.IfType = conf.HostConf.IfType
+	}
+	if dataCopy.HostConf.NetType == "" {
+		dataCopy.HostConf.NetType = "interface"
+	}
+
+	if dataCopy.HostConf.IfType == "memif" {
+		if dataCopy.HostConf.MemifConf.Role == "" {
+			if conf.HostConf.MemifConf.Role == "master" {
+				dataCopy.HostConf.MemifConf.Role = "slave"
+			} else {
+				dataCopy.HostConf.MemifConf.Role = "master"
+			}
+		}
+		if dataCopy.HostConf.MemifConf.Mode == "" {
+			dataCopy.HostConf.MemifConf.Mode = conf.HostConf.MemifConf.Mode
+		}
+		if len(dataCopy.HostConf.MemifConf.Interfaces) == 0 {
+			dataCopy.HostConf.MemifConf.Interfaces = mirrorMemifInterfaces(conf.HostConf.MemifConf.Interfaces)
+		}
+	} else if dataCopy.HostConf.IfType == "vhostuser" {
+		if dataCopy.HostConf.VhostConf.Mode == "" {
+			if conf.HostConf.VhostConf.Mode == "client" {
+				dataCopy.HostConf.VhostConf.Mode = "server"
+			} else {
+				dataCopy.HostConf.VhostConf.Mode = "client"
+			}
+		}
+	}
+
+	remote.NetConf = dataCopy
+	remote.ContainerId = containerID
+	if ipResult != nil {
+		remote.IPResult = *ipResult
+	}
+
+	return remote
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module builds the JSON Schema of the userspace NetConf, so configs
+// can be linted before they reach a node. The enums and ranges are the ones
+// used by Validate(). The schema is written to netconf.schema.json by
+// 'make generate'.
+//
+
+package usrsptypes
+
+import (
+	"encoding/json"
+)
+
+//
+// Constants
+//
+
+// CNI versions supported by the userspace plugin.
+var SupportedCNIVersions = []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0", "1.1.0"}
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+//
+// Types
+//
+type schema map[string]interface{}
+
+//
+// API Functions
+//
+
+// JSONSchema() - Return the JSON Schema document describing NetConf.
+func JSONSchema() ([]byte, error) {
+
+	netConf := object(map[string]schema{
+		"cniVersion": enumOf("CNI spec version of the config", SupportedCNIVersions),
+		"name":       str("Network name"),
+		"type":       enumOf("Plugin binary name", []string{"userspace"}),
+		"strict":     {"type": "boolean", "description": "Reject unknown fields in the config"},
+		"if0name": {
+			"type":        "string",
+			"description": "Interface name, also used in socket file names",
+			"minLength":   1,
+			"maxLength":   maxIfNameLen,
+			"pattern":     "^[^/ ]+$",
+		},
+		"mtu":       {"type": "integer", "description": "MTU of the host and container interfaces", "minimum": minMtu, "maximum": maxMtu},
+		"macPolicy": enumOf("How MACs not provided are chosen", macPolicies[1:]),
+		"socketDir": str("Template of the socket file directory, may use {{.Namespace}}, {{.PodName}}, {{.PodUID}}, {{.IfName}} and {{.ContainerID}}"),
+		"socket": object(map[string]schema{
+			"uid":            {"type": "integer", "description": "Owner of the socket files", "minimum": 0},
+			"gid":            {"type": "integer", "description": "Group of the socket files", "minimum": 0},
+			"mode":           {"type": "string", "description": "File mode of the socket files, in octal", "pattern": "^0?[0-7]{3}$"},
+			"selinuxContext": {"type": "string", "description": "SELinux context of the socket files", "pattern": "^[^:]+:[^:]+:[^:]+:.+$"},
+		}),
+		"configDelivery": enumOf("How the container config is passed to the container", configDeliveries[1:]),
+		"linkUpTimeout":  {"type": "integer", "description": "Seconds ADD waits for the container to report the link up, 0 to not wait", "minimum": 0, "maximum": maxLinkUpTimeout},
+		"kubernetes": object(map[string]schema{
+			"apiServer": {"type": "string", "description": "URL of the Kubernetes API server", "pattern": "^https?://"},
+			"tokenFile": str("File holding the bearer token"),
+			"caFile":    str("CA bundle used to verify the API server"),
+		}),
+		"host":      {"$ref": "#/definitions/userSpaceConf"},
+		"container": {"$ref": "#/definitions/userSpaceConf"},
+
+		// Owned by the runtime or other plugins, so not checked in depth.
+		"capabilities": {"type": "object", "additionalProperties": schema{"type": "boolean"}},
+		"ipam":         {"type": "object", "properties": map[string]schema{"type": str("IPAM plugin")}},
+		"dns":          {"type": "object"},
+		"prevResult":   {"type": "object"},
+		"runtimeConfig": object(map[string]schema{
+			"mac":        str("MAC of the container interface"),
+			"ips":        {"type": "array", "items": str("IP of the container interface, with prefix length")},
+			"socketPath": str("Socket file shared by the host and container interfaces"),
+			"bridgeId":   {"type": "integer", "description": "Bridge to add the host interface to", "minimum": 1, "maximum": maxBridgeId},
+
+			"CNIDeviceInfoFile": str("File the device-info of the container interface is written to"),
+		}),
+		"args":                      {"type": "object"},
+		"cni.dev/valid-attachments": {"type": "array"},
+	})
+	// The runtime and other tools, such as Multus, add fields of their own
+	// at the top level, which Validate() accepts unless "strict" is set.
+	// The schema can't depend on "strict", so only the plugin's own objects
+	// are closed.
+	delete(netConf, "additionalProperties")
+	netConf["$schema"] = schemaDraft
+	netConf["title"] = "userspace CNI network configuration"
+	netConf["required"] = []string{"cniVersion", "name", "type", "if0name", "host"}
+
+	userSpaceConf := object(map[string]schema{
+		"engine":  enumOf("Engine implementing the interface", engines),
+		"iftype":  enumOf("Type of interface", ifTypes),
+		"netType": enumOf("Network the interface is attached to", netTypes),
+		"mac":     str("MAC of the interface"),
+		"memif": object(map[string]schema{
+			"role": enumOf("Role of memif", memifRoles),
+			"mode": enumOf("Mode of memif", memifModes),
+			"interfaces": {
+				"type":        "array",
+				"description": "Memif interfaces sharing the socket, one per Id",
+				"items": object(map[string]schema{
+					"id":       {"type": "integer", "description": "Memif Id, the same on the host and container", "minimum": 0},
+					"name":     {"type": "string", "description": "Interface name", "minLength": 1, "maxLength": maxIfNameLen},
+					"mode":     enumOf("Mode of memif", memifModes),
+					"rxQueues": {"type": "integer", "description": "Number of Rx queues", "minimum": 0, "maximum": maxMemifQueues},
+					"txQueues": {"type": "integer", "description": "Number of Tx queues", "minimum": 0, "maximum": maxMemifQueues},
+				}),
+			},
+		}),
+		"vhost": object(map[string]schema{
+			"mode": enumOf("vhost-user mode", vhostModes),
+		}),
+		"bridge": object(map[string]schema{
+			"bridgeId": {"type": "integer", "description": "Bridge Id", "minimum": 1, "maximum": maxBridgeId},
+			"vlanId":   {"type": "integer", "description": "VLAN Id, 0 for none", "minimum": 0, "maximum": maxVlanId},
+		}),
+		"l3": object(map[string]schema{
+			"unnumbered": {"type": "string", "description": "Name of the VPP interface the host interface borrows the address of", "minLength": 1},
+		}),
+	})
+	netConf["definitions"] = map[string]schema{"userSpaceConf": userSpaceConf}
+
+	return json.MarshalIndent(netConf, "", "  ")
+}
+
+//
+// Local Functions
+//
+
+func object(properties map[string]schema) schema {
+	return schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func str(description string) schema {
+	return schema{"type": "string", "description": description}
+}
+
+func enumOf(description string, values []string) schema {
+	return schema{"type": "string", "description": description, "enum": values}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module resolves the socketDir template, which places socket files
+// in a directory per pod so each pod can mount only its own sockets, and
+// applies the configured ownership, mode and SELinux context to them.
+//
+
+package usrsptypes
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"text/template/parse"
+)
+
+//
+// Constants
+//
+const selinuxXattr = "security.selinux"
+
+// Escapes the characters with a meaning in a glob pattern.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+
+// Permission bits checked for a uid, see hasAccess().
+const (
+	accessRead  = 04
+	accessWrite = 02
+	accessExec  = 01
+)
+
+//
+// API Functions
+//
+
+// ResolveSocketDir() - Return the directory for the socket files of this
+//  interface, built from the socketDir template, or "" if no template is
+//  configured and the Engine default applies. The template may use
+//  {{.Namespace}}, {{.PodName}} and {{.PodUID}} from CNI_ARGS, and
+//  {{.IfName}} and {{.ContainerID}}. Using a value that was not provided
+//  is an error.
+func (conf *NetConf) ResolveSocketDir(containerID string) (string, error) {
+	if conf.SocketDir == "" {
+		return "", nil
+	}
+
+	tmpl, err := parseSocketDir(conf.SocketDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Only non-empty values are added, so the template fails on missing ones.
+	values := map[string]string{}
+	addValue(values, "Namespace", conf.PodNamespace)
+	addValue(values, "PodName", conf.PodName)
+	addValue(values, "PodUID", conf.PodUID)
+	addValue(values, "IfName", conf.If0name)
+	addValue(values, "ContainerID", containerID)
+
+	var dir bytes.Buffer
+	if err = tmpl.Execute(&dir, values); err != nil {
+		return "", fmt.Errorf("ERROR: Unable to resolve socketDir %q: %v", conf.SocketDir, err)
+	}
+
+	return filepath.Clean(dir.String()), nil
+}
+
+// SocketDirGlob() - Return a glob pattern matching every directory the
+//  socketDir template resolves to, each value of the template matching any
+//  name, or "" if no template is configured.
+func (conf *NetConf) SocketDirGlob() (string, error) {
+	if conf.SocketDir == "" {
+		return "", nil
+	}
+
+	tmpl, err := parseSocketDir(conf.SocketDir)
+	if err != nil {
+		return "", err
+	}
+
+	var pattern bytes.Buffer
+	for _, node := range tmpl.Tree.Root.Nodes {
+		if text, ok := node.(*parse.TextNode); ok {
+			pattern.WriteString(globEscaper.Replace(string(text.Text)))
+		} else {
+			pattern.WriteString("*")
+		}
+	}
+
+	return filepath.Clean(pattern.String()), nil
+}
+
+// PrepareSocketDir() - Create the directory for the socket files, if
+//  needed. The socket config is only applied to a directory of the pod,
+//  perPod, created by this call. An existing directory, like the shared
+//  default one or one of the runtime, is left as is for the other pods
+//  using it. The directory mode is the socket mode plus search permission
+//  wherever read is granted. Returns true if the directory is owned by the
+//  CNI, a directory of the pod created by this call, to be removed with its
+//  last socket.
+func (conf *NetConf) PrepareSocketDir(dir string, perPod bool) (bool, error) {
+	_, err := os.Stat(dir)
+	created := os.IsNotExist(err)
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return false, err
+	}
+	if perPod == false || created == false {
+		return false, nil
+	}
+
+	mode, err := conf.SocketConf.fileMode()
+	if err != nil {
+		return true, err
+	}
+	if mode != 0 {
+		mode = 0700 | mode | (mode&0444)>>2
+	}
+
+	return true, conf.SocketConf.apply(dir, mode)
+}
+
+// SetSocketPermissions() - Apply the socket config to a socket file and
+//  make sure the configured uid can use it. If the file doesn't exist yet,
+//  because the container side creates it, the uid must be able to create it
+//  in its directory. Returns an error if the uid can't reach the socket.
+func (conf *NetConf) SetSocketPermissions(sockFile string) error {
+	sockConf := &conf.SocketConf
+
+	if _, err := os.Stat(sockFile); err == nil {
+		mode, err := sockConf.fileMode()
+		if err != nil {
+			return err
+		}
+		if err = sockConf.apply(sockFile, mode); err != nil {
+			return err
+		}
+	} else if os.IsNotExist(err) == false {
+		return err
+	}
+
+	if sockConf.Uid == nil {
+		return nil
+	}
+
+	uid := *sockConf.Uid
+	gid := -1
+	if sockConf.Gid != nil {
+		gid = *sockConf.Gid
+	}
+
+	// The directory is mounted into the pod, so the directories above it
+	// on the host don't need to be searchable.
+	dir := filepath.Dir(sockFile)
+	if hasAccess(dir, uid, gid, accessExec) == false {
+		return fmt.Errorf("ERROR: Socket %s not accessible to uid %d, no search permission on %s", sockFile, uid, dir)
+	}
+
+	if _, err := os.Stat(sockFile); err == nil {
+		if hasAccess(sockFile, uid, gid, accessRead|accessWrite) == false {
+			return fmt.Errorf("ERROR: Socket %s not accessible to uid %d, no read/write permission", sockFile, uid)
+		}
+	} else if hasAccess(dir, uid, gid, accessWrite) == false {
+		return fmt.Errorf("ERROR: Socket %s can't be created by uid %d, no write permission on %s", sockFile, uid, dir)
+	}
+
+	return nil
+}
+
+//
+// Local Functions
+//
+
+// Return the configured socket mode, or 0 if not configured.
+func (sockConf *SocketConf) fileMode() (os.FileMode, error) {
+	if sockConf.Mode == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(sockConf.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("ERROR: Invalid socket mode:%s", sockConf.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Apply the ownership, mode (if not 0) and SELinux context to path.
+func (sockConf *SocketConf) apply(path string, mode os.FileMode) error {
+	if sockConf.Uid != nil || sockConf.Gid != nil {
+		uid, gid := -1, -1
+		if sockConf.Uid != nil {
+			uid = *sockConf.Uid
+		}
+		if sockConf.Gid != nil {
+			gid = *sockConf.Gid
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	if sockConf.SELinuxContext != "" {
+		if err := syscall.Setxattr(path, selinuxXattr, []byte(sockConf.SELinuxContext), 0); err != nil {
+			return fmt.Errorf("ERROR: Unable to set SELinux context %s on %s: %v", sockConf.SELinuxContext, path, err)
+		}
+	}
+
+	return nil
+}
+
+// Check the permission bits of path for uid and gid, the way the kernel
+// does for a process without supplementary groups. root always has access.
+func hasAccess(path string, uid int, gid int, access os.FileMode) bool {
+	if uid == 0 {
+		return true
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok == false {
+		return false
+	}
+
+	perm := info.Mode().Perm()
+	if int(stat.Uid) == uid {
+		perm >>= 6
+	} else if int(stat.Gid) == gid {
+		perm >>= 3
+	}
+
+	return perm&access == access
+}
+
+func parseSocketDir(socketDir string) (*template.Template, error) {
+	return template.New("socketDir").Option("missingkey=error").Parse(socketDir)
+}
+
+func addValue(values map[string]string, key string, value string) {
+	if value != "" {
+		values[key] = value
+	}
+}
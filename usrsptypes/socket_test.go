@@ -0,0 +1,165 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usrsptypes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testContainerID = "0123456789abcdef"
+
+func TestResolveSocketDir(t *testing.T) {
+	tests := []struct {
+		name      string
+		socketDir string
+		dir       string
+	}{
+		{"no template", "", ""},
+		{"constant", "/var/run/vpp/cni/pods", "/var/run/vpp/cni/pods"},
+		{"pod", "/var/run/vpp/cni/{{.Namespace}}/{{.PodName}}", "/var/run/vpp/cni/default/pod"},
+		{"uid and interface", "/var/run/vpp/cni/{{.PodUID}}/{{.IfName}}", "/var/run/vpp/cni/1234/net1"},
+		{"container", "/var/run/vpp/cni/{{.ContainerID}}/", "/var/run/vpp/cni/" + testContainerID},
+	}
+
+	for _, test := range tests {
+		conf := validConf()
+		conf.SocketDir = test.socketDir
+		conf.PodName, conf.PodNamespace, conf.PodUID = "pod", "default", "1234"
+
+		dir, err := conf.ResolveSocketDir(testContainerID)
+		if err != nil {
+			t.Errorf("%s: ResolveSocketDir() failed: %v", test.name, err)
+		} else if dir != test.dir {
+			t.Errorf("%s: got %q, want %q", test.name, dir, test.dir)
+		}
+	}
+}
+
+func TestResolveSocketDirRejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		socketDir string
+	}{
+		{"not run by kubernetes", "/var/run/vpp/cni/{{.PodUID}}"},
+		{"unknown value", "/var/run/vpp/cni/{{.PodIP}}"},
+		{"bad template", "/var/run/vpp/cni/{{.PodUID"},
+	}
+
+	for _, test := range tests {
+		conf := validConf()
+		conf.SocketDir = test.socketDir
+
+		if dir, err := conf.ResolveSocketDir(testContainerID); err == nil {
+			t.Errorf("%s: got %q, want an error", test.name, dir)
+		}
+	}
+}
+
+func TestSocketDirGlob(t *testing.T) {
+	tests := []struct {
+		name      string
+		socketDir string
+		pattern   string
+	}{
+		{"no template", "", ""},
+		{"constant", "/var/run/vpp/cni/pods/", "/var/run/vpp/cni/pods"},
+		{"pod", "/var/run/vpp/cni/{{.Namespace}}/{{.PodName}}", "/var/run/vpp/cni/*/*"},
+		{"prefix", "/var/run/vpp/cni/pod-{{.PodUID}}-{{.IfName}}", "/var/run/vpp/cni/pod-*-*"},
+		{"escaped", "/var/run/vpp/cni[1]/{{.PodUID}}", `/var/run/vpp/cni\[1]/*`},
+	}
+
+	for _, test := range tests {
+		conf := validConf()
+		conf.SocketDir = test.socketDir
+
+		pattern, err := conf.SocketDirGlob()
+		if err != nil {
+			t.Errorf("%s: SocketDirGlob() failed: %v", test.name, err)
+		} else if pattern != test.pattern {
+			t.Errorf("%s: got %q, want %q", test.name, pattern, test.pattern)
+		}
+	}
+
+	// Every directory the template resolves to matches the pattern.
+	conf := validConf()
+	conf.SocketDir = "/var/run/vpp/cni/{{.Namespace}}/{{.PodName}}"
+	conf.PodName, conf.PodNamespace = "pod", "default"
+	pattern, _ := conf.SocketDirGlob()
+	dir, _ := conf.ResolveSocketDir(testContainerID)
+	if ok, _ := filepath.Match(pattern, dir); ok == false {
+		t.Errorf("%q doesn't match %q", dir, pattern)
+	}
+}
+
+func TestPrepareSocketDir(t *testing.T) {
+	base, err := ioutil.TempDir("", "socketdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	// An existing directory, like the shared default one.
+	shared := filepath.Join(base, "shared")
+	if err = os.Mkdir(shared, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		dir    string
+		perPod bool
+		mode   os.FileMode
+		owned  bool
+	}{
+		{"created for the pod", filepath.Join(base, "pod1"), true, 0750, true},
+		{"created, not for the pod", filepath.Join(base, "runtime"), false, 0700, false},
+		{"existing", shared, true, 0700, false},
+	}
+
+	for _, test := range tests {
+		conf := validConf()
+		conf.SocketConf.Mode = "0640"
+
+		owned, err := conf.PrepareSocketDir(test.dir, test.perPod)
+		if err != nil {
+			t.Errorf("%s: PrepareSocketDir() failed: %v", test.name, err)
+			continue
+		}
+		if owned != test.owned {
+			t.Errorf("%s: got owned %v, want %v", test.name, owned, test.owned)
+		}
+		info, err := os.Stat(test.dir)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if info.Mode().Perm() != test.mode {
+			t.Errorf("%s: got mode %o, want %o", test.name, info.Mode().Perm(), test.mode)
+		}
+	}
+}
+
+// The container can't apply the socket config, the host does.
+func TestRemoteConfigSocketConf(t *testing.T) {
+	conf := validConf()
+	conf.SocketConf.Uid = intPtr(1000)
+	conf.SocketConf.Mode = "0660"
+
+	remote := NewRemoteConfig(conf, nil, testContainerID)
+	if remote.NetConf.SocketConf != (SocketConf{}) {
+		t.Errorf("got socket config %+v in the container config", remote.NetConf.SocketConf)
+	}
+}
@@ -16,7 +16,7 @@ package usrsptypes
 
 import (
 	"github.com/containernetworking/cni/pkg/types"
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 )
 
 //
@@ -30,8 +30,19 @@ type UsrSpCni interface {
 }
 
 type MemifConf struct {
-	Role string `json:"role"` // Role of memif: master|slave
-	Mode string `json:"mode"` // Mode of memif: ip|ethernet|inject-punt
+	Role       string        `json:"role"`                 // Role of memif: master|slave
+	Mode       string        `json:"mode"`                 // Mode of memif: ip|ethernet|inject-punt
+	Interfaces []MemifIfConf `json:"interfaces,omitempty"` // Optional memif interfaces sharing the socket file
+}
+
+// One of several memif interfaces sharing a socket file. Unset values
+// default to the Mode of the MemifConf and one queue each way.
+type MemifIfConf struct {
+	Id       uint32 `json:"id"`                 // memif ID, unique on the socket file
+	Name     string `json:"name,omitempty"`     // Name of the interface in the result
+	Mode     string `json:"mode,omitempty"`     // Mode of memif: ip|ethernet|inject-punt
+	RxQueues int    `json:"rxQueues,omitempty"` // Number of receive queues
+	TxQueues int    `json:"txQueues,omitempty"` // Number of transmit queues
 }
 
 type VhostConf struct {
@@ -40,7 +51,14 @@ type VhostConf struct {
 
 type BridgeConf struct {
 	BridgeId int `json:"bridgeId"`         // Bridge Id
-	VlanId   int `json:"vlanId,onitempty"` // Optional VLAN Id
+	VlanId   int `json:"vlanId,omitempty"` // Optional VLAN Id
+}
+
+// Host side of the point-to-point L3 model of NetType interface. By default
+// the host interface borrows the gateway from IPAM from a loopback shared by
+// the pods with the same gateways.
+type L3Conf struct {
+	Unnumbered string `json:"unnumbered,omitempty"` // Optional name of the VPP interface to borrow the address of
 }
 
 type UserSpaceConf struct {
@@ -51,15 +69,68 @@ type UserSpaceConf struct {
 	Engine     string     `json:"engine,omitempty"`  // CNI Implementation {vpp|ovs|ovs-dpdk|linux}
 	IfType     string     `json:"iftype,omitempty"`  // Type of interface {memif|vhostuser|veth|tap}
 	NetType    string     `json:"netType,omitempty"` // Interface network type {none|bridge|interface}
+	Mac        string     `json:"mac,omitempty"`     // Optional MAC of the interface
 	MemifConf  MemifConf  `json:"memif,omitempty"`
 	VhostConf  VhostConf  `json:"vhost,omitempty"`
 	BridgeConf BridgeConf `json:"bridge,omitempty"`
+	L3Conf     L3Conf     `json:"l3,omitempty"`
+}
+
+// Ownership, mode and SELinux context applied to the socket files and the
+// directories they are created in. Unset values are left as created.
+type SocketConf struct {
+	Uid            *int   `json:"uid,omitempty"`            // Owner of the socket files
+	Gid            *int   `json:"gid,omitempty"`            // Group of the socket files
+	Mode           string `json:"mode,omitempty"`           // File mode of the socket files, in octal such as "0660"
+	SELinuxContext string `json:"selinuxContext,omitempty"` // SELinux context, such as "system_u:object_r:container_file_t:s0"
+}
+
+// Access to the Kubernetes API, used to deliver the container config as
+// a pod annotation.
+type KubernetesConf struct {
+	ApiServer string `json:"apiServer,omitempty"` // URL of the API server, such as https://10.0.0.1:6443
+	TokenFile string `json:"tokenFile,omitempty"` // File holding the bearer token
+	CAFile    string `json:"caFile,omitempty"`    // CA bundle used to verify the API server
+}
+
+// Capabilities passed by the runtime in runtimeConfig. The runtime only
+// passes a key if the config lists it in "capabilities".
+type RuntimeConfig struct {
+	Mac        string   `json:"mac,omitempty"`        // MAC of the container interface
+	IPs        []string `json:"ips,omitempty"`        // IPs of the container interface, used when no IPAM is configured
+	SocketPath string   `json:"socketPath,omitempty"` // Socket file shared by the host and container interfaces
+	BridgeId   int      `json:"bridgeId,omitempty"`   // Bridge to add the host interface to
+
+	// Passed by Multus, where to write the device-info of the container
+	// interface for the network-status annotation.
+	CNIDeviceInfoFile string `json:"CNIDeviceInfoFile,omitempty"`
 }
 
 type NetConf struct {
 	types.NetConf
 	Name          string        `json:"name"`
-	If0name       string        `json:"if0name,omitempty"` // Interface name
+	Strict        bool          `json:"strict,omitempty"`    // Reject unknown fields in the config
+	If0name       string        `json:"if0name,omitempty"`   // Interface name
+	Mtu           int           `json:"mtu,omitempty"`       // MTU of the host and container interfaces, 0 for the default
+	MacPolicy     string        `json:"macPolicy,omitempty"` // How MACs not provided are chosen {random|derived|explicit|runtime}
+	SocketDir     string        `json:"socketDir,omitempty"` // Optional template of the socket file directory
+	SocketConf    SocketConf    `json:"socket,omitempty"`
 	HostConf      UserSpaceConf `json:"host,omitempty"`
 	ContainerConf UserSpaceConf `json:"container,omitempty"`
+	RuntimeConfig RuntimeConfig `json:"runtimeConfig,omitempty"`
+
+	// How the container config is passed to the container: file (default),
+	// through a directory mounted into the container, or annotation, as a
+	// pod annotation read through the downward API.
+	ConfigDelivery string         `json:"configDelivery,omitempty"`
+	Kubernetes     KubernetesConf `json:"kubernetes,omitempty"`
+
+	// Seconds ADD waits for the container to report the link of its
+	// interface up, 0 to return once the config is delivered.
+	LinkUpTimeout int `json:"linkUpTimeout,omitempty"`
+
+	// Pod the interface is added to, from CNI_ARGS. Not part of the config.
+	PodName      string `json:"-"`
+	PodNamespace string `json:"-"`
+	PodUID       string `json:"-"`
 }
@@ -0,0 +1,482 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module validates the userspace NetConf before any Engine acts on
+// it, so a bad configuration is reported against the field that caused it
+// and no VPP or OVS state is changed.
+//
+
+package usrsptypes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//
+// Constants
+//
+const (
+	maxIfNameLen = 15       // IFNAMSIZ - 1, If0name is also used in socket file names.
+	maxBridgeId  = 0xFFFFFF // Largest bridge domain Id accepted by VPP.
+	maxVlanId    = 4094
+	minMtu       = 68   // Smallest MTU accepted for IPv4 by the kernel.
+	maxMtu       = 9216 // Largest frame size accepted by VPP.
+
+	maxLinkUpTimeout = 300 // Seconds, longer would stall pod creation.
+)
+
+// Interface types each Engine is able to create on the host.
+var engineIfTypes = map[string][]string{
+	"vpp":      {"memif"},
+	"ovs-dpdk": {"vhostuser"},
+}
+
+// Network types each Engine is able to attach a host interface to.
+var engineNetTypes = map[string][]string{
+	"vpp":      {"", "none", "bridge", "interface"},
+	"ovs-dpdk": {"", "none", "interface"},
+}
+
+// Keys a runtime may add to the config that are not part of NetConf.
+var runtimeKeys = []string{"args"}
+
+var (
+	engines    = []string{"vpp", "ovs-dpdk"}
+	ifTypes    = []string{"memif", "vhostuser"}
+	netTypes   = []string{"", "none", "bridge", "interface"}
+	memifRoles = []string{"master", "slave"}
+	memifModes = []string{"", "ethernet", "ip", "inject-punt"}
+	vhostModes = []string{"", "client", "server"}
+
+	configDeliveries = []string{"", "file", "annotation"}
+)
+
+//
+// Exported Types
+//
+
+// FieldError describes a single invalid NetConf field. Field is the json
+// path of the field, such as "host.memif.role".
+type FieldError struct {
+	Field  string
+	Value  interface{}
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("Invalid %s=%q: %s", e.Field, fmt.Sprint(e.Value), e.Reason)
+}
+
+// ValidationError holds every FieldError found in a NetConf.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		msgs = append(msgs, fieldErr.Error())
+	}
+	return "ERROR: " + strings.Join(msgs, "; ")
+}
+
+//
+// API Functions
+//
+
+// Validate() - Check the whole NetConf and return a *ValidationError naming
+//  every invalid field, or nil if the NetConf can be used. Container values
+//  default to the Host values, so are only checked where provided.
+func (conf *NetConf) Validate() error {
+	v := &ValidationError{}
+
+	if conf.If0name == "" {
+		v.add("if0name", conf.If0name, "required")
+	} else if len(conf.If0name) > maxIfNameLen {
+		v.add("if0name", conf.If0name, fmt.Sprintf("longer than %d characters", maxIfNameLen))
+	} else if strings.ContainsAny(conf.If0name, "/ ") {
+		v.add("if0name", conf.If0name, "must not contain '/' or spaces")
+	}
+
+	if conf.Mtu != 0 && (conf.Mtu < minMtu || conf.Mtu > maxMtu) {
+		v.add("mtu", conf.Mtu, fmt.Sprintf("must be between %d and %d", minMtu, maxMtu))
+	}
+
+	if conf.SocketDir != "" {
+		if filepath.IsAbs(conf.SocketDir) == false {
+			v.add("socketDir", conf.SocketDir, "must be an absolute path")
+		} else if _, err := parseSocketDir(conf.SocketDir); err != nil {
+			v.add("socketDir", conf.SocketDir, err.Error())
+		}
+	}
+
+	sockConf := &conf.SocketConf
+	if sockConf.Uid != nil && *sockConf.Uid < 0 {
+		v.add("socket.uid", *sockConf.Uid, "must not be negative")
+	}
+	if sockConf.Gid != nil && *sockConf.Gid < 0 {
+		v.add("socket.gid", *sockConf.Gid, "must not be negative")
+	}
+	if _, err := sockConf.fileMode(); err != nil {
+		v.add("socket.mode", sockConf.Mode, "must be an octal file mode no greater than 0777")
+	}
+	if sockConf.SELinuxContext != "" && strings.Count(sockConf.SELinuxContext, ":") < 3 {
+		v.add("socket.selinuxContext", sockConf.SELinuxContext, "must be of the form user:role:type:level")
+	}
+
+	if contains(configDeliveries, conf.ConfigDelivery) == false {
+		v.add("configDelivery", conf.ConfigDelivery, "must be one of file|annotation")
+	} else if conf.ConfigDelivery == "annotation" {
+		kubeConf := &conf.Kubernetes
+		if kubeConf.ApiServer == "" {
+			v.add("kubernetes.apiServer", kubeConf.ApiServer, "required with configDelivery annotation")
+		} else if u, err := url.Parse(kubeConf.ApiServer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("kubernetes.apiServer", kubeConf.ApiServer, "must be an http or https URL")
+		}
+		if conf.PodName == "" {
+			v.add("CNI_ARGS.K8S_POD_NAME", conf.PodName, "required with configDelivery annotation")
+		}
+		if conf.PodNamespace == "" {
+			v.add("CNI_ARGS.K8S_POD_NAMESPACE", conf.PodNamespace, "required with configDelivery annotation")
+		}
+	}
+
+	host := &conf.HostConf
+	container := &conf.ContainerConf
+
+	if conf.LinkUpTimeout < 0 || conf.LinkUpTimeout > maxLinkUpTimeout {
+		v.add("linkUpTimeout", conf.LinkUpTimeout, fmt.Sprintf("must be between 0 and %d", maxLinkUpTimeout))
+	} else if conf.LinkUpTimeout != 0 {
+		// Only vpp-app reports back, through the directory shared with the host.
+		if conf.ConfigDelivery == "annotation" {
+			v.add("linkUpTimeout", conf.LinkUpTimeout, "not supported with configDelivery annotation")
+		}
+		if container.Engine != "vpp" && (container.Engine != "" || host.Engine != "vpp") {
+			v.add("linkUpTimeout", conf.LinkUpTimeout, "only supported with container engine vpp")
+		}
+	}
+
+	//
+	// Host
+	//
+	if host.Engine == "" {
+		v.add("host.engine", host.Engine, "required, must be one of "+strings.Join(engines, "|"))
+	} else if _, ok := engineIfTypes[host.Engine]; ok == false {
+		v.add("host.engine", host.Engine, "must be one of "+strings.Join(engines, "|"))
+	} else {
+		if contains(engineIfTypes[host.Engine], host.IfType) == false {
+			v.add("host.iftype", host.IfType, "not supported by engine "+host.Engine+", must be one of "+strings.Join(engineIfTypes[host.Engine], "|"))
+		}
+		if contains(engineNetTypes[host.Engine], host.NetType) == false {
+			v.add("host.netType", host.NetType, "not supported by engine "+host.Engine)
+		}
+	}
+	v.checkUserSpaceConf("host", host, true)
+
+	//
+	// Container
+	//
+	if container.Engine != "" {
+		if _, ok := engineIfTypes[container.Engine]; ok == false {
+			v.add("container.engine", container.Engine, "must be one of "+strings.Join(engines, "|"))
+		}
+	}
+	if container.IfType != "" && container.IfType != host.IfType {
+		v.add("container.iftype", container.IfType, "must match host.iftype "+host.IfType)
+	}
+	if contains(netTypes, container.NetType) == false {
+		v.add("container.netType", container.NetType, "must be one of none|bridge|interface")
+	}
+	v.checkUserSpaceConf("container", container, false)
+
+	// The two ends of the connection must take opposite roles.
+	if host.IfType == "memif" && contains(memifRoles, host.MemifConf.Role) &&
+		container.MemifConf.Role == host.MemifConf.Role {
+		v.add("container.memif.role", container.MemifConf.Role, "must be the opposite of host.memif.role")
+	}
+	if host.IfType == "memif" && container.MemifConf.Mode != "" && host.MemifConf.Mode != "" &&
+		container.MemifConf.Mode != host.MemifConf.Mode {
+		v.add("container.memif.mode", container.MemifConf.Mode, "must match host.memif.mode "+host.MemifConf.Mode)
+	}
+	if host.IfType == "memif" && len(container.MemifConf.Interfaces) != 0 &&
+		sameMemifIds(container.MemifConf.InterfaceList(""), host.MemifConf.InterfaceList("")) == false {
+		v.add("container.memif.interfaces", memifIds(container.MemifConf.Interfaces),
+			"must have the memif IDs of host.memif.interfaces "+memifIds(host.MemifConf.InterfaceList("")))
+	}
+	if host.L3Conf.Unnumbered != "" && (host.Engine != "vpp" || host.NetType != "interface") {
+		v.add("host.l3.unnumbered", host.L3Conf.Unnumbered, "only supported with engine vpp and netType interface")
+	}
+	if container.L3Conf.Unnumbered != "" {
+		v.add("container.l3.unnumbered", container.L3Conf.Unnumbered, "only supported on the host")
+	}
+	if host.IfType == "vhostuser" && container.VhostConf.Mode != "" &&
+		container.VhostConf.Mode == host.VhostConf.Mode {
+		v.add("container.vhost.mode", container.VhostConf.Mode, "must be the opposite of host.vhost.mode")
+	}
+
+	//
+	// MAC Policy
+	//
+	if contains(macPolicies, conf.MacPolicy) == false {
+		v.add("macPolicy", conf.MacPolicy, "must be one of "+strings.Join(macPolicies[1:], "|"))
+	} else if conf.MacPolicy == MacPolicyExplicit {
+		// Engines that can't set the host MAC only take the container MAC.
+		if host.Mac == "" && contains(hostMacEngines, host.Engine) {
+			v.add("host.mac", host.Mac, "required with macPolicy explicit")
+		}
+		if container.Mac == "" {
+			v.add("container.mac", container.Mac, "required with macPolicy explicit")
+		}
+	} else if conf.MacPolicy == MacPolicyRuntime && conf.RuntimeConfig.Mac == "" {
+		v.add("runtimeConfig.mac", conf.RuntimeConfig.Mac, "required with macPolicy runtime, from runtimeConfig or CNI_ARGS MAC")
+	}
+	if host.Mac != "" && host.Engine != "" && contains(hostMacEngines, host.Engine) == false {
+		v.add("host.mac", host.Mac, "not supported by engine "+host.Engine)
+	}
+
+	//
+	// Runtime Config
+	//
+	rc := &conf.RuntimeConfig
+	for i, ipStr := range rc.IPs {
+		if _, _, err := net.ParseCIDR(ipStr); err != nil {
+			v.add(fmt.Sprintf("runtimeConfig.ips[%d]", i), ipStr, "must be an address with prefix length")
+		}
+	}
+	if rc.SocketPath != "" && filepath.IsAbs(rc.SocketPath) == false {
+		v.add("runtimeConfig.socketPath", rc.SocketPath, "must be an absolute path")
+	}
+	if rc.CNIDeviceInfoFile != "" && filepath.IsAbs(rc.CNIDeviceInfoFile) == false {
+		v.add("runtimeConfig.CNIDeviceInfoFile", rc.CNIDeviceInfoFile, "must be an absolute path")
+	}
+
+	if len(v.Errors) != 0 {
+		return v
+	}
+	return nil
+}
+
+// CheckUnknownFields() - Return a *ValidationError naming every field in
+//  the raw config that doesn't map to NetConf, or nil if there are none.
+//  Only the userspace specific objects are checked in depth, the contents
+//  of ipam, prevResult and the runtime keys belong to other components.
+func CheckUnknownFields(bytes []byte) error {
+	var raw map[string]json.RawMessage
+
+	if err := json.Unmarshal(bytes, &raw); err != nil {
+		return err
+	}
+
+	v := &ValidationError{}
+	v.checkFields("", raw, reflect.TypeOf(NetConf{}))
+
+	if len(v.Errors) != 0 {
+		return v
+	}
+	return nil
+}
+
+//
+// Local Functions
+//
+
+func (v *ValidationError) add(field string, value interface{}, reason string) {
+	v.Errors = append(v.Errors, &FieldError{Field: field, Value: value, Reason: reason})
+}
+
+// Check the values within a UserSpaceConf. The memif role is required on
+// the host, the container defaults to the opposite role.
+func (v *ValidationError) checkUserSpaceConf(prefix string, usConf *UserSpaceConf, isHost bool) {
+
+	if usConf.IfType == "memif" || (isHost == false && usConf.MemifConf.Role != "") {
+		if usConf.MemifConf.Role == "" && isHost {
+			v.add(prefix+".memif.role", usConf.MemifConf.Role, "required, must be one of master|slave")
+		} else if usConf.MemifConf.Role != "" && contains(memifRoles, usConf.MemifConf.Role) == false {
+			v.add(prefix+".memif.role", usConf.MemifConf.Role, "must be one of master|slave")
+		}
+	}
+	if usConf.Mac != "" {
+		if hwAddr, err := net.ParseMAC(usConf.Mac); err != nil {
+			v.add(prefix+".mac", usConf.Mac, "must be a MAC address")
+		} else if hwAddr[0]&0x01 != 0 {
+			v.add(prefix+".mac", usConf.Mac, "must be a unicast MAC address")
+		}
+	}
+	if contains(memifModes, usConf.MemifConf.Mode) == false {
+		v.add(prefix+".memif.mode", usConf.MemifConf.Mode, "must be one of ethernet|ip|inject-punt")
+	}
+	if len(usConf.MemifConf.Interfaces) != 0 {
+		if usConf.IfType != "" && usConf.IfType != "memif" {
+			v.add(prefix+".memif.interfaces", memifIds(usConf.MemifConf.Interfaces), "only supported with iftype memif")
+		}
+		v.checkMemifInterfaces(prefix+".memif.interfaces", usConf.MemifConf.Interfaces)
+	}
+	if contains(vhostModes, usConf.VhostConf.Mode) == false {
+		v.add(prefix+".vhost.mode", usConf.VhostConf.Mode, "must be one of client|server")
+	}
+
+	if usConf.NetType == "bridge" {
+		if usConf.BridgeConf.BridgeId < 1 || usConf.BridgeConf.BridgeId > maxBridgeId {
+			v.add(prefix+".bridge.bridgeId", usConf.BridgeConf.BridgeId, fmt.Sprintf("must be in the range 1-%d", maxBridgeId))
+		}
+	}
+	if usConf.BridgeConf.VlanId < 0 || usConf.BridgeConf.VlanId > maxVlanId {
+		v.add(prefix+".bridge.vlanId", usConf.BridgeConf.VlanId, fmt.Sprintf("must be in the range 1-%d, or 0 for none", maxVlanId))
+	}
+}
+
+// Check the memif interfaces sharing a socket file. IDs and names must be
+// unique on the socket file.
+func (v *ValidationError) checkMemifInterfaces(prefix string, list []MemifIfConf) {
+	ids := make(map[uint32]bool)
+	names := make(map[string]bool)
+
+	for i, intf := range list {
+		field := fmt.Sprintf("%s[%d]", prefix, i)
+
+		if ids[intf.Id] {
+			v.add(field+".id", intf.Id, "duplicate memif ID")
+		}
+		ids[intf.Id] = true
+
+		if intf.Name != "" {
+			if names[intf.Name] {
+				v.add(field+".name", intf.Name, "duplicate name")
+			} else if len(intf.Name) > maxIfNameLen {
+				v.add(field+".name", intf.Name, fmt.Sprintf("longer than %d characters", maxIfNameLen))
+			}
+			names[intf.Name] = true
+		}
+
+		if contains(memifModes, intf.Mode) == false {
+			v.add(field+".mode", intf.Mode, "must be one of ethernet|ip|inject-punt")
+		}
+		if intf.RxQueues < 0 || intf.RxQueues > maxMemifQueues {
+			v.add(field+".rxQueues", intf.RxQueues, fmt.Sprintf("must be in the range 1-%d, or 0 for 1", maxMemifQueues))
+		}
+		if intf.TxQueues < 0 || intf.TxQueues > maxMemifQueues {
+			v.add(field+".txQueues", intf.TxQueues, fmt.Sprintf("must be in the range 1-%d, or 0 for 1", maxMemifQueues))
+		}
+	}
+}
+
+// Return true if both lists have the same memif IDs, in any order.
+func sameMemifIds(list1 []MemifIfConf, list2 []MemifIfConf) bool {
+	if len(list1) != len(list2) {
+		return false
+	}
+	ids := make(map[uint32]bool)
+	for _, intf := range list1 {
+		ids[intf.Id] = true
+	}
+	for _, intf := range list2 {
+		if ids[intf.Id] == false {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the memif IDs of the list, such as "0,1,2", for error messages.
+func memifIds(list []MemifIfConf) string {
+	ids := make([]string, len(list))
+	for i, intf := range list {
+		ids[i] = fmt.Sprint(intf.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func contains(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Report keys in raw that have no matching json field in t, recursing into
+// fields whose type is defined in this package.
+func (v *ValidationError) checkFields(prefix string, raw map[string]json.RawMessage, t reflect.Type) {
+	fields := jsonFields(t)
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, ok := fields[key]
+		if ok == false {
+			if prefix == "" && contains(runtimeKeys, key) {
+				continue
+			}
+			v.add(prefix+key, string(raw[key]), "unknown field")
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == t.PkgPath() {
+			var sub map[string]json.RawMessage
+			if err := json.Unmarshal(raw[key], &sub); err == nil {
+				v.checkFields(prefix+key+".", sub, field.Type)
+			}
+		} else if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct &&
+			field.Type.Elem().PkgPath() == t.PkgPath() {
+			var subs []map[string]json.RawMessage
+			if err := json.Unmarshal(raw[key], &subs); err == nil {
+				for i, sub := range subs {
+					v.checkFields(fmt.Sprintf("%s%s[%d].", prefix, key, i), sub, field.Type.Elem())
+				}
+			}
+		}
+	}
+}
+
+// Map the json names of the fields of t, including those of embedded
+// structs, to the fields.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+
+		if field.Anonymous && name == "" {
+			for embName, embField := range jsonFields(field.Type) {
+				if _, ok := fields[embName]; ok == false {
+					fields[embName] = embField
+				}
+			}
+			continue
+		}
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+
+	return fields
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usrsptypes
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A valid VPP memif config, which each test case breaks in one way.
+func validConf() *NetConf {
+	conf := &NetConf{Name: "userspace-vpp-net", If0name: "net1"}
+	conf.HostConf.Engine = "vpp"
+	conf.HostConf.IfType = "memif"
+	conf.HostConf.NetType = "bridge"
+	conf.HostConf.MemifConf.Role = "master"
+	conf.HostConf.BridgeConf.BridgeId = 4
+	return conf
+}
+
+// A valid OVS-DPDK vhost-user config.
+func validOvsConf() *NetConf {
+	conf := &NetConf{Name: "userspace-ovs-net", If0name: "net1"}
+	conf.HostConf.Engine = "ovs-dpdk"
+	conf.HostConf.IfType = "vhostuser"
+	conf.HostConf.VhostConf.Mode = "client"
+	return conf
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// Return the fields named by the *ValidationError err.
+func errorFields(t *testing.T, err error) []string {
+	var fields []string
+
+	if err == nil {
+		return nil
+	}
+	v, ok := err.(*ValidationError)
+	if ok == false {
+		t.Fatalf("got %T, want *ValidationError: %v", err, err)
+	}
+	for _, fieldErr := range v.Errors {
+		fields = append(fields, fieldErr.Field)
+	}
+	return fields
+}
+
+func TestValidateValid(t *testing.T) {
+	// On ovs-dpdk, macPolicy applies to the container end.
+	derivedOvs := validOvsConf()
+	derivedOvs.MacPolicy = MacPolicyDerived
+	explicitOvs := validOvsConf()
+	explicitOvs.MacPolicy = MacPolicyExplicit
+	explicitOvs.ContainerConf.Mac = "02:00:00:00:00:02"
+
+	for _, conf := range []*NetConf{validConf(), validOvsConf(), derivedOvs, explicitOvs} {
+		if err := conf.Validate(); err != nil {
+			t.Errorf("Validate(%s) failed: %v", conf.Name, err)
+		}
+	}
+}
+
+func TestValidateRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		ovs    bool
+		modify func(conf *NetConf)
+		field  string
+	}{
+		{"no if0name", false, func(c *NetConf) { c.If0name = "" }, "if0name"},
+		{"long if0name", false, func(c *NetConf) { c.If0name = "net0123456789abc" }, "if0name"},
+		{"if0name with slash", false, func(c *NetConf) { c.If0name = "net/1" }, "if0name"},
+		{"small mtu", false, func(c *NetConf) { c.Mtu = 67 }, "mtu"},
+		{"large mtu", false, func(c *NetConf) { c.Mtu = 9217 }, "mtu"},
+		{"relative socketDir", false, func(c *NetConf) { c.SocketDir = "run/{{.PodUID}}" }, "socketDir"},
+		{"bad socketDir template", false, func(c *NetConf) { c.SocketDir = "/run/{{.PodUID" }, "socketDir"},
+		{"negative uid", false, func(c *NetConf) { c.SocketConf.Uid = intPtr(-1) }, "socket.uid"},
+		{"negative gid", false, func(c *NetConf) { c.SocketConf.Gid = intPtr(-1) }, "socket.gid"},
+		{"bad socket mode", false, func(c *NetConf) { c.SocketConf.Mode = "0999" }, "socket.mode"},
+		{"bad selinuxContext", false, func(c *NetConf) { c.SocketConf.SELinuxContext = "container_file_t" }, "socket.selinuxContext"},
+		{"bad configDelivery", false, func(c *NetConf) { c.ConfigDelivery = "mail" }, "configDelivery"},
+		{"annotation without apiServer", false, func(c *NetConf) {
+			c.ConfigDelivery, c.PodName, c.PodNamespace = "annotation", "pod", "default"
+		}, "kubernetes.apiServer"},
+		{"annotation with bad apiServer", false, func(c *NetConf) {
+			c.ConfigDelivery, c.PodName, c.PodNamespace = "annotation", "pod", "default"
+			c.Kubernetes.ApiServer = "ftp://10.0.0.1"
+		}, "kubernetes.apiServer"},
+		{"annotation without pod name", false, func(c *NetConf) {
+			c.ConfigDelivery, c.PodNamespace = "annotation", "default"
+			c.Kubernetes.ApiServer = "https://10.0.0.1:6443"
+		}, "CNI_ARGS.K8S_POD_NAME"},
+		{"annotation without namespace", false, func(c *NetConf) {
+			c.ConfigDelivery, c.PodName = "annotation", "pod"
+			c.Kubernetes.ApiServer = "https://10.0.0.1:6443"
+		}, "CNI_ARGS.K8S_POD_NAMESPACE"},
+		{"negative linkUpTimeout", false, func(c *NetConf) { c.LinkUpTimeout = -1 }, "linkUpTimeout"},
+		{"large linkUpTimeout", false, func(c *NetConf) { c.LinkUpTimeout = 301 }, "linkUpTimeout"},
+		{"linkUpTimeout with annotation", false, func(c *NetConf) {
+			c.ConfigDelivery, c.PodName, c.PodNamespace = "annotation", "pod", "default"
+			c.Kubernetes.ApiServer = "https://10.0.0.1:6443"
+			c.LinkUpTimeout = 10
+		}, "linkUpTimeout"},
+		{"linkUpTimeout with ovs-dpdk", true, func(c *NetConf) { c.LinkUpTimeout = 10 }, "linkUpTimeout"},
+		{"no host engine", false, func(c *NetConf) { c.HostConf.Engine = "" }, "host.engine"},
+		{"unknown host engine", false, func(c *NetConf) { c.HostConf.Engine = "linux" }, "host.engine"},
+		{"iftype not supported by engine", false, func(c *NetConf) { c.HostConf.IfType = "vhostuser" }, "host.iftype"},
+		{"netType not supported by engine", true, func(c *NetConf) {
+			c.HostConf.NetType = "bridge"
+			c.HostConf.BridgeConf.BridgeId = 4
+		}, "host.netType"},
+		{"no host memif role", false, func(c *NetConf) { c.HostConf.MemifConf.Role = "" }, "host.memif.role"},
+		{"bad host memif role", false, func(c *NetConf) { c.HostConf.MemifConf.Role = "primary" }, "host.memif.role"},
+		{"bad host memif mode", false, func(c *NetConf) { c.HostConf.MemifConf.Mode = "raw" }, "host.memif.mode"},
+		{"bad host mac", false, func(c *NetConf) { c.HostConf.Mac = "02:00:00:00:00" }, "host.mac"},
+		{"multicast host mac", false, func(c *NetConf) { c.HostConf.Mac = "01:00:5e:00:00:01" }, "host.mac"},
+		{"bad vhost mode", true, func(c *NetConf) { c.HostConf.VhostConf.Mode = "both" }, "host.vhost.mode"},
+		{"bridgeId out of range", false, func(c *NetConf) { c.HostConf.BridgeConf.BridgeId = 0 }, "host.bridge.bridgeId"},
+		{"vlanId out of range", false, func(c *NetConf) { c.HostConf.BridgeConf.VlanId = 4095 }, "host.bridge.vlanId"},
+		{"duplicate memif id", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1}, {Id: 1}}
+		}, "host.memif.interfaces[1].id"},
+		{"duplicate memif name", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1, Name: "a"}, {Id: 2, Name: "a"}}
+		}, "host.memif.interfaces[1].name"},
+		{"long memif name", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1, Name: "net0123456789abc"}}
+		}, "host.memif.interfaces[0].name"},
+		{"bad memif interface mode", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1, Mode: "raw"}}
+		}, "host.memif.interfaces[0].mode"},
+		{"too many rx queues", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1, RxQueues: 256}}
+		}, "host.memif.interfaces[0].rxQueues"},
+		{"too many tx queues", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1, TxQueues: -1}}
+		}, "host.memif.interfaces[0].txQueues"},
+		{"memif interfaces on vhostuser", true, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1}}
+		}, "host.memif.interfaces"},
+		{"unknown container engine", false, func(c *NetConf) { c.ContainerConf.Engine = "linux" }, "container.engine"},
+		{"container iftype mismatch", false, func(c *NetConf) { c.ContainerConf.IfType = "vhostuser" }, "container.iftype"},
+		{"bad container netType", false, func(c *NetConf) { c.ContainerConf.NetType = "l3" }, "container.netType"},
+		{"same memif role", false, func(c *NetConf) { c.ContainerConf.MemifConf.Role = "master" }, "container.memif.role"},
+		{"memif mode mismatch", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Mode = "ethernet"
+			c.ContainerConf.MemifConf.Mode = "ip"
+		}, "container.memif.mode"},
+		{"memif ids mismatch", false, func(c *NetConf) {
+			c.HostConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1}, {Id: 2}}
+			c.ContainerConf.MemifConf.Interfaces = []MemifIfConf{{Id: 1}, {Id: 3}}
+		}, "container.memif.interfaces"},
+		{"same vhost mode", true, func(c *NetConf) { c.ContainerConf.VhostConf.Mode = "client" }, "container.vhost.mode"},
+		{"unnumbered on bridge", false, func(c *NetConf) { c.HostConf.L3Conf.Unnumbered = "loop0" }, "host.l3.unnumbered"},
+		{"unnumbered in container", false, func(c *NetConf) {
+			c.HostConf.NetType = "interface"
+			c.ContainerConf.L3Conf.Unnumbered = "loop0"
+		}, "container.l3.unnumbered"},
+		{"bad macPolicy", false, func(c *NetConf) { c.MacPolicy = "fixed" }, "macPolicy"},
+		{"explicit without host mac", false, func(c *NetConf) {
+			c.MacPolicy = MacPolicyExplicit
+			c.ContainerConf.Mac = "02:00:00:00:00:02"
+		}, "host.mac"},
+		{"explicit without container mac", false, func(c *NetConf) {
+			c.MacPolicy = MacPolicyExplicit
+			c.HostConf.Mac = "02:00:00:00:00:01"
+		}, "container.mac"},
+		{"runtime without mac", false, func(c *NetConf) { c.MacPolicy = MacPolicyRuntime }, "runtimeConfig.mac"},
+		{"host mac on ovs-dpdk", true, func(c *NetConf) { c.HostConf.Mac = "02:00:00:00:00:01" }, "host.mac"},
+		{"explicit with host mac on ovs-dpdk", true, func(c *NetConf) {
+			c.MacPolicy = MacPolicyExplicit
+			c.HostConf.Mac = "02:00:00:00:00:01"
+			c.ContainerConf.Mac = "02:00:00:00:00:02"
+		}, "host.mac"},
+		{"explicit without container mac on ovs-dpdk", true, func(c *NetConf) { c.MacPolicy = MacPolicyExplicit }, "container.mac"},
+		{"bad runtime ip", false, func(c *NetConf) { c.RuntimeConfig.IPs = []string{"10.1.1.5"} }, "runtimeConfig.ips[0]"},
+		{"relative socketPath", false, func(c *NetConf) { c.RuntimeConfig.SocketPath = "memif.sock" }, "runtimeConfig.socketPath"},
+		{"relative CNIDeviceInfoFile", false, func(c *NetConf) {
+			c.RuntimeConfig.CNIDeviceInfoFile = "device-info.json"
+		}, "runtimeConfig.CNIDeviceInfoFile"},
+	}
+
+	for _, test := range tests {
+		conf := validConf()
+		if test.ovs {
+			conf = validOvsConf()
+		}
+		test.modify(conf)
+
+		fields := errorFields(t, conf.Validate())
+		if reflect.DeepEqual(fields, []string{test.field}) == false {
+			t.Errorf("%s: got errors for %v, want %s", test.name, fields, test.field)
+		}
+	}
+}
+
+// Every invalid field is reported at once.
+func TestValidateAllErrors(t *testing.T) {
+	conf := validConf()
+	conf.If0name = ""
+	conf.Mtu = 10
+	conf.HostConf.MemifConf.Role = "primary"
+
+	fields := errorFields(t, conf.Validate())
+	want := []string{"if0name", "mtu", "host.memif.role"}
+	if reflect.DeepEqual(fields, want) == false {
+		t.Errorf("got errors for %v, want %v", fields, want)
+	}
+}
+
+func TestCheckUnknownFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		fields []string
+	}{
+		{"known fields", `{"cniVersion": "1.0.0", "name": "n", "type": "userspace", "if0name": "net1",
+			"host": {"engine": "vpp", "iftype": "memif", "memif": {"role": "master", "interfaces": [{"id": 1}]}},
+			"runtimeConfig": {"mac": "02:00:00:00:00:01"}}`, nil},
+		{"ipam, prevResult and args belong to others", `{"name": "n",
+			"ipam": {"type": "host-local", "subnet": "10.1.1.0/24"},
+			"prevResult": {"interfaces": []}, "args": {"cni": {"foo": "bar"}}}`, nil},
+		{"top level", `{"name": "n", "bogus": 1}`, []string{"bogus"}},
+		{"misspelled", `{"name": "n", "ifName": "net1"}`, []string{"ifName"}},
+		{"nested", `{"host": {"memif": {"roll": "master"}}}`, []string{"host.memif.roll"}},
+		{"in a list", `{"host": {"memif": {"interfaces": [{"id": 1}, {"id": 2, "queues": 2}]}}}`,
+			[]string{"host.memif.interfaces[1].queues"}},
+		{"several, sorted", `{"zeta": 1, "container": {"bogus": 1}, "alpha": 1}`,
+			[]string{"alpha", "container.bogus", "zeta"}},
+	}
+
+	for _, test := range tests {
+		fields := errorFields(t, CheckUnknownFields([]byte(test.config)))
+		if reflect.DeepEqual(fields, test.fields) == false {
+			t.Errorf("%s: got unknown fields %v, want %v", test.name, fields, test.fields)
+		}
+	}
+
+	if err := CheckUnknownFields([]byte("{")); err == nil {
+		t.Errorf("CheckUnknownFields() accepted invalid json")
+	}
+}